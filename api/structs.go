@@ -1,11 +1,53 @@
 package api
 
+import "encoding/json"
+
 type DataImport struct {
 	RaceUrl string `json:"raceUrl"`
 }
 
 type RacerMerge struct {
 	RacerId string `json:"racerId"`
+	Force   bool   `json:"force,omitempty"`
+}
+
+type RacerMergeConflict struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	RacePath    string `json:"race,omitempty"`
+}
+
+type RacerMergePreview struct {
+	Racer        Racer                `json:"racer"`
+	MergedRacer  Racer                `json:"mergedRacer"`
+	ResultsMoved int                  `json:"resultsMoved"`
+	Conflicts    []RacerMergeConflict `json:"conflicts"`
+	CanMerge     bool                 `json:"canMerge"`
+	CanForce     bool                 `json:"canForce"`
+}
+
+type RacerMergeHistory struct {
+	Id          string `json:"id"`
+	Racer       Racer  `json:"racer"`
+	MergedRacer Racer  `json:"mergedRacer"`
+	Merged      string `json:"merged"`
+	Undone      string `json:"undone,omitempty"`
+	UndoPath    string `json:"undo,omitempty"`
+}
+
+type RacerMergeHistoryFeed struct {
+	Merges []RacerMergeHistory `json:"merges"`
+}
+
+type RacerSplit struct {
+	ResultIds []string `json:"resultIds"`
+	RacerId   string   `json:"racerId,omitempty"`
+}
+
+type RacerMergeResult struct {
+	Racer         Racer  `json:"racer"`
+	MergedRacerId string `json:"mergedRacerId"`
+	ResultsMoved  int    `json:"resultsMoved"`
 }
 
 type RaceGroupAddRace struct {
@@ -25,6 +67,8 @@ type RaceGroup struct {
 	DistanceUnit string `json:"distanceUnit"`
 	SelfPath     string `json:"self"`
 	RacesPath    string `json:"races"`
+	Deleted      string `json:"deleted,omitempty"`
+	RestorePath  string `json:"restore,omitempty"`
 }
 
 type Race struct {
@@ -34,6 +78,8 @@ type Race struct {
 	ResultsPath   string `json:"results"`
 	Date          string `json:"date"`
 	RaceGroupPath string `json:"raceGroup,omitempty"`
+	Deleted       string `json:"deleted,omitempty"`
+	RestorePath   string `json:"restore,omitempty"`
 }
 
 type Racer struct {
@@ -42,6 +88,75 @@ type Racer struct {
 	ResultsPath string `json:"results"`
 	ProfilePath string `json:"profile"`
 	MergePath   string `json:"merge"`
+	MergesPath  string `json:"merges"`
+	SplitPath   string `json:"split"`
+	AliasesPath string `json:"aliases"`
+}
+
+type RacerAlias struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	SelfPath  string `json:"self"`
+	RacerPath string `json:"racer"`
+}
+
+type RacerAliasCreate struct {
+	Name string `json:"name"`
+}
+
+type RacerAliasFeed struct {
+	Aliases []RacerAlias `json:"aliases"`
+}
+
+type RacerMatch struct {
+	Name            string `json:"name"`
+	Reason          string `json:"reason"`
+	FirstNameReason string `json:"firstNameReason"`
+	LastNameReason  string `json:"lastNameReason"`
+	Racer           Racer  `json:"racer"`
+}
+
+type DuplicateRacers struct {
+	Racer      Racer    `json:"racer"`
+	Duplicate  Racer    `json:"duplicate"`
+	Score      float64  `json:"score"`
+	Evidence   []string `json:"evidence"`
+	AcceptPath string   `json:"accept"`
+}
+
+type DuplicateRacersFeed struct {
+	Duplicates []DuplicateRacers `json:"duplicates"`
+}
+
+type RacerMatchFeed struct {
+	Matches []RacerMatch `json:"matches"`
+}
+
+type RacerSearchResult struct {
+	Name  string   `json:"name"`
+	Match string   `json:"match"`
+	Races int      `json:"races"`
+	Sex   string   `json:"sex,omitempty"`
+	Clubs []string `json:"clubs"`
+	Racer Racer    `json:"racer"`
+}
+
+type RacerSuggestion struct {
+	Name  string `json:"name"`
+	Races int    `json:"races"`
+	Racer Racer  `json:"racer"`
+}
+
+type RacerSuggestionFeed struct {
+	Suggestions []RacerSuggestion `json:"suggestions"`
+}
+
+type RacerSearchFeed struct {
+	Racers       []RacerSearchResult `json:"racers"`
+	Total        int                 `json:"total"`
+	SelfPath     string              `json:"self"`
+	NextPath     string              `json:"next,omitempty"`
+	PreviousPath string              `json:"previous,omitempty"`
 }
 
 type RacerProfile struct {
@@ -53,14 +168,21 @@ type RacerProfile struct {
 }
 
 type RaceResults struct {
-	Racers  map[string]Racer `json:"racers"`
-	Races   map[string]Race  `json:"races"`
-	Results []RaceResult     `json:"results"`
+	Racers       map[string]Racer `json:"racers"`
+	Races        map[string]Race  `json:"races"`
+	Results      []RaceResult     `json:"results"`
+	Ranking      string           `json:"ranking,omitempty"`
+	Total        *int             `json:"total,omitempty"`
+	SelfPath     string           `json:"self,omitempty"`
+	NextPath     string           `json:"next,omitempty"`
+	PreviousPath string           `json:"previous,omitempty"`
 }
 
 type RaceResult struct {
+	Id                  string `json:"id"`
 	Name                string `json:"name"`
 	Time                string `json:"time"`
+	TimeMs              int    `json:"timeMs"`
 	Position            int    `json:"position"`
 	SexPosition         int    `json:"sexPosition"`
 	AgeCategoryPosition int    `json:"ageCategoryPosition"`
@@ -68,15 +190,76 @@ type RaceResult struct {
 	RaceID              string `json:"raceId"`
 	BibNumber           string `json:"bibNumber"`
 	AgeCategory         string `json:"ageCategory"`
+	Bracket             string `json:"bracket"`
 	Sex                 string `json:"sex"`
 	Club                string `json:"club,omitempty"`
 	ChipTime            string `json:"chipTime,omitempty"`
+	ChipTimeMs          int    `json:"chipTimeMs,omitempty"`
+	//the places as published, when the results are placed again by chip time
+	GunPosition            int `json:"gunPosition,omitempty"`
+	GunSexPosition         int `json:"gunSexPosition,omitempty"`
+	GunAgeCategoryPosition int `json:"gunAgeCategoryPosition,omitempty"`
 }
 
 type RaceFeed struct {
-	Races []Race `json:"races"`
+	Races        []Race `json:"races"`
+	SelfPath     string `json:"self,omitempty"`
+	NextPath     string `json:"next,omitempty"`
+	PreviousPath string `json:"previous,omitempty"`
 }
 
 type RaceGroupFeed struct {
 	RaceGroups []RaceGroup `json:"raceGroups"`
 }
+
+type TrashFeed struct {
+	Races      []Race      `json:"races"`
+	RaceGroups []RaceGroup `json:"raceGroups"`
+}
+
+type AgeCategory struct {
+	Id          string             `json:"id"`
+	Name        string             `json:"name"`
+	MinAge      int                `json:"minAge"`
+	MaxAge      int                `json:"maxAge"`
+	Aliases     []AgeCategoryAlias `json:"aliases"`
+	SelfPath    string             `json:"self"`
+	AliasesPath string             `json:"aliasesPath"`
+}
+
+type AgeCategoryAlias struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	SelfPath string `json:"self"`
+}
+
+type AgeCategoryFeed struct {
+	AgeCategories []AgeCategory `json:"ageCategories"`
+}
+
+type AgeCategoryCreate struct {
+	Name    string   `json:"name"`
+	MinAge  int      `json:"minAge"`
+	MaxAge  int      `json:"maxAge"`
+	Aliases []string `json:"aliases"`
+}
+
+type AgeCategoryAliasCreate struct {
+	Name string `json:"name"`
+}
+
+type AuditEntry struct {
+	Id         string          `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Entity     string          `json:"entity"`
+	EntityId   string          `json:"entityId"`
+	EntityPath string          `json:"entityPath,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Created    string          `json:"created"`
+}
+
+type AuditFeed struct {
+	Entries []AuditEntry `json:"entries"`
+}
@@ -15,7 +15,7 @@ import (
 var _ = log.Print
 
 type DataImportResource struct {
-	Db          database.Db
+	Db          database.Store
 	RaceFetcher RaceFetcher
 }
 
@@ -37,19 +37,45 @@ func (r *DataImportResource) DoImport(res http.ResponseWriter, req *http.Request
 		return
 	}
 
-	if r.Db.HasRaceBeenImported(dataimport.RaceUrl) {
+	imported, err := r.Db.HasRaceBeenImported(dataimport.RaceUrl)
+
+	if err != nil {
+		feed.HandleError(err, res)
+		return
+	}
+
+	if imported {
 		http.Error(res, "Race Already Imported", http.StatusBadRequest)
 		return
 	}
 
-	tasks := r.Db.GetPendingImportTasks()
+	tasks, err := r.Db.GetPendingImportTasks()
+
+	if err != nil {
+		feed.HandleError(err, res)
+		return
+	}
 
 	if len(tasks) > 0 {
 		http.Error(res, "Server is busy processing another import", http.StatusConflict)
 		return
 	}
 
-	importTask, _ := r.Db.CreateImportTask(dataimport.RaceUrl)
+	var importTask database.ImportTask
+
+	err = r.Db.Transaction(func(tx database.Store) error {
+		var err error
+		if importTask, err = tx.CreateImportTask(dataimport.RaceUrl); err != nil {
+			return err
+		}
+		return feed.RecordAudit(tx, req, "import", database.AuditImportTask, importTask.ID, nil, dataimport)
+	})
+
+	if err != nil {
+		feed.HandleError(err, res)
+		return
+	}
+
 	res.Header().Set("Content-Type", "application/json")
 	res.Header().Set("Location", feed.FormatImportTaskLocation(req, importTask.ID))
 	res.WriteHeader(http.StatusAccepted)
@@ -62,7 +88,7 @@ func (r *DataImportResource) ImportResults(task database.ImportTask) {
 	results, err := r.RaceFetcher.GetRawResults(task.SrcUrl)
 
 	if err != nil {
-		r.Db.FailedImport(task, err)
+		r.failImport(task, err)
 		return
 	}
 
@@ -70,19 +96,25 @@ func (r *DataImportResource) ImportResults(task database.ImportTask) {
 	raceDetails, err := parseResults(results)
 
 	if err != nil {
-		r.Db.FailedImport(task, err)
+		r.failImport(task, err)
 		return
 	}
 
 	_, err = r.Db.SaveRace(task, &raceDetails)
 
 	if err != nil {
-		r.Db.FailedImport(task, err)
+		r.failImport(task, err)
 		return
 	}
 
 }
 
+func (r *DataImportResource) failImport(task database.ImportTask, err error) {
+	if err := r.Db.FailedImport(task, err); err != nil {
+		log.Printf("failed to record the failure of import task %d: %s", task.ID, err)
+	}
+}
+
 func (r *DataImportResource) CheckImportStatus(res http.ResponseWriter, req *http.Request) {
 
 	vars := mux.Vars(req)
@@ -90,10 +122,16 @@ func (r *DataImportResource) CheckImportStatus(res http.ResponseWriter, req *htt
 	taskId, err := strconv.Atoi(vars["id"])
 
 	if err != nil {
-		http.Error(res, err.Error(), 404)
+		feed.HandleError(feed.ErrNotFound, res)
+		return
 	}
 
-	task, _ := r.Db.GetImportTask(taskId)
+	task, err := r.Db.GetImportTask(taskId)
+
+	if err != nil {
+		feed.HandleError(err, res)
+		return
+	}
 
 	if task.Status == "pending" {
 		res.Header().Set("Content-Type", "application/json")
@@ -130,13 +130,13 @@ func parseResults(htmlresult []byte) (model.RaceDetails, error) {
 
 	const position = `^[ ]*(?P<position>\d+)`
 	const bibName = `[ ]+(?P<bib_number>\d+)[ ]+(?P<name>[\D\(\)]+)`
-	const time = `(?P<time>[\:\d]+)`
-	const chiptime = `(?P<chiptime>[\:\d]+|[ ]+)`
+	const time = `(?P<time>[\:\d]+(?:\.\d+)?)`
+	const chiptime = `(?P<chiptime>[\:\d]+(?:\.\d+)?|[ ]+)`
 	const pace = `(?P<pace>[\:\d]+|[ ]+)`
 	const spaceOrMore = `[ ]+`
 	const sexPosition = `[\(]?(?P<sex_pos>\d+)[\)]?`
 	const sex = `(?P<sex>M|F|W|P)`
-	const category = `(?P<category>A|-19|U20|\<20|NOAGE|70\+|80\+|\d\d-\d\d|)`
+	const category = `(?P<category>[A-Za-z]+(?:\d+(?:-\d+|\+)?)?|-\d+|\<\d+|\d+-\d+|\d+\+|)`
 	const categoryPosition = `((?P<category_position>\d+)(\/[\d]+)?)`
 
 	const clubNameRegEx = `[^\(]*\((?P<club>\w{2,4})\)`
@@ -231,6 +231,19 @@ func parseResults(htmlresult []byte) (model.RaceDetails, error) {
 				return model.RaceDetails{}, fmt.Errorf("Failed to find category in ''%s'", raceRows[i])
 			}
 
+			timeMs, err := model.ParseTime(md["time"])
+			if err != nil {
+				return model.RaceDetails{}, fmt.Errorf("Failed to parse time in '%s'", raceRows[i])
+			}
+
+			chipTimeMs := 0
+			if len(strings.TrimSpace(md["chiptime"])) > 0 {
+				chipTimeMs, err = model.ParseTime(md["chiptime"])
+				if err != nil {
+					return model.RaceDetails{}, fmt.Errorf("Failed to parse chip time in '%s'", raceRows[i])
+				}
+			}
+
 			//map is based on position.. if the same position exists twice it will be overrwriten
 			raceResultsMap[p] = model.Racer{
 				Position:            p,
@@ -238,11 +251,13 @@ func parseResults(htmlresult []byte) (model.RaceDetails, error) {
 				BibNumber:           md["bib_number"],
 				Club:                runnersClubName,
 				Time:                md["time"],
+				TimeMs:              timeMs,
 				Sex:                 md["sex"],
 				SexPosition:         sp,
 				AgeCategory:         md["category"],
 				AgeCategoryPosition: ap,
 				ChipTime:            md["chiptime"],
+				ChipTimeMs:          chipTimeMs,
 			}
 		}
 	}
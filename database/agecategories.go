@@ -0,0 +1,270 @@
+package database
+
+import (
+	"strings"
+)
+
+var ErrInvalidAgeCategory = newError(KindValidation, "Age category needs a name and a minimum age no greater than the maximum age")
+var ErrAgeCategoryExists = newError(KindConflict, "Age category name is already in use")
+var ErrAgeCategoryInUse = newError(KindConflict, "Age category has race results")
+
+//ageCategoryLookup finds categories by their normalized name or alias
+type ageCategoryLookup map[string]AgeCategory
+
+func normalizeAgeCategoryName(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+func (l ageCategoryLookup) find(name string) (AgeCategory, bool) {
+	category, ok := l[normalizeAgeCategoryName(name)]
+	return category, ok
+}
+
+//loadAgeCategoryLookup indexes every category by name and alias.  Names win
+//over aliases when the two collide.
+func (db *Db) loadAgeCategoryLookup() (ageCategoryLookup, error) {
+	categories := []AgeCategory{}
+	if err := db.orm.Order("id asc").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	aliases := []AgeCategoryAlias{}
+	if err := db.orm.Order("id asc").Find(&aliases).Error; err != nil {
+		return nil, err
+	}
+
+	return newAgeCategoryLookup(categories, aliases), nil
+}
+
+//newAgeCategoryLookup indexes the categories, given in id order, by name and alias
+func newAgeCategoryLookup(categories []AgeCategory, aliases []AgeCategoryAlias) ageCategoryLookup {
+	lookup := ageCategoryLookup{}
+	byID := map[int]AgeCategory{}
+
+	for i := range categories {
+		byID[categories[i].ID] = categories[i]
+		key := normalizeAgeCategoryName(categories[i].Name)
+		if _, ok := lookup[key]; !ok {
+			lookup[key] = categories[i]
+		}
+	}
+
+	for i := range aliases {
+		key := normalizeAgeCategoryName(aliases[i].Name)
+		if _, ok := lookup[key]; ok {
+			continue
+		}
+		if category, ok := byID[aliases[i].AgeCategoryID]; ok {
+			lookup[key] = category
+		}
+	}
+
+	return lookup
+}
+
+//FindAgeCategory returns the category with the name or alias.  Case and
+//surrounding spaces are ignored.
+func (db *Db) FindAgeCategory(name string) (AgeCategory, error) {
+	lookup, err := db.loadAgeCategoryLookup()
+
+	if err != nil {
+		return AgeCategory{}, wrapError("FindAgeCategory", err)
+	}
+
+	if category, ok := lookup.find(name); ok {
+		return category, nil
+	}
+
+	return AgeCategory{}, wrapError("FindAgeCategory", ErrRecordNotFoundError)
+}
+
+//GetAgeCategories returns the categories, youngest first
+func (db *Db) GetAgeCategories() ([]AgeCategory, error) {
+	categories := []AgeCategory{}
+	if err := db.orm.Order("min_age asc, max_age asc, id asc").Find(&categories).Error; err != nil {
+		return categories, wrapError("GetAgeCategories", err)
+	}
+	return categories, nil
+}
+
+func (db *Db) GetAgeCategory(id int) (AgeCategory, error) {
+	category := AgeCategory{}
+	if err := db.orm.First(&category, id).Error; err != nil {
+		return category, wrapError("GetAgeCategory", err)
+	}
+	return category, nil
+}
+
+//GetAgeCategoryAliases returns the aliases of every category
+func (db *Db) GetAgeCategoryAliases() ([]AgeCategoryAlias, error) {
+	aliases := []AgeCategoryAlias{}
+	if err := db.orm.Order("name asc").Find(&aliases).Error; err != nil {
+		return aliases, wrapError("GetAgeCategoryAliases", err)
+	}
+	return aliases, nil
+}
+
+func (db *Db) GetAliasesForAgeCategory(categoryID int) ([]AgeCategoryAlias, error) {
+	aliases := []AgeCategoryAlias{}
+	if err := db.orm.Where("age_category_id = ?", categoryID).Order("name asc").Find(&aliases).Error; err != nil {
+		return aliases, wrapError("GetAliasesForAgeCategory", err)
+	}
+	return aliases, nil
+}
+
+//CreateAgeCategory adds a category and its aliases.  The names can't already be used by another category or alias.
+func (db *Db) CreateAgeCategory(name string, minAge int, maxAge int, aliases []string) (AgeCategory, error) {
+	category := AgeCategory{Name: strings.TrimSpace(name), MinAge: minAge, MaxAge: maxAge}
+
+	if err := validateAgeCategory(category); err != nil {
+		return category, wrapError("CreateAgeCategory", err)
+	}
+
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.checkAgeCategoryNameIsFree(category.Name, 0); err != nil {
+			return err
+		}
+
+		if err := tx.orm.Create(&category).Error; err != nil {
+			return err
+		}
+
+		for i := range aliases {
+			if _, err := tx.CreateAgeCategoryAlias(category, aliases[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return category, wrapError("CreateAgeCategory", err)
+}
+
+func (db *Db) UpdateAgeCategory(id int, name string, minAge int, maxAge int) (AgeCategory, error) {
+	category, err := db.GetAgeCategory(id)
+
+	if err != nil {
+		return category, wrapError("UpdateAgeCategory", err)
+	}
+
+	category.Name = strings.TrimSpace(name)
+	category.MinAge = minAge
+	category.MaxAge = maxAge
+
+	if err := validateAgeCategory(category); err != nil {
+		return category, wrapError("UpdateAgeCategory", err)
+	}
+
+	err = db.transaction(func(tx *Db) error {
+		if err := tx.checkAgeCategoryNameIsFree(category.Name, category.ID); err != nil {
+			return err
+		}
+
+		if err := tx.orm.Save(&category).Error; err != nil {
+			return err
+		}
+
+		//new ages change what is known about the racers in the category
+		var racerIDs []int
+		if err := tx.orm.Model(&RaceResult{}).Where("age_category_id = ?", category.ID).Group("racer_id").Pluck("racer_id", &racerIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.updateBrackets(racerIDs); err != nil {
+			return err
+		}
+		return tx.touchRacers(racerIDs)
+	})
+
+	return category, wrapError("UpdateAgeCategory", err)
+}
+
+//DeleteAgeCategory removes a category and its aliases.  Categories with results can't be removed.
+func (db *Db) DeleteAgeCategory(id int) (AgeCategory, error) {
+	category, err := db.GetAgeCategory(id)
+
+	if err != nil {
+		return category, wrapError("DeleteAgeCategory", err)
+	}
+
+	var count int
+	if err := db.orm.Model(&RaceResult{}).Where("age_category_id = ?", category.ID).Count(&count).Error; err != nil {
+		return category, wrapError("DeleteAgeCategory", err)
+	}
+
+	if count > 0 {
+		return category, wrapError("DeleteAgeCategory", ErrAgeCategoryInUse)
+	}
+
+	err = db.transaction(func(tx *Db) error {
+		if err := tx.orm.Where("age_category_id = ?", category.ID).Delete(AgeCategoryAlias{}).Error; err != nil {
+			return err
+		}
+		return tx.orm.Delete(&category).Error
+	})
+
+	return category, wrapError("DeleteAgeCategory", err)
+}
+
+//CreateAgeCategoryAlias records another name used for the category.  Existing aliases are returned as is.
+func (db *Db) CreateAgeCategoryAlias(category AgeCategory, name string) (AgeCategoryAlias, error) {
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 {
+		return AgeCategoryAlias{}, wrapError("CreateAgeCategoryAlias", ErrInvalidAgeCategory)
+	}
+
+	aliases := []AgeCategoryAlias{}
+	if err := db.orm.Where("age_category_id = ?", category.ID).Find(&aliases).Error; err != nil {
+		return AgeCategoryAlias{}, wrapError("CreateAgeCategoryAlias", err)
+	}
+
+	for i := range aliases {
+		if normalizeAgeCategoryName(aliases[i].Name) == normalizeAgeCategoryName(name) {
+			return aliases[i], nil
+		}
+	}
+
+	if err := db.checkAgeCategoryNameIsFree(name, 0); err != nil {
+		return AgeCategoryAlias{}, wrapError("CreateAgeCategoryAlias", err)
+	}
+
+	alias := AgeCategoryAlias{AgeCategoryID: category.ID, Name: name}
+	if err := db.orm.Create(&alias).Error; err != nil {
+		return alias, wrapError("CreateAgeCategoryAlias", err)
+	}
+	return alias, nil
+}
+
+func (db *Db) DeleteAgeCategoryAlias(categoryID int, id int) (AgeCategoryAlias, error) {
+	alias := AgeCategoryAlias{}
+	if err := db.orm.Where("age_category_id = ?", categoryID).First(&alias, id).Error; err != nil {
+		return alias, wrapError("DeleteAgeCategoryAlias", err)
+	}
+
+	if err := db.orm.Delete(&alias).Error; err != nil {
+		return alias, wrapError("DeleteAgeCategoryAlias", err)
+	}
+	return alias, nil
+}
+
+func validateAgeCategory(category AgeCategory) error {
+	if len(category.Name) == 0 || category.MinAge < 0 || category.MinAge > category.MaxAge {
+		return ErrInvalidAgeCategory
+	}
+	return nil
+}
+
+//checkAgeCategoryNameIsFree makes sure no other category uses the name, as its name or an alias
+func (db *Db) checkAgeCategoryNameIsFree(name string, categoryID int) error {
+	lookup, err := db.loadAgeCategoryLookup()
+
+	if err != nil {
+		return err
+	}
+
+	if category, ok := lookup.find(name); ok && category.ID != categoryID {
+		return ErrAgeCategoryExists
+	}
+
+	return nil
+}
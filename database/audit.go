@@ -0,0 +1,75 @@
+package database
+
+import (
+	"time"
+)
+
+//The kinds of entity audit entries are recorded against
+const (
+	AuditRace        = "race"
+	AuditRaceGroup   = "racegroup"
+	AuditRacer       = "racer"
+	AuditAgeCategory = "agecategory"
+	AuditImportTask  = "importtask"
+)
+
+//AuditEntities are the kinds of entity with audit entries
+var AuditEntities = []string{AuditRace, AuditRaceGroup, AuditRacer, AuditAgeCategory, AuditImportTask}
+
+//AuditEntry records a change: who made it, what it was made to, and the
+//entity before and after the change as json
+type AuditEntry struct {
+	ID       int
+	Actor    string
+	Action   string
+	Entity   string `sql:"index"`
+	EntityID int    `sql:"index"`
+	Before   string `sql:"type:text"`
+	After    string `sql:"type:text"`
+	Created  time.Time
+}
+
+//AuditFilter narrows down the audit entries returned.  Empty fields don't filter.
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	Actor    string
+	Action   string
+	Limit    int
+}
+
+//RecordAudit saves the audit entry, stamped with the current time
+func (db *Db) RecordAudit(entry AuditEntry) (AuditEntry, error) {
+	entry.Created = time.Now()
+	if err := db.orm.Create(&entry).Error; err != nil {
+		return entry, wrapError("RecordAudit", err)
+	}
+	return entry, nil
+}
+
+//GetAuditEntries returns the entries matching the filter, newest first
+func (db *Db) GetAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	query := db.orm.Order("id desc")
+
+	if len(filter.Entity) > 0 {
+		query = query.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID > 0 {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if len(filter.Actor) > 0 {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if len(filter.Action) > 0 {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	entries := []AuditEntry{}
+	if err := query.Find(&entries).Error; err != nil {
+		return entries, wrapError("GetAuditEntries", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidBracket = newError(KindValidation, "Unknown age bracket")
+
+//Brackets are the canonical five year age brackets results are compared by,
+//whatever categories the race used
+var Brackets = []string{
+	"U20", "20-24", "25-29", "30-34", "35-39", "40-44", "45-49",
+	"50-54", "55-59", "60-64", "65-69", "70-74", "75-79", "80+",
+}
+
+//bracketAges are the youngest and oldest ages in each bracket
+var bracketAges = map[string][2]int{
+	"U20": {0, 19}, "20-24": {20, 24}, "25-29": {25, 29}, "30-34": {30, 34}, "35-39": {35, 39},
+	"40-44": {40, 44}, "45-49": {45, 49}, "50-54": {50, 54}, "55-59": {55, 59}, "60-64": {60, 64},
+	"65-69": {65, 69}, "70-74": {70, 74}, "75-79": {75, 79}, "80+": {80, 200},
+}
+
+//CanonicalBracket returns the bracket holding both ages, or an empty string
+//when the ages fall in different brackets
+func CanonicalBracket(minAge int, maxAge int) string {
+	for _, bracket := range Brackets {
+		ages := bracketAges[bracket]
+		if minAge >= ages[0] && maxAge <= ages[1] {
+			return bracket
+		}
+	}
+	return ""
+}
+
+//ParseBracket returns the canonical spelling of the bracket
+func ParseBracket(value string) (string, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if _, ok := bracketAges[value]; ok {
+		return value, nil
+	}
+	return "", ErrInvalidBracket
+}
+
+//ageOnDate is the age on the date of someone born on the birth date
+func ageOnDate(birthDate time.Time, date time.Time) int {
+	age := date.Year() - birthDate.Year()
+	if date.Month() < birthDate.Month() || (date.Month() == birthDate.Month() && date.Day() < birthDate.Day()) {
+		age--
+	}
+	return age
+}
+
+//resultBracket places a result by the racer's birth date range when it is
+//known, falling back to the ages of the result's own category
+func resultBracket(birthDates [2]time.Time, hasBirthDates bool, raceDate time.Time, category AgeCategory) string {
+	if hasBirthDates && !birthDates[0].After(birthDates[1]) {
+		return CanonicalBracket(ageOnDate(birthDates[1], raceDate), ageOnDate(birthDates[0], raceDate))
+	}
+	if category.ID != 0 && category.MaxAge > 0 {
+		return CanonicalBracket(category.MinAge, category.MaxAge)
+	}
+	return ""
+}
+
+//updateBrackets recalculates the brackets of every result of the racers.  A
+//new result can narrow a racer's birth date range, which moves their earlier
+//results into a bracket too.  The races whose results changed bracket are
+//given a new etag.
+func (db *Db) updateBrackets(racerIDs []int) error {
+	birthDates, err := db.getBirthDatesForRacers(racerIDs)
+
+	if err != nil {
+		return err
+	}
+
+	changedRaces := map[int]bool{}
+
+	for start := 0; start < len(racerIDs); start += batchSize {
+		batch := racerIDs[start:minInt(start+batchSize, len(racerIDs))]
+
+		rows, err := db.orm.Raw("SELECT race_result.id, race_result.racer_id, race_result.race_id, COALESCE(race_result.bracket, ''), race.date, COALESCE(age_category.id, 0), COALESCE(age_category.min_age, 0), COALESCE(age_category.max_age, 0) FROM race_result JOIN race ON race.id = race_result.race_id LEFT JOIN age_category ON age_category.id = race_result.age_category_id WHERE race_result.racer_id IN (?)", batch).Rows()
+
+		if err != nil {
+			return err
+		}
+
+		changed := map[string][]int{}
+
+		for rows.Next() {
+			var id, racerID, raceID int
+			var bracket string
+			var raceDate time.Time
+			var category AgeCategory
+			if err := rows.Scan(&id, &racerID, &raceID, &bracket, &raceDate, &category.ID, &category.MinAge, &category.MaxAge); err != nil {
+				rows.Close()
+				return err
+			}
+
+			dates, ok := birthDates[racerID]
+			if newBracket := resultBracket(dates, ok, raceDate, category); newBracket != bracket {
+				changed[newBracket] = append(changed[newBracket], id)
+				changedRaces[raceID] = true
+			}
+		}
+		rows.Close()
+
+		for bracket, ids := range changed {
+			for i := 0; i < len(ids); i += batchSize {
+				if err := db.orm.Exec("UPDATE race_result SET bracket=? WHERE id IN (?)", bracket, ids[i:minInt(i+batchSize, len(ids))]).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	raceIDs := make([]int, 0, len(changedRaces))
+	for id := range changedRaces {
+		raceIDs = append(raceIDs, id)
+	}
+	return db.touchRaces(raceIDs)
+}
+
+//touchRaces gives the races a new etag after their results change
+func (db *Db) touchRaces(raceIDs []int) error {
+	etag, lastUpdated := newETag(fmt.Sprint(raceIDs))
+
+	for start := 0; start < len(raceIDs); start += batchSize {
+		batch := raceIDs[start:minInt(start+batchSize, len(raceIDs))]
+		if err := db.orm.Exec("UPDATE race SET e_tag=?, last_updated=? WHERE id IN (?)", etag, lastUpdated, batch).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package database
+
+//orphanRacerQuery finds the racers without any results
+const orphanRacerQuery = "SELECT racer.id FROM racer WHERE NOT EXISTS (SELECT 1 FROM race_result WHERE race_result.racer_id = racer.id)"
+
+//DeleteOrphanRacers removes every racer left without results, along with
+//their aliases, redirects and merge history.  It returns the ids of the
+//racers removed.
+func (db *Db) DeleteOrphanRacers() ([]int, error) {
+	var orphanIDs []int
+
+	err := db.transaction(func(tx *Db) error {
+		var err error
+		if orphanIDs, err = tx.queryRacerIDs(orphanRacerQuery + " ORDER BY racer.id"); err != nil {
+			return err
+		}
+		return tx.deleteRacers(orphanIDs)
+	})
+
+	if err != nil {
+		return nil, wrapError("DeleteOrphanRacers", err)
+	}
+
+	return orphanIDs, nil
+}
+
+//orphanRacerIDs returns the racers, out of the ones given, without any results
+func (db *Db) orphanRacerIDs(racerIDs []int) ([]int, error) {
+	var orphanIDs []int
+
+	for start := 0; start < len(racerIDs); start += batchSize {
+		batch := racerIDs[start:minInt(start+batchSize, len(racerIDs))]
+		ids, err := db.queryRacerIDs(orphanRacerQuery+" AND racer.id IN (?)", batch)
+		if err != nil {
+			return nil, err
+		}
+		orphanIDs = append(orphanIDs, ids...)
+	}
+
+	return orphanIDs, nil
+}
+
+func (db *Db) queryRacerIDs(query string, values ...interface{}) ([]int, error) {
+	rows, err := db.orm.Raw(query, values...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+//deleteRacers removes the racers and everything that only makes sense while
+//they exist.  Their results must already be gone.
+func (db *Db) deleteRacers(racerIDs []int) error {
+	db.racersChanged(racerIDs)
+
+	for start := 0; start < len(racerIDs); start += batchSize {
+		batch := racerIDs[start:minInt(start+batchSize, len(racerIDs))]
+
+		if err := db.orm.Exec("DELETE FROM racer_alias WHERE racer_id IN (?)", batch).Error; err != nil {
+			return err
+		}
+
+		//racers merged into them have nowhere left to go
+		if err := db.orm.Exec("DELETE FROM racer_redirect WHERE new_racer_id IN (?)", batch).Error; err != nil {
+			return err
+		}
+
+		var mergeIDs []int
+		if err := db.orm.Model(&RacerMerge{}).Where("parent_racer_id IN (?)", batch).Pluck("id", &mergeIDs).Error; err != nil {
+			return err
+		}
+
+		if len(mergeIDs) > 0 {
+			if err := db.orm.Exec("DELETE FROM racer_merge_change WHERE racer_merge_id IN (?)", mergeIDs).Error; err != nil {
+				return err
+			}
+			if err := db.orm.Exec("DELETE FROM racer_merge WHERE id IN (?)", mergeIDs).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := db.orm.Exec("DELETE FROM racer WHERE id IN (?)", batch).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//withoutIDs returns the ids that aren't in removed
+func withoutIDs(ids []int, removed []int) []int {
+	skip := map[int]bool{}
+	for _, id := range removed {
+		skip[id] = true
+	}
+
+	kept := []int{}
+	for _, id := range ids {
+		if !skip[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
@@ -3,19 +3,26 @@ package database
 import (
 	"crypto/sha1"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/chiefwhitecloud/running-man/model"
+	"github.com/chiefwhitecloud/running-man/names"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 type Db struct {
 	orm              gorm.DB
 	ConnectionString string
+	dialect          string
+	//suggestions is the index of racer names, shared with the transactions
+	suggestions *suggestionIndex
+	//changedRacers are the racers to reindex when the transaction commits
+	changedRacers map[int]bool
 }
 
 type ImportTask struct {
@@ -27,8 +34,10 @@ type ImportTask struct {
 }
 
 type Racer struct {
-	ID      int
-	Created time.Time
+	ID          int
+	Created     time.Time
+	ETag        string
+	LastUpdated time.Time
 }
 
 type RaceGroup struct {
@@ -38,6 +47,7 @@ type RaceGroup struct {
 	DistanceUnit string `gorm:"size:1"`
 	ETag         string
 	LastUpdated  time.Time
+	Deleted      *time.Time `sql:"index"`
 }
 
 type Race struct {
@@ -49,117 +59,237 @@ type Race struct {
 	SrcUrl       string
 	ETag         string
 	LastUpdated  time.Time
+	Imported     *time.Time `sql:"index"`
+	Deleted      *time.Time `sql:"index"`
 }
 
 type RaceResult struct {
 	ID                  int
 	Name                string
+	NameKey             string `sql:"index"`
 	Position            int
 	SexPosition         int
 	AgeCategoryPosition int
 	RaceID              int `sql:"index"`
 	RacerID             int `sql:"index"`
 	AgeCategoryID       int `sql:"index"`
+	AgeCategory         AgeCategory
 	BibNumber           string
 	Time                string
+	TimeMs              int
 	ChipTime            string
+	ChipTimeMs          int
+	Bracket             string `sql:"index"`
 	Racer               Racer
 	Race                Race
 	Sex                 string
 	Club                string
+	//the places by chip time, when the results are ranked by it
+	ChipPosition            int `sql:"-"`
+	ChipSexPosition         int `sql:"-"`
+	ChipAgeCategoryPosition int `sql:"-"`
+}
+
+type RacerAlias struct {
+	ID      int
+	RacerID int    `sql:"index"`
+	Name    string `sql:"index"`
+	NameKey string `sql:"index"`
+	Created time.Time
+}
+
+//RacerNameMatch is a racer who raced under, or is known by, a name equivalent to the one searched for
+type RacerNameMatch struct {
+	RacerID     int
+	Name        string
+	Alias       bool
+	Equivalence names.Equivalence
+}
+
+type RacerRedirect struct {
+	ID         int
+	OldRacerID int `sql:"index"`
+	NewRacerID int
+	Created    time.Time
+}
+
+type RacerMerge struct {
+	ID                 int
+	ParentRacerID      int `sql:"index"`
+	MergedRacerID      int `sql:"index"`
+	MergedRacerCreated time.Time
+	Created            time.Time
+	Undone             *time.Time
+}
+
+type RacerMergeChange struct {
+	ID           int
+	RacerMergeID int `sql:"index"`
+	Kind         string
+	ItemID       int
 }
 
 type AgeCategory struct {
-	ID   int
-	Name string
+	ID     int
+	Name   string
+	MinAge int
+	MaxAge int
 }
 
-type AgeLookup struct {
-	minAge int
-	maxAge int
+type AgeCategoryAlias struct {
+	ID            int
+	AgeCategoryID int    `sql:"index"`
+	Name          string `sql:"index"`
 }
 
 type AgeResult struct {
 	RaceDate    time.Time
-	AgeCategory string
+	AgeCategory AgeCategory
 }
 
 // ErrRecordNotFoundError is an error implementation that includes the table name
-var ErrRecordNotFoundError = errors.New("Record not found")
-var ErrNoRecordsAvailable = errors.New("No records available")
-
-func (db *Db) Migrate() {
-	db.orm.AutoMigrate(&Racer{}, &Race{}, &RaceResult{}, &AgeCategory{}, &ImportTask{}, &RaceGroup{})
-
-	cats := []string{
-		"U20", "-19", "<20",
-		"20-24", "25-29", "20-29",
-		"30-34", "35-39", "30-39",
-		"40-44", "45-49", "40-49",
-		"50-54", "55-59", "50-59",
-		"60-64", "65-69", "60-69",
-		"70-74", "75-79", "70-79",
-		"70+", "80-84", "85-89",
-		"80-89", "80+", "A", "NOAGE",
-	}
-
-	for i := 0; i < len(cats); i++ {
-		cat := AgeCategory{Name: cats[i]}
-		db.orm.Create(&cat)
-	}
+var ErrRecordNotFoundError = newError(KindNotFound, "Record not found")
+var ErrNoRecordsAvailable = newError(KindNotFound, "No records available")
+var ErrMergeConflict = newError(KindConflict, "Racers have conflicting results")
 
+func (db *Db) Create() error {
+	return wrapError("Create", db.orm.CreateTable(&Racer{}, &Race{}, &RaceResult{}, &AgeCategory{}, &ImportTask{}, &RaceGroup{}, &RacerAlias{}, &RacerRedirect{}, &RacerMerge{}, &RacerMergeChange{}, &AgeCategoryAlias{}, &AuditEntry{}).Error)
 }
 
-func (db *Db) Create() {
-	db.orm.CreateTable(&Racer{}, &Race{}, &RaceResult{}, &AgeCategory{}, &ImportTask{}, &RaceGroup{})
+func (db *Db) DropAllTables() error {
+	db.resetSuggestions()
+	return wrapError("DropAllTables", db.orm.DropTableIfExists(&Racer{}, &Race{}, &RaceResult{}, &AgeCategory{}, &ImportTask{}, &RaceGroup{}, &RacerAlias{}, &RacerRedirect{}, &RacerMerge{}, &RacerMergeChange{}, &AgeCategoryAlias{}, &AuditEntry{}, &ageCategorySeedV2{}, &SchemaMigration{}).Error)
 }
 
-func (db *Db) DropAllTables() {
-	db.orm.DropTable(&Racer{}, &Race{}, &RaceResult{}, &AgeCategory{}, &ImportTask{}, &RaceGroup{})
+//ParseConnectionString returns the driver named by the connection string and
+//the data source to hand to it.  postgres:// and postgresql:// urls use
+//Postgres.  Strings starting with sqlite3:// or file:, or naming a .db or
+//.sqlite file, use SQLite.  Everything else is a MySQL DSN, optionally
+//prefixed with mysql://.
+func ParseConnectionString(connectionString string) (string, string) {
+	switch {
+	case strings.HasPrefix(connectionString, "postgres://"), strings.HasPrefix(connectionString, "postgresql://"):
+		return "postgres", connectionString
+	case strings.HasPrefix(connectionString, "sqlite3://"):
+		return "sqlite3", strings.TrimPrefix(connectionString, "sqlite3://")
+	case strings.HasPrefix(connectionString, "sqlite://"):
+		return "sqlite3", strings.TrimPrefix(connectionString, "sqlite://")
+	case strings.HasPrefix(connectionString, "file:"):
+		return "sqlite3", connectionString
+	case strings.HasSuffix(connectionString, ".db"), strings.HasSuffix(connectionString, ".sqlite"):
+		return "sqlite3", connectionString
+	case strings.HasPrefix(connectionString, "mysql://"):
+		return "mysql", strings.TrimPrefix(connectionString, "mysql://")
+	}
+	return "mysql", connectionString
 }
 
 func (db *Db) Open() error {
 
-	gormdb, err := gorm.Open("mysql", db.ConnectionString)
+	dialect, source := ParseConnectionString(db.ConnectionString)
+
+	gormdb, err := gorm.Open(dialect, source)
 	if err != nil {
-		return err
+		return wrapError("Open", err)
 	}
 	gormdb.SingularTable(true)
 
+	if dialect == "sqlite3" {
+		//sqlite allows a single writer, share one connection so concurrent
+		//imports wait their turn instead of failing with database is locked
+		gormdb.DB().SetMaxOpenConns(1)
+	}
+
+	db.dialect = dialect
+
 	db.orm = gormdb
 
+	db.suggestions = newSuggestionIndex()
+
+	return nil
+}
+
+//transaction runs fn against a Db bound to a new transaction.  The
+//transaction is committed when fn succeeds and rolled back when it fails.  A
+//Db already bound to a transaction runs fn in it.
+func (db *Db) transaction(fn func(tx *Db) error) error {
+	if db.changedRacers != nil {
+		return fn(db)
+	}
+
+	orm := db.orm.Begin()
+	if orm.Error != nil {
+		return orm.Error
+	}
+
+	tx := &Db{orm: *orm, ConnectionString: db.ConnectionString, dialect: db.dialect, suggestions: db.suggestions, changedRacers: map[int]bool{}}
+
+	if err := fn(tx); err != nil {
+		orm.Rollback()
+		return err
+	}
+
+	if err := orm.Commit().Error; err != nil {
+		return err
+	}
+
+	racerIDs := make([]int, 0, len(tx.changedRacers))
+	for id := range tx.changedRacers {
+		racerIDs = append(racerIDs, id)
+	}
+	sort.Ints(racerIDs)
+
+	db.racersChanged(racerIDs)
 	return nil
 }
 
+//Transaction runs fn against a store bound to a new transaction, committed
+//when fn succeeds and rolled back when it fails.  The store's methods make
+//their changes in that transaction.  It runs at the database's default
+//isolation level: changes to racers lock their rows first, so a change to
+//the same racers waits for the other to commit rather than failing.
+func (db *Db) Transaction(fn func(tx Store) error) error {
+	err := db.transaction(func(tx *Db) error {
+		return fn(tx)
+	})
+	return wrapError("Transaction", err)
+}
+
 //CreateImportTask creates an import task and returns the new task
 func (db *Db) CreateImportTask(url string) (ImportTask, error) {
 	race := Race{Name: "Pending", ImportStatus: "pending", SrcUrl: url, Date: time.Now(), LastUpdated: time.Now()}
 
 	if err := db.orm.Create(&race).Error; err != nil {
-		return ImportTask{}, err
+		return ImportTask{}, wrapError("CreateImportTask", err)
 	}
 
 	task := ImportTask{RaceID: race.ID, Status: "pending", SrcUrl: url}
 
 	if err := db.orm.Create(&task).Error; err != nil {
-		return task, err
+		return task, wrapError("CreateImportTask", err)
 	}
 
 	return task, nil
 }
 
-//FailedImport cleans up after a failed import
-func (db *Db) FailedImport(task ImportTask, err error) {
-	task.Status = "failed"
-	task.ErrorText = err.Error()
-	if err := db.orm.Save(&task).Error; err != nil {
-
-	}
+//FailedImport marks the task as failed and removes the pending race.  The
+//task is all that is left of the import.
+func (db *Db) FailedImport(task ImportTask, importErr error) error {
+	err := db.transaction(func(tx *Db) error {
+		task.Status = "failed"
+		task.ErrorText = importErr.Error()
+		if err := tx.orm.Save(&task).Error; err != nil {
+			return err
+		}
 
-	db.orm.Delete(&Race{}, task.RaceID)
+		if err := tx.orm.Delete(&RaceResult{}, "race_id = ?", task.RaceID).Error; err != nil {
+			return err
+		}
 
-	db.orm.Delete(&RaceResult{}, "race_id = ?", task.RaceID)
+		return tx.orm.Delete(&Race{}, task.RaceID).Error
+	})
 
+	return wrapError("FailedImport", err)
 }
 
 //CreateRaceGroup creates a new race group and returns the race group
@@ -167,24 +297,34 @@ func (db *Db) CreateRaceGroup(name string, distance string, distanceunit string)
 	etag, lastUpdated := db.CreateEtagAndLastUpdated(name)
 	raceGroup := RaceGroup{Name: name, Distance: distance, DistanceUnit: distanceunit, LastUpdated: lastUpdated, ETag: etag}
 	if err := db.orm.Save(&raceGroup).Error; err != nil {
-		return raceGroup, err
+		return raceGroup, wrapError("CreateRaceGroup", err)
 	}
 	return raceGroup, nil
 }
 
 func (db *Db) UpdateRaceGroup(id int, name string, distance string, distanceunit string) (RaceGroup, error) {
-	raceGroup, _ := db.GetRaceGroup(id)
+	raceGroup, err := db.GetRaceGroup(id)
+	if err != nil {
+		return raceGroup, wrapError("UpdateRaceGroup", err)
+	}
 	etag, lastUpdated := db.CreateEtagAndLastUpdated(name)
 	raceGroup.Name = name
 	raceGroup.Distance = distance
 	raceGroup.DistanceUnit = distanceunit
 	raceGroup.LastUpdated = lastUpdated
 	raceGroup.ETag = etag
-	db.orm.Save(&raceGroup)
+	if err := db.orm.Save(&raceGroup).Error; err != nil {
+		return raceGroup, wrapError("UpdateRaceGroup", err)
+	}
 	return raceGroup, nil
 }
 
 func (db *Db) CreateEtagAndLastUpdated(name string) (string, time.Time) {
+	return newETag(name)
+}
+
+//newETag returns a new etag for the named item and the time it was last updated
+func newETag(name string) (string, time.Time) {
 	t := time.Now()
 	h := sha1.New()
 	h.Write([]byte(name + t.String()))
@@ -192,191 +332,76 @@ func (db *Db) CreateEtagAndLastUpdated(name string) (string, time.Time) {
 	return hex.EncodeToString(bs), t
 }
 
-func (db *Db) DeleteRaceGroup(id int) (RaceGroup, error) {
-	raceGroup := RaceGroup{}
-	if db.orm.First(&raceGroup, id).RecordNotFound() {
-		return raceGroup, ErrRecordNotFoundError
-	}
+//touchRacers gives the racers a new etag after their results or names
+//change, and reindexes their names
+func (db *Db) touchRacers(racerIDs []int) error {
+	db.racersChanged(racerIDs)
 
-	races, _ := db.GetRacesForRaceGroup(raceGroup.ID)
+	etag, lastUpdated := newETag(fmt.Sprint(racerIDs))
 
-	for i, _ := range races {
-		etag, lastUpdated := db.CreateEtagAndLastUpdated(races[i].Name)
-		races[i].LastUpdated = lastUpdated
-		races[i].ETag = etag
-		db.orm.Save(&races[i])
+	for start := 0; start < len(racerIDs); start += batchSize {
+		batch := racerIDs[start:minInt(start+batchSize, len(racerIDs))]
+		if err := db.orm.Exec("UPDATE racer SET e_tag=?, last_updated=? WHERE id IN (?)", etag, lastUpdated, batch).Error; err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if err := db.orm.Delete(&raceGroup).Error; err != nil {
-		return raceGroup, err
+//DeleteRaceGroup moves the race group to the trash.  Its races keep their
+//place in the group in case it is restored.
+func (db *Db) DeleteRaceGroup(id int) (RaceGroup, error) {
+	raceGroup, err := db.GetRaceGroup(id)
+	if err != nil {
+		return raceGroup, wrapError("DeleteRaceGroup", err)
 	}
 
-	//update the etag for the newest item... this is the etag used to the list
-	if raceGroupLastUpdated, err := db.GetLastUpdatedRaceGroup(); err == nil {
-		etag, lastUpdated := db.CreateEtagAndLastUpdated(raceGroupLastUpdated.Name)
-		raceGroupLastUpdated.LastUpdated = lastUpdated
-		raceGroupLastUpdated.ETag = etag
-		db.orm.Save(&raceGroupLastUpdated)
-	}
+	err = db.transaction(func(tx *Db) error {
+		deleted := time.Now()
+		raceGroup.Deleted = &deleted
+		if err := tx.orm.Save(&raceGroup).Error; err != nil {
+			return err
+		}
+		return tx.raceGroupChanged(raceGroup)
+	})
 
-	return raceGroup, nil
+	return raceGroup, wrapError("DeleteRaceGroup", err)
 }
 
 func (db *Db) GetRaceGroup(id int) (RaceGroup, error) {
 	raceGroup := RaceGroup{}
-	if err := db.orm.First(&raceGroup, id).Error; err != nil {
-		return raceGroup, err
+	if err := db.orm.Where("deleted IS NULL").First(&raceGroup, id).Error; err != nil {
+		return raceGroup, wrapError("GetRaceGroup", err)
 	}
 	return raceGroup, nil
 }
 
-func (db *Db) GetPendingImportTasks() []ImportTask {
+func (db *Db) GetPendingImportTasks() ([]ImportTask, error) {
 	tasks := []ImportTask{}
-	db.orm.Where("status = ?", "pending").Find(&tasks)
-	return tasks
-}
-
-func (db *Db) HasRaceBeenImported(url string) bool {
-	races := []Race{}
-	db.orm.Where("src_url = ? AND import_status = ?", url, "completed").Find(&races)
-	if len(races) > 0 {
-		return true
-	} else {
-		return false
+	if err := db.orm.Where("status = ?", "pending").Find(&tasks).Error; err != nil {
+		return tasks, wrapError("GetPendingImportTasks", err)
 	}
+	return tasks, nil
 }
 
-func (db *Db) SaveRace(task ImportTask, r *model.RaceDetails) (Race, error) {
-
-	cats := []AgeCategory{}
-
-	db.orm.Find(&cats)
-
-	raceDate := time.Date(r.Year, time.Month(r.Month), r.Day, 0, 0, 0, 0, time.UTC)
-
-	race := Race{ID: task.RaceID}
-	db.orm.First(&race)
-	race.Name = r.Name
-	race.Date = raceDate
-	db.orm.Save(&race)
-
-	//save the race results information
-	for i := range r.Racers {
-
-		mRacer := r.Racers[i]
-
-		var raceResults []RaceResult
-		var racer Racer
-
-		db.orm.Where(&RaceResult{Name: mRacer.Name}).Find(&raceResults)
-
-		//find the agecategory id for the current race result
-		catId := 0
-		for i := range cats {
-			if cats[i].Name == mRacer.AgeCategory {
-				catId = cats[i].ID
-			}
-		}
-
-		if len(raceResults) == 0 {
-			//must be a new racer.. no racer with that name
-			racer = Racer{Created: time.Now()}
-			db.orm.Create(&racer)
-		} else if len(raceResults) > 0 {
-			//We have some Racer records with the same name, etc... Time to match the race result with an existing Racer in the database.
-
-			for i := range raceResults {
-				//did we already save a racer with same name and age group to this race?
-
-				//a runnner with same name already ran this race.
-				// FIX ME:  Needs to check their aliases too
-				rows, _ := db.orm.Raw("SELECT race_result.racer_id FROM race_result WHERE race_result.name = ? AND race_result.race_id = ?", mRacer.Name, race.ID).Rows()
-				defer rows.Close()
-
-				mustBeNewRacer := false
-
-				for rows.Next() {
-					var uid int
-					_ = rows.Scan(&uid)
-					mustBeNewRacer = true
-				}
-
-				if mustBeNewRacer {
-					break
-				} else {
-					//look at the racers age catgory history... does it look like a match?
-					early, late, _ := db.GetRacerBirthDates(raceResults[i].RacerID)
-					minAge, maxAge, _ := db.GetAgeRangeOnDate(early, late, raceDate)
-
-					//check to see if the race is within the same age category
-					ok, err := db.isAgeRangeWithinCatgory(maxAge, minAge, mRacer.AgeCategory)
-
-					if err != nil {
-						return race, err
-					}
-					if ok {
-						//existing racer is found
-						db.orm.Where(&Racer{ID: raceResults[i].RacerID}).Find(&racer)
-						break
-					}
-				}
-			}
-
-			//if no match found... create a new Racer
-			if racer == (Racer{}) {
-				racer = Racer{Created: time.Now()}
-				db.orm.Create(&racer)
-			}
-
-		}
-
-		result := RaceResult{
-			RaceID:              race.ID,
-			RacerID:             racer.ID,
-			Name:                mRacer.Name,
-			Position:            mRacer.Position,
-			BibNumber:           mRacer.BibNumber,
-			SexPosition:         mRacer.SexPosition,
-			AgeCategoryPosition: mRacer.AgeCategoryPosition,
-			AgeCategoryID:       catId,
-			Time:                mRacer.Time,
-			Sex:                 mRacer.Sex,
-		}
-		if len(mRacer.Club) > 0 {
-			result.Club = mRacer.Club
-		}
-
-		if len(mRacer.ChipTime) > 0 {
-			result.ChipTime = mRacer.ChipTime
-		}
-
-		db.orm.Create(&result)
-
+//HasRaceBeenImported reports whether the race has been imported.  Races in the
+//trash count, they are restored rather than imported again.
+func (db *Db) HasRaceBeenImported(url string) (bool, error) {
+	races := []Race{}
+	if err := db.orm.Where("src_url = ? AND import_status = ?", url, "completed").Find(&races).Error; err != nil {
+		return false, wrapError("HasRaceBeenImported", err)
 	}
-
-	t := time.Now()
-
-	h := sha1.New()
-	h.Write([]byte(race.Name + race.Date.String() + t.String()))
-	bs := h.Sum(nil)
-
-	race.ImportStatus = "completed"
-	race.LastUpdated = time.Now()
-	race.ETag = hex.EncodeToString(bs)
-	db.orm.Save(&race)
-
-	task.Status = "completed"
-	db.orm.Save(&task)
-
-	return race, nil
+	return len(races) > 0, nil
 }
 
 func (db *Db) GetLastUpdatedRace() (Race, error) {
 	race := Race{}
-	db.orm.Order("last_updated desc").First(&race)
+	if err := db.orm.Where("deleted IS NULL").Order("last_updated desc").First(&race).Error; err != nil && err != gorm.RecordNotFound {
+		return race, wrapError("GetLastUpdatedRace", err)
+	}
 
 	if race.Name == "" {
-		return race, ErrNoRecordsAvailable
+		return race, wrapError("GetLastUpdatedRace", ErrNoRecordsAvailable)
 	}
 
 	return race, nil
@@ -385,10 +410,12 @@ func (db *Db) GetLastUpdatedRace() (Race, error) {
 func (db *Db) GetLastUpdatedRaceGroup() (RaceGroup, error) {
 	raceGroup := RaceGroup{}
 
-	db.orm.Order("last_updated desc").First(&raceGroup)
+	if err := db.orm.Where("deleted IS NULL").Order("last_updated desc").First(&raceGroup).Error; err != nil && err != gorm.RecordNotFound {
+		return raceGroup, wrapError("GetLastUpdatedRaceGroup", err)
+	}
 
 	if raceGroup.Name == "" {
-		return raceGroup, errors.New("No race groups found")
+		return raceGroup, wrapError("GetLastUpdatedRaceGroup", ErrNoRecordsAvailable)
 	}
 
 	return raceGroup, nil
@@ -396,170 +423,325 @@ func (db *Db) GetLastUpdatedRaceGroup() (RaceGroup, error) {
 
 func (db *Db) GetRaceGroups() ([]RaceGroup, error) {
 	raceGroups := []RaceGroup{}
-	db.orm.Order("name asc").Find(&raceGroups)
+	if err := db.orm.Where("deleted IS NULL").Order("name asc").Find(&raceGroups).Error; err != nil {
+		return raceGroups, wrapError("GetRaceGroups", err)
+	}
 	return raceGroups, nil
 }
 
 func (db *Db) GetRacesForRaceGroup(raceGroupId int) ([]Race, error) {
 	races := []Race{}
-	db.orm.Where("race_group_id = ?", raceGroupId).Find(&races)
+	if err := db.orm.Where("race_group_id = ? AND deleted IS NULL", raceGroupId).Find(&races).Error; err != nil {
+		return races, wrapError("GetRacesForRaceGroup", err)
+	}
 	return races, nil
 }
 
 func (db *Db) GetRaces() ([]Race, error) {
 	races := []Race{}
-	db.orm.Order("date desc").Find(&races)
+	if err := db.orm.Where("deleted IS NULL").Order("date desc").Find(&races).Error; err != nil {
+		return races, wrapError("GetRaces", err)
+	}
 	return races, nil
 }
 
 //GetRace
 func (db *Db) GetRace(id int) (Race, error) {
 	race := Race{}
-	if db.orm.First(&race, id).RecordNotFound() {
-		return race, ErrRecordNotFoundError
+	if err := db.orm.Where("deleted IS NULL").First(&race, id).Error; err != nil {
+		return race, wrapError("GetRace", err)
 	}
 	return race, nil
 }
 
-//DeleteRace
+//DeleteRace moves the race to the trash.  Its results stay, hidden from the
+//feeds, until the race is restored or purged.
 func (db *Db) DeleteRace(id int) (Race, error) {
-	race := Race{}
-	if db.orm.First(&race, id).RecordNotFound() {
-		return race, ErrRecordNotFoundError
-	}
-
-	if err := db.orm.Delete(&race).Error; err != nil {
-		return race, err
+	race, err := db.GetRace(id)
+	if err != nil {
+		return race, wrapError("DeleteRace", err)
 	}
 
-	//update the etag for the newest item... this is the etag used to the list
-	if raceLastUpdated, err := db.GetLastUpdatedRace(); err == nil {
-		etag, lastUpdated := db.CreateEtagAndLastUpdated(raceLastUpdated.Name)
-		raceLastUpdated.LastUpdated = lastUpdated
-		raceLastUpdated.ETag = etag
-		db.orm.Save(&raceLastUpdated)
-	}
+	err = db.transaction(func(tx *Db) error {
+		deleted := time.Now()
+		race.Deleted = &deleted
+		if err := tx.orm.Save(&race).Error; err != nil {
+			return err
+		}
+		return tx.raceChanged(race)
+	})
 
-	return race, nil
+	return race, wrapError("DeleteRace", err)
 }
 
 //GetImportTask
 func (db *Db) GetImportTask(id int) (ImportTask, error) {
 	task := ImportTask{}
-	if db.orm.First(&task, id).RecordNotFound() {
-		return task, ErrRecordNotFoundError
+	if err := db.orm.First(&task, id).Error; err != nil {
+		return task, wrapError("GetImportTask", err)
 	}
 	return task, nil
 }
 
 func (db *Db) GetRacer(id int) (Racer, error) {
 	racer := Racer{}
-	if db.orm.First(&racer, id).RecordNotFound() {
-		return racer, ErrRecordNotFoundError
+	if err := db.orm.First(&racer, id).Error; err != nil {
+		return racer, wrapError("GetRacer", err)
 	}
 	return racer, nil
 }
 
-func (db *Db) MergeRacers(parentRacer Racer, racer Racer) (Racer, error) {
-	//update all race results with the new id
-	db.orm.Exec("UPDATE race_result SET racer_id=? WHERE racer_id =?", parentRacer.ID, racer.ID)
-	return parentRacer, nil
+//FindRacersForName returns the racers whose names or aliases are equivalent to the name.
+//Exact names come first, followed by aliases, nicknames and similar sounding names.
+func (db *Db) FindRacersForName(name string) ([]RacerNameMatch, error) {
+	key := names.Key(name)
+
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	candidates, err := db.findRacerNameCandidates([]string{key})
+
+	if err != nil {
+		return nil, wrapError("FindRacersForName", err)
+	}
+
+	return rankRacerNameMatches(name, candidates[key]), nil
 }
 
-func (db *Db) AddRaceToRaceGroup(raceGroup RaceGroup, race Race) (RaceGroup, error) {
+//findRacerNameCandidates returns the result names and aliases filed under each
+//of the phonetic keys.  Result names come first, oldest first, followed by the aliases.
+func (db *Db) findRacerNameCandidates(keys []string) (map[string][]RacerNameMatch, error) {
+	candidates := map[string][]RacerNameMatch{}
 
-	etag, lastUpdated := db.CreateEtagAndLastUpdated(race.Name)
-	race.LastUpdated = lastUpdated
-	race.ETag = etag
-	race.RaceGroupID = raceGroup.ID
-	db.orm.Save(&race)
+	for start := 0; start < len(keys); start += batchSize {
+		batch := keys[start:minInt(start+batchSize, len(keys))]
 
-	etag, lastUpdated = db.CreateEtagAndLastUpdated(raceGroup.Name)
-	raceGroup.LastUpdated = lastUpdated
-	raceGroup.ETag = etag
-	db.orm.Save(&raceGroup)
+		rows, err := db.orm.Raw("SELECT racer_id, name, name_key FROM race_result WHERE name_key IN (?) GROUP BY racer_id, name, name_key ORDER BY MIN(id) ASC", batch).Rows()
 
-	return raceGroup, nil
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var candidate RacerNameMatch
+			var key string
+			if err := rows.Scan(&candidate.RacerID, &candidate.Name, &key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			candidates[key] = append(candidates[key], candidate)
+		}
+		rows.Close()
+	}
+
+	for start := 0; start < len(keys); start += batchSize {
+		batch := keys[start:minInt(start+batchSize, len(keys))]
+
+		aliases := []RacerAlias{}
+		if err := db.orm.Where("name_key IN (?)", batch).Order("id asc").Find(&aliases).Error; err != nil {
+			return nil, err
+		}
+
+		for i := range aliases {
+			candidates[aliases[i].NameKey] = append(candidates[aliases[i].NameKey], RacerNameMatch{RacerID: aliases[i].RacerID, Name: aliases[i].Name, Alias: true})
+		}
+	}
+
+	return candidates, nil
 }
 
-func (db *Db) isAgeRangeWithinCatgory(minAge int, maxAge int, ageCategory string) (bool, error) {
-	catMinAge, catMaxAge, err := db.GetMinMaxAgeForCategory(ageCategory)
+//Reason explains why the racer matched: exact, alias, nickname or phonetic
+func (m RacerNameMatch) Reason() string {
+	reason := m.Equivalence.Reason()
+	if m.Alias && reason == names.ReasonExact {
+		return "alias"
+	}
+	return reason
+}
 
-	if err != nil {
-		return false, err
-	} else {
-		return minAge >= catMinAge && maxAge <= catMaxAge, nil
+func (m RacerNameMatch) rank() int {
+	switch m.Reason() {
+	case names.ReasonExact:
+		return 0
+	case "alias":
+		return 1
+	case names.ReasonNickname:
+		return 2
+	default:
+		return 3
 	}
+}
+
+//rankRacerNameMatches keeps the equivalent candidates, the strongest match for each racer, ordered by strength
+func rankRacerNameMatches(name string, candidates []RacerNameMatch) []RacerNameMatch {
+	best := map[int]int{}
+	var matches []RacerNameMatch
+
+	for i := range candidates {
+		equivalence, ok := names.Equivalent(name, candidates[i].Name)
+
+		if !ok {
+			continue
+		}
+
+		candidates[i].Equivalence = equivalence
+
+		if j, seen := best[candidates[i].RacerID]; seen {
+			if candidates[i].rank() < matches[j].rank() {
+				matches[j] = candidates[i]
+			}
+			continue
+		}
+
+		best[candidates[i].RacerID] = len(matches)
+		matches = append(matches, candidates[i])
+	}
+
+	sort.Stable(byRank(matches))
+
+	return matches
+}
+
+type byRank []RacerNameMatch
+
+func (m byRank) Len() int           { return len(m) }
+func (m byRank) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byRank) Less(i, j int) bool { return m[i].rank() < m[j].rank() }
+
+//GetRacerAliases returns the aliases recorded for the racer
+func (db *Db) GetRacerAliases(racerID int) ([]RacerAlias, error) {
+	aliases := []RacerAlias{}
+	if err := db.orm.Where("racer_id = ?", racerID).Order("name asc").Find(&aliases).Error; err != nil {
+		return aliases, wrapError("GetRacerAliases", err)
+	}
+	return aliases, nil
+}
+
+//GetRacerAlias
+func (db *Db) GetRacerAlias(id int) (RacerAlias, error) {
+	alias := RacerAlias{}
+	if err := db.orm.First(&alias, id).Error; err != nil {
+		return alias, wrapError("GetRacerAlias", err)
+	}
+	return alias, nil
+}
+
+//CreateRacerAlias records another name the racer is known by.  Existing aliases are returned as is.
+func (db *Db) CreateRacerAlias(racer Racer, name string) (RacerAlias, error) {
+	alias := RacerAlias{}
+	if err := db.orm.Where("racer_id = ? AND name = ?", racer.ID, name).First(&alias).Error; err == nil {
+		return alias, nil
+	} else if err != gorm.RecordNotFound {
+		return alias, wrapError("CreateRacerAlias", err)
+	}
+
+	alias = RacerAlias{RacerID: racer.ID, Name: name, NameKey: names.Key(name), Created: time.Now()}
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.orm.Create(&alias).Error; err != nil {
+			return err
+		}
+		return tx.touchRacers([]int{racer.ID})
+	})
+	return alias, wrapError("CreateRacerAlias", err)
+}
+
+//DeleteRacerAlias
+func (db *Db) DeleteRacerAlias(racerID int, id int) (RacerAlias, error) {
+	alias := RacerAlias{}
+	if err := db.orm.Where("racer_id = ?", racerID).First(&alias, id).Error; err != nil {
+		return alias, wrapError("DeleteRacerAlias", err)
+	}
+
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.orm.Delete(&alias).Error; err != nil {
+			return err
+		}
+		return tx.touchRacers([]int{racerID})
+	})
+	return alias, wrapError("DeleteRacerAlias", err)
+}
+
+func containsName(names []string, name string) bool {
+	for i := range names {
+		if names[i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Db) AddRaceToRaceGroup(raceGroup RaceGroup, race Race) (RaceGroup, error) {
 
+	err := db.transaction(func(tx *Db) error {
+		etag, lastUpdated := tx.CreateEtagAndLastUpdated(race.Name)
+		race.LastUpdated = lastUpdated
+		race.ETag = etag
+		race.RaceGroupID = raceGroup.ID
+		if err := tx.orm.Save(&race).Error; err != nil {
+			return err
+		}
+
+		etag, lastUpdated = tx.CreateEtagAndLastUpdated(raceGroup.Name)
+		raceGroup.LastUpdated = lastUpdated
+		raceGroup.ETag = etag
+		return tx.orm.Save(&raceGroup).Error
+	})
+
+	return raceGroup, wrapError("AddRaceToRaceGroup", err)
 }
 
+func isAgeRangeWithinCatgory(minAge int, maxAge int, category AgeCategory) bool {
+	return minAge >= category.MinAge && maxAge <= category.MaxAge
+}
+
+//GetMinMaxAgeForCategory looks up the ages of the category by its name or one of its aliases
 func (db *Db) GetMinMaxAgeForCategory(ageCategory string) (int, int, error) {
-	ageCategoryMap := map[string]*AgeLookup{
-		"U20":   &AgeLookup{5, 19},
-		"-19":   &AgeLookup{5, 19},
-		"<20":   &AgeLookup{5, 19},
-		"20-24": &AgeLookup{20, 24},
-		"25-29": &AgeLookup{25, 29},
-		"20-29": &AgeLookup{20, 29},
-		"30-34": &AgeLookup{30, 34},
-		"35-39": &AgeLookup{35, 39},
-		"30-39": &AgeLookup{30, 39},
-		"40-44": &AgeLookup{40, 44},
-		"45-49": &AgeLookup{45, 49},
-		"40-49": &AgeLookup{40, 49},
-		"50-54": &AgeLookup{50, 54},
-		"55-59": &AgeLookup{55, 59},
-		"50-59": &AgeLookup{50, 59},
-		"60-64": &AgeLookup{60, 64},
-		"65-69": &AgeLookup{65, 69},
-		"60-69": &AgeLookup{60, 69},
-		"70-74": &AgeLookup{70, 74},
-		"75-79": &AgeLookup{75, 79},
-		"70-79": &AgeLookup{70, 79},
-		"70+":   &AgeLookup{70, 100},
-		"80-84": &AgeLookup{80, 84},
-		"85-89": &AgeLookup{85, 89},
-		"80-89": &AgeLookup{80, 89},
-		"80+":   &AgeLookup{80, 100},
-		"A":     &AgeLookup{5, 100},
-		"NOAGE": &AgeLookup{5, 100},
-	}
-	if age, ok := ageCategoryMap[ageCategory]; ok {
-		return age.minAge, age.maxAge, nil
-	} else {
-		return 0, 0, errors.New("Failed to find age category " + ageCategory)
+	category, err := db.FindAgeCategory(ageCategory)
+
+	if err != nil {
+		return 0, 0, wrapError("GetMinMaxAgeForCategory", err)
 	}
+
+	return category.MinAge, category.MaxAge, nil
 }
 
 func (db *Db) GetAgeRangeOnDate(earlyBirthDate time.Time, lateBirthDate time.Time, raceDate time.Time) (int, int, error) {
+	minAge, maxAge := ageRangeOnDate(earlyBirthDate, lateBirthDate, raceDate)
+	return minAge, maxAge, nil
+}
+
+func ageRangeOnDate(earlyBirthDate time.Time, lateBirthDate time.Time, raceDate time.Time) (int, int) {
 	earlyDate := raceDate.Sub(earlyBirthDate)
 	years := earlyDate / time.Hour / 24 / 365
 
 	lateDate := raceDate.Sub(lateBirthDate)
 	minyears := lateDate / time.Hour / 24 / 365
 
-	return int(minyears), int(years), nil
+	return int(minyears), int(years)
 }
 
-func (db *Db) GetBirthDateRangeForCategory(raceDate time.Time, ageCategory string) (time.Time, time.Time, error) {
+func (db *Db) GetBirthDateRangeForCategory(raceDate time.Time, category AgeCategory) (time.Time, time.Time, error) {
+	earlyDate, lateDate := birthDateRangeForCategory(raceDate, category)
+	return earlyDate, lateDate, nil
+}
+
+func birthDateRangeForCategory(raceDate time.Time, category AgeCategory) (time.Time, time.Time) {
 	var earlyDate time.Time
 	var lateDate time.Time
 
-	catMinAge, catMaxAge, _ := db.GetMinMaxAgeForCategory(ageCategory)
-
-	earlyDate = raceDate.AddDate(-catMaxAge-1, 0, 1)
-	lateDate = earlyDate.AddDate(catMaxAge-catMinAge+1, 0, -1)
-
-	return earlyDate, lateDate, nil
+	earlyDate = raceDate.AddDate(-category.MaxAge-1, 0, 1)
+	lateDate = earlyDate.AddDate(category.MaxAge-category.MinAge+1, 0, -1)
 
+	return earlyDate, lateDate
 }
 
 func (db *Db) GetRacerNames(id int) ([]string, error) {
 
-	rows, err := db.orm.Raw(fmt.Sprintf("SELECT race_result.name FROM race_result LEFT JOIN (race) ON (race.id = race_result.race_id) WHERE race_result.racer_id = %d GROUP BY race_result.name", id)).Rows()
+	rows, err := db.orm.Raw("SELECT name FROM race_result WHERE racer_id = ? GROUP BY name ORDER BY MIN(id) ASC", id).Rows()
 
 	if err != nil {
-		log.Println(err)
+		return nil, wrapError("GetRacerNames", err)
 	}
 
 	var name string
@@ -569,60 +751,96 @@ func (db *Db) GetRacerNames(id int) ([]string, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		err := rows.Scan(&name)
-		if err != nil {
-			log.Fatal(err)
+		if err := rows.Scan(&name); err != nil {
+			return nil, wrapError("GetRacerNames", err)
 		}
 
 		results = append(results, name)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("GetRacerNames", err)
+	}
+
+	//followed by the names the racer is also known by
+	aliases, err := db.GetRacerAliases(id)
+
+	if err != nil {
+		return nil, wrapError("GetRacerNames", err)
+	}
+
+	for i := range aliases {
+		if !containsName(results, aliases[i].Name) {
+			results = append(results, aliases[i].Name)
+		}
+	}
+
 	return results, nil
 }
 
 func (db *Db) GetRacerBirthDates(id int) (time.Time, time.Time, error) {
-
-	rows, err := db.orm.Raw(fmt.Sprintf("SELECT race.date, age_category.name FROM race LEFT JOIN (race_result, age_category) ON (race_result.race_id = race.id AND age_category.id = race_result.age_category_id) WHERE race_result.racer_id = %d ORDER BY race.date ASC", id)).Rows()
+	birthDates, err := db.getBirthDatesForRacers([]int{id})
 
 	if err != nil {
-		log.Println(err)
+		return time.Time{}, time.Time{}, wrapError("GetRacerBirthDates", err)
 	}
 
-	var (
-		raceDate        time.Time
-		ageCategoryName string
-	)
+	return birthDates[id][0], birthDates[id][1], nil
+}
 
-	var results []AgeResult
+//getBirthDatesForRacers narrows down each racer's birth date from the age
+//categories they have raced in.  Racers without a category history are left out.
+func (db *Db) getBirthDatesForRacers(ids []int) (map[int][2]time.Time, error) {
+	histories := map[int][]AgeResult{}
 
-	defer rows.Close()
+	for start := 0; start < len(ids); start += batchSize {
+		batch := ids[start:minInt(start+batchSize, len(ids))]
+
+		rows, err := db.orm.Raw("SELECT race_result.racer_id, race.date, age_category.id, age_category.name, age_category.min_age, age_category.max_age FROM race_result JOIN race ON race.id = race_result.race_id JOIN age_category ON age_category.id = race_result.age_category_id WHERE race_result.racer_id IN (?) ORDER BY race.date ASC", batch).Rows()
 
-	for rows.Next() {
-		err := rows.Scan(&raceDate, &ageCategoryName)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		xx := AgeResult{
-			RaceDate:    raceDate,
-			AgeCategory: ageCategoryName,
+
+		for rows.Next() {
+			var racerID int
+			var result AgeResult
+			category := &result.AgeCategory
+			if err := rows.Scan(&racerID, &result.RaceDate, &category.ID, &category.Name, &category.MinAge, &category.MaxAge); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			histories[racerID] = append(histories[racerID], result)
 		}
+		rows.Close()
+	}
 
-		results = append(results, xx)
+	return birthDatesFromHistories(histories), nil
+}
+
+//birthDatesFromHistories narrows down the birth date of each racer from their age category history
+func birthDatesFromHistories(histories map[int][]AgeResult) map[int][2]time.Time {
+	birthDates := map[int][2]time.Time{}
+
+	for id, results := range histories {
+		low, high := birthDateRange(results)
+		birthDates[id] = [2]time.Time{low, high}
 	}
 
+	return birthDates
+}
+
+//birthDateRange intersects the birth date ranges of the age categories, oldest race first
+func birthDateRange(results []AgeResult) (time.Time, time.Time) {
 	var high time.Time
 	var low time.Time
-	var ageCat string
-	var lastRaceDate time.Time
 
 	for i := range results {
 
-		if ageCat == "" && lastRaceDate.IsZero() {
-			ageCat = results[i].AgeCategory
-			lastRaceDate = results[i].RaceDate
-			low, high, _ = db.GetBirthDateRangeForCategory(results[i].RaceDate, results[i].AgeCategory)
+		if i == 0 {
+			low, high = birthDateRangeForCategory(results[i].RaceDate, results[i].AgeCategory)
 		} else {
-			lowforCat, highforCat, _ := db.GetBirthDateRangeForCategory(results[i].RaceDate, results[i].AgeCategory)
+			lowforCat, highforCat := birthDateRangeForCategory(results[i].RaceDate, results[i].AgeCategory)
 
 			if lowforCat.After(low) {
 				low = lowforCat
@@ -635,28 +853,219 @@ func (db *Db) GetRacerBirthDates(id int) (time.Time, time.Time, error) {
 		}
 	}
 
-	return low, high, nil
+	return low, high
+}
+
+//The orders results of a race can be listed in
+const (
+	ResultSortPosition = "position"
+	ResultSortTime     = "time"
+	ResultSortChipTime = "chiptime"
+	ResultSortCategory = "category"
+)
+
+//ResultFilter narrows down the results returned.  Empty fields don't filter.
+//Name and Club match any part of the result's, ignoring case.  Ranking, Sort,
+//Offset and Limit only apply to the results of a race.
+type ResultFilter struct {
+	Bracket       string
+	From          time.Time
+	To            time.Time
+	Sex           string
+	AgeCategoryID int
+	Club          string
+	Bib           string
+	Name          string
+	Ranking       string
+	Sort          string
+	Descending    bool
+	Offset        int
+	Limit         int
+}
+
+//apply adds the filter to a query joined to race_result and race.  Results of
+//races in the trash are never included.
+func (f ResultFilter) apply(query *gorm.DB) *gorm.DB {
+	query = query.Where("race.deleted IS NULL")
+	if len(f.Bracket) > 0 {
+		query = query.Where("race_result.bracket = ?", f.Bracket)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("race.date >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("race.date <= ?", f.To)
+	}
+	if len(f.Sex) > 0 {
+		query = query.Where("UPPER(race_result.sex) = ?", strings.ToUpper(f.Sex))
+	}
+	if f.AgeCategoryID > 0 {
+		query = query.Where("race_result.age_category_id = ?", f.AgeCategoryID)
+	}
+	if len(f.Club) > 0 {
+		query = query.Where("UPPER(race_result.club) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToUpper(f.Club))+"%")
+	}
+	if len(f.Bib) > 0 {
+		query = query.Where("race_result.bib_number = ?", f.Bib)
+	}
+	if len(f.Name) > 0 {
+		query = query.Where("UPPER(race_result.name) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToUpper(f.Name))+"%")
+	}
+	return query
+}
+
+//sortColumn is what the results of a race are ordered by.  Chip time falls
+//back to the gun time for results without one.
+func (f ResultFilter) sortColumn() string {
+	switch f.Sort {
+	case ResultSortTime:
+		return "race_result.time_ms"
+	case ResultSortChipTime:
+		return "COALESCE(NULLIF(race_result.chip_time_ms, 0), race_result.time_ms)"
+	case ResultSortCategory:
+		return "race_result.age_category_position"
+	}
+	return "race_result.position"
+}
+
+//order adds the sort to a query on the results of a race.  Results without a
+//time or place to sort by come last either way.  Results ranked by chip time
+//are sorted by sortResults once they are placed.
+func (f ResultFilter) order(query *gorm.DB) *gorm.DB {
+	direction := "ASC"
+	if f.Descending {
+		direction = "DESC"
+	}
+
+	column := f.sortColumn()
+
+	return query.Order("CASE WHEN " + column + " > 0 THEN 0 ELSE 1 END ASC").
+		Order(column + " " + direction).
+		Order("race_result.position " + direction).
+		Order("race_result.id " + direction)
+}
+
+func (db *Db) GetRaceResultsForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter) ([]RaceResult, []Racer, []Race, error) {
+	var results []RaceResult
+	var racers []Racer
+
+	race, err := db.eachRaceResultForRace(raceid, startPosition, numOfRecords, filter, func(result RaceResult, race Race) error {
+		results = append(results, result)
+		racers = append(racers, Racer{ID: result.RacerID})
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, nil, wrapError("GetRaceResultsForRace", err)
+	}
+
+	return results, racers, []Race{race}, nil
+}
+
+//EachRaceResultForRace calls fn with each of the race's results, and the race.
+//The results are read a page at a time, and each page is passed on once it
+//is read so a slow fn doesn't hold a connection.  Placed by chip time, the
+//results are all read and placed before the first is passed on.
+func (db *Db) EachRaceResultForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter, fn func(result RaceResult, race Race) error) error {
+	_, err := db.eachRaceResultForRace(raceid, startPosition, numOfRecords, filter, fn)
+	return wrapError("EachRaceResultForRace", err)
+}
+
+func (db *Db) eachRaceResultForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter, fn func(result RaceResult, race Race) error) (Race, error) {
+
+	r := Race{}
+
+	if err := db.orm.Where("deleted IS NULL").First(&r, raceid).Error; err != nil {
+		return r, err
+	}
+
+	//results are placed by chip time once they are read, so they are sorted
+	//and paged then too
+	if filter.Ranking == RankChipTime {
+		results, err := db.queryRaceResults(r, filter.apply(db.raceResultQuery(r)))
+		if err != nil {
+			return r, err
+		}
+
+		race := []RaceResult{}
+		if err := db.orm.Select("id, position, sex, age_category_id, time_ms, chip_time_ms").Where("race_id = ?", r.ID).Find(&race).Error; err != nil {
+			return r, err
+		}
+		for _, result := range filter.page(withChipPlaces(results, race, startPosition), numOfRecords) {
+			if err := fn(result, r); err != nil {
+				return r, err
+			}
+		}
+		return r, nil
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = numOfRecords
+	}
+	offset := filter.Offset
+
+	//the results are read a page at a time and passed on once the page is
+	//read, so the connection isn't held while fn sends them on
+	for {
+		size := resultPageSize
+		if limit > 0 && limit < size {
+			size = limit
+		}
+
+		query := db.raceResultQuery(r)
+		if startPosition > 0 {
+			query = query.Where("race_result.position >= ?", startPosition)
+		}
+
+		page, err := db.queryRaceResults(r, filter.order(filter.apply(query)).Offset(offset).Limit(size))
+		if err != nil {
+			return r, err
+		}
+
+		for _, result := range page {
+			if err := fn(result, r); err != nil {
+				return r, err
+			}
+		}
+
+		if len(page) < size {
+			return r, nil
+		}
+
+		offset += len(page)
+		if limit > 0 {
+			if limit -= len(page); limit == 0 {
+				return r, nil
+			}
+		}
+	}
 }
 
-func (db *Db) GetRaceResultsForRace(raceid int, startPosition int, numOfRecords int) ([]RaceResult, []Racer, []Race, error) {
+//resultPageSize is the most results read at a time when they are passed on
+//as they are read
+const resultPageSize = 500
+
+//raceResultQuery selects the race's results as queryRaceResults reads them
+func (db *Db) raceResultQuery(r Race) *gorm.DB {
 
 	// XXX: Maybe a better way to do this using the ORM.  Couldn't figure it out.
 	// For now doing a manual join and populating the struct to return.  Seems like the
 	// ORM should be doing some more work here.
 
-	r := Race{}
+	return db.orm.Table("race_result").
+		Select("race_result.time, race_result.position, race_result.sex_position, race_result.age_category_position, race_result.bib_number, race_result.name, racer.id, race_result.id, race_result.sex, race_result.age_category_id, race_result.club, race_result.chip_time, race_result.time_ms, race_result.chip_time_ms, COALESCE(age_category.name, ''), COALESCE(race_result.bracket, '')").
+		Joins("join racer on race_result.racer_id = racer.id join race on race_result.race_id = race.id left join age_category on age_category.id = race_result.age_category_id").
+		Where("race_result.race_id = ?", r.ID)
+}
 
-	db.orm.Find(&r, raceid)
-
-	rows, err := db.orm.Table("race_result").
-		Select("race_result.time, race_result.position, race_result.sex_position, race_result.age_category_position, race_result.bib_number, race_result.name, racer.id, race_result.id, race_result.sex, race_result.age_category_id, race_result.club, race_result.chip_time").
-		Joins("join racer on race_result.racer_id = racer.id").
-		Where("race_result.race_id = ?", r.ID).
-		Order("race_result.position ASC").
-		Rows()
+//queryRaceResults reads the results the query selects.  The rows are closed
+//before it returns.
+func (db *Db) queryRaceResults(r Race, query *gorm.DB) ([]RaceResult, error) {
+	rows, err := query.Rows()
 
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
 
 	var (
@@ -672,22 +1081,22 @@ func (db *Db) GetRaceResultsForRace(raceid int, startPosition int, numOfRecords
 		racername           string
 		club                string
 		chiptime            string
+		timems              int
+		chiptimems          int
+		agecatname          string
+		bracket             string
 	)
 
 	var results []RaceResult
-	var races []Race
-	var racers []Racer
-	var numOfRows = 0
-	races = append(races, r)
 
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&time, &position, &sexposition, &agecategoryposition, &bibnumber, &racername, &racerid, &raceresultid, &sex, &agecat, &club, &chiptime)
+		err := rows.Scan(&time, &position, &sexposition, &agecategoryposition, &bibnumber, &racername, &racerid, &raceresultid, &sex, &agecat, &club, &chiptime, &timems, &chiptimems, &agecatname, &bracket)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
-		xx := RaceResult{
+		results = append(results, RaceResult{
 			ID:                  raceresultid,
 			Time:                time,
 			Position:            position,
@@ -697,43 +1106,80 @@ func (db *Db) GetRaceResultsForRace(raceid int, startPosition int, numOfRecords
 			RacerID:             racerid,
 			BibNumber:           bibnumber,
 			AgeCategoryID:       agecat,
+			AgeCategory:         AgeCategory{ID: agecat, Name: agecatname},
 			Name:                racername,
 			Sex:                 sex,
 			Club:                club,
 			ChipTime:            chiptime,
+			TimeMs:              timems,
+			ChipTimeMs:          chiptimems,
+			Bracket:             bracket,
+		})
+	}
+
+	return results, rows.Err()
+}
+
+//GetRaceWinners returns the results placed first overall or in their sex in
+//each of the races, by race then position
+func (db *Db) GetRaceWinners(raceIDs []int) ([]RaceResult, error) {
+	winners := []RaceResult{}
+
+	for start := 0; start < len(raceIDs); start += batchSize {
+		batch := raceIDs[start:minInt(start+batchSize, len(raceIDs))]
+
+		results := []RaceResult{}
+		if err := db.orm.Where("race_id IN (?) AND (position = 1 OR sex_position = 1)", batch).Order("race_id asc, position asc").Find(&results).Error; err != nil {
+			return winners, wrapError("GetRaceWinners", err)
 		}
+		winners = append(winners, results...)
+	}
 
-		if startPosition > 0 {
+	return winners, nil
+}
 
-			if startPosition <= xx.Position {
-				results = append(results, xx)
+//CountRaceResultsForRace returns the number of results of the race matching the filter
+func (db *Db) CountRaceResultsForRace(raceid int, filter ResultFilter) (int, error) {
+	var count int
 
-				racers = append(racers, Racer{
-					ID: racerid,
-				})
+	query := db.orm.Table("race_result").
+		Joins("join race on race_result.race_id = race.id").
+		Where("race_result.race_id = ?", raceid)
 
-				numOfRows++
-			}
+	if err := filter.apply(query).Count(&count).Error; err != nil {
+		return 0, wrapError("CountRaceResultsForRace", err)
+	}
 
-		} else {
-			results = append(results, xx)
+	return count, nil
+}
 
-			racers = append(racers, Racer{
-				ID: racerid,
-			})
+func (db *Db) GetRaceResultsForRacer(racerid uint, filter ResultFilter) ([]RaceResult, []Racer, []Race, error) {
+	var results []RaceResult
+	var races []Race
 
-			numOfRows++
-		}
+	r := Racer{}
 
-		if numOfRows == numOfRecords {
-			break
-		}
+	if err := db.orm.First(&r, racerid).Error; err != nil {
+		return nil, nil, nil, wrapError("GetRaceResultsForRacer", err)
 	}
 
-	return results, racers, races, nil
+	err := db.EachRaceResultForRacer(racerid, filter, func(result RaceResult, race Race) error {
+		results = append(results, result)
+		races = append(races, race)
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return results, []Racer{r}, races, nil
 }
 
-func (db *Db) GetRaceResultsForRacer(racerid uint) ([]RaceResult, []Racer, []Race, error) {
+//EachRaceResultForRacer calls fn with each of the racer's results, newest
+//race first, and the race it was in.  A racer has few enough results that
+//they are all read, and the connection freed, before the first is passed on.
+func (db *Db) EachRaceResultForRacer(racerid uint, filter ResultFilter, fn func(result RaceResult, race Race) error) error {
 
 	// XXX: Maybe a better way to do this using the ORM.  Couldn't figure it out.
 	// For now doing a manual join and populating the struct to return.  Seems like the
@@ -741,17 +1187,21 @@ func (db *Db) GetRaceResultsForRacer(racerid uint) ([]RaceResult, []Racer, []Rac
 
 	r := Racer{}
 
-	db.orm.Find(&r, racerid)
+	if err := db.orm.First(&r, racerid).Error; err != nil {
+		return wrapError("EachRaceResultForRacer", err)
+	}
 
-	rows, err := db.orm.Table("race_result").
-		Select("race_result.time, race_result.position, race_result.sex_position, race_result.age_category_position, race_result.bib_number, race_result.name, race.name,  race.id, race.race_group_id, race_result.id,  race_result.sex, race.date, race_result.age_category_id").
-		Joins("join race on race_result.race_id = race.id").
-		Where("race_result.racer_id = ?", r.ID).
+	query := db.orm.Table("race_result").
+		Select("race_result.time, race_result.position, race_result.sex_position, race_result.age_category_position, race_result.bib_number, race_result.name, race.name,  race.id, race.race_group_id, race_result.id,  race_result.sex, race.date, race_result.age_category_id, race_result.time_ms, race_result.chip_time, race_result.chip_time_ms, COALESCE(age_category.name, ''), COALESCE(race_result.bracket, ''), race.imported").
+		Joins("join race on race_result.race_id = race.id left join age_category on age_category.id = race_result.age_category_id").
+		Where("race_result.racer_id = ?", r.ID)
+
+	rows, err := filter.apply(query).
 		Order("race.date DESC").
 		Rows()
 
 	if err != nil {
-		log.Println(err)
+		return wrapError("EachRaceResultForRacer", err)
 	}
 
 	var (
@@ -768,20 +1218,23 @@ func (db *Db) GetRaceResultsForRacer(racerid uint) ([]RaceResult, []Racer, []Rac
 		raceDate            time.Time
 		sex                 string
 		raceGroupId         int
+		timems              int
+		chiptime            string
+		chiptimems          int
+		agecatname          string
+		bracket             string
+		imported            *time.Time
 	)
 
 	var results []RaceResult
 	var races []Race
-	var racers []Racer
-
-	racers = append(racers, r)
 
 	defer rows.Close()
 
 	for rows.Next() {
-		err := rows.Scan(&raceresulttime, &position, &sexposition, &agecategoryposition, &bibnumber, &racername, &racename, &raceid, &raceGroupId, &raceresultid, &sex, &raceDate, &agecat)
+		err := rows.Scan(&raceresulttime, &position, &sexposition, &agecategoryposition, &bibnumber, &racername, &racename, &raceid, &raceGroupId, &raceresultid, &sex, &raceDate, &agecat, &timems, &chiptime, &chiptimems, &agecatname, &bracket, &imported)
 		if err != nil {
-			log.Fatal(err)
+			return wrapError("EachRaceResultForRacer", err)
 		}
 
 		xx := RaceResult{
@@ -794,19 +1247,87 @@ func (db *Db) GetRaceResultsForRacer(racerid uint) ([]RaceResult, []Racer, []Rac
 			RacerID:             r.ID,
 			BibNumber:           bibnumber,
 			AgeCategoryID:       agecat,
+			AgeCategory:         AgeCategory{ID: agecat, Name: agecatname},
 			Name:                racername,
 			Sex:                 sex,
+			TimeMs:              timems,
+			ChipTime:            chiptime,
+			ChipTimeMs:          chiptimems,
+			Bracket:             bracket,
 		}
 
 		results = append(results, xx)
-
 		races = append(races, Race{
 			ID:          raceid,
 			Name:        racename,
 			Date:        raceDate,
 			RaceGroupID: raceGroupId,
+			Imported:    imported,
 		})
 	}
 
-	return results, racers, races, nil
+	if err := rows.Err(); err != nil {
+		return wrapError("EachRaceResultForRacer", err)
+	}
+	rows.Close()
+
+	for i := range results {
+		if err := fn(results[i], races[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//GetRaceResults returns the results across every race that match the filter,
+//oldest race first
+func (db *Db) GetRaceResults(filter ResultFilter) ([]RaceResult, []Racer, []Race, error) {
+
+	query := db.orm.Table("race_result").
+		Select("race_result.id, race_result.time, race_result.position, race_result.sex_position, race_result.age_category_position, race_result.bib_number, race_result.name, race_result.racer_id, race_result.sex, race_result.club, race_result.age_category_id, COALESCE(age_category.name, ''), race_result.time_ms, race_result.chip_time, race_result.chip_time_ms, COALESCE(race_result.bracket, ''), race.id, race.name, race.date, race.race_group_id").
+		Joins("join race on race_result.race_id = race.id left join age_category on age_category.id = race_result.age_category_id")
+
+	rows, err := filter.apply(query).
+		Order("race.date ASC, race.id ASC, race_result.position ASC").
+		Rows()
+
+	if err != nil {
+		return nil, nil, nil, wrapError("GetRaceResults", err)
+	}
+
+	defer rows.Close()
+
+	var results []RaceResult
+	var racers []Racer
+	var races []Race
+
+	seenRacers := map[int]bool{}
+	seenRaces := map[int]bool{}
+
+	for rows.Next() {
+		var result RaceResult
+		var race Race
+
+		if err := rows.Scan(&result.ID, &result.Time, &result.Position, &result.SexPosition, &result.AgeCategoryPosition, &result.BibNumber, &result.Name, &result.RacerID, &result.Sex, &result.Club,
+			&result.AgeCategoryID, &result.AgeCategory.Name, &result.TimeMs, &result.ChipTime, &result.ChipTimeMs, &result.Bracket, &race.ID, &race.Name, &race.Date, &race.RaceGroupID); err != nil {
+			return nil, nil, nil, wrapError("GetRaceResults", err)
+		}
+
+		result.RaceID = race.ID
+		result.AgeCategory.ID = result.AgeCategoryID
+		results = append(results, result)
+
+		if !seenRacers[result.RacerID] {
+			seenRacers[result.RacerID] = true
+			racers = append(racers, Racer{ID: result.RacerID})
+		}
+
+		if !seenRaces[race.ID] {
+			seenRaces[race.ID] = true
+			races = append(races, race)
+		}
+	}
+
+	return results, racers, races, wrapError("GetRaceResults", rows.Err())
 }
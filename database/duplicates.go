@@ -0,0 +1,327 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/names"
+)
+
+//DuplicateRacers is a pair of racers that look like the same person.  Accepting
+//the suggestion merges the duplicate into the racer.
+type DuplicateRacers struct {
+	Racer     Racer
+	Duplicate Racer
+	Score     float64
+	Evidence  []string
+}
+
+type racerSummary struct {
+	id    int
+	names []string
+	sex   string
+	races map[int]bool
+	clubs []string
+}
+
+//FindDuplicateRacers scans all the racers for likely duplicates.  Racers are
+//paired when their names are equivalent, their birth date ranges overlap, or
+//either is unknown, and they never ran the same race.  The pairs are scored by
+//how alike the names are, how closely the birth dates agree and whether they
+//ran for the same club, most likely first.  Results of races in the trash are
+//left out.
+func (db *Db) FindDuplicateRacers() ([]DuplicateRacers, error) {
+
+	rows, err := db.orm.Raw("SELECT race_result.racer_id, race_result.name, race_result.sex, race_result.race_id, COALESCE(race_result.club, '') FROM race_result JOIN race ON race.id = race_result.race_id WHERE race.deleted IS NULL ORDER BY race_result.racer_id ASC, race_result.id ASC").Rows()
+
+	if err != nil {
+		return nil, wrapError("FindDuplicateRacers", err)
+	}
+
+	summaries := newRacerSummaries()
+
+	for rows.Next() {
+		var (
+			racerID int
+			name    string
+			sex     string
+			raceID  int
+			club    string
+		)
+		if err := rows.Scan(&racerID, &name, &sex, &raceID, &club); err != nil {
+			rows.Close()
+			return nil, wrapError("FindDuplicateRacers", err)
+		}
+		summaries.addResult(racerID, name, sex, raceID, club)
+	}
+	rows.Close()
+
+	aliases := []RacerAlias{}
+	if err := db.orm.Find(&aliases).Error; err != nil {
+		return nil, wrapError("FindDuplicateRacers", err)
+	}
+
+	for i := range aliases {
+		summaries.addAlias(aliases[i].RacerID, aliases[i].Name)
+	}
+
+	birthDates, err := db.getBirthDatesForRacers(summaries.candidates())
+
+	if err != nil {
+		return nil, wrapError("FindDuplicateRacers", err)
+	}
+
+	return summaries.duplicates(birthDates), nil
+}
+
+//racerSummaries collects the names, sex, races and clubs of each racer, in
+//the order the racers were first seen
+type racerSummaries struct {
+	byID  map[int]*racerSummary
+	order []int
+}
+
+func newRacerSummaries() *racerSummaries {
+	return &racerSummaries{byID: map[int]*racerSummary{}}
+}
+
+func (s *racerSummaries) addResult(racerID int, name string, sex string, raceID int, club string) {
+	summary, ok := s.byID[racerID]
+	if !ok {
+		summary = &racerSummary{id: racerID, sex: sex, races: map[int]bool{}}
+		s.byID[racerID] = summary
+		s.order = append(s.order, racerID)
+	}
+	if !containsName(summary.names, name) {
+		summary.names = append(summary.names, name)
+	}
+	summary.races[raceID] = true
+	if club = strings.ToUpper(strings.TrimSpace(club)); len(club) > 0 && !containsName(summary.clubs, club) {
+		summary.clubs = append(summary.clubs, club)
+	}
+}
+
+//addAlias adds a name the racer is known by.  Racers without results are left out.
+func (s *racerSummaries) addAlias(racerID int, name string) {
+	if summary, ok := s.byID[racerID]; ok && !containsName(summary.names, name) {
+		summary.names = append(summary.names, name)
+	}
+}
+
+//blocks groups the racers by the phonetic keys of their last names.  Only
+//racers sharing a key can have equivalent names.  The keys are in the order
+//they were first seen.
+func (s *racerSummaries) blocks() (map[string][]int, []string) {
+	blocks := map[string][]int{}
+	var keys []string
+	for _, id := range s.order {
+		seen := map[string]bool{}
+		for _, name := range s.byID[id].names {
+			key := names.Key(name)
+			if len(key) == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if _, ok := blocks[key]; !ok {
+				keys = append(keys, key)
+			}
+			blocks[key] = append(blocks[key], id)
+		}
+	}
+	return blocks, keys
+}
+
+//candidates are the racers sharing a block with another, the only ones whose
+//birth dates are needed
+func (s *racerSummaries) candidates() []int {
+	blocks, keys := s.blocks()
+
+	seen := map[int]bool{}
+	var ids []int
+	for _, key := range keys {
+		if len(blocks[key]) < 2 {
+			continue
+		}
+		for _, id := range blocks[key] {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+//duplicates pairs up the racers that look like the same person, most likely
+//first.  birthDates holds the birth date range of each candidate, when known.
+func (s *racerSummaries) duplicates(birthDates map[int][2]time.Time) []DuplicateRacers {
+	summaries := s.byID
+	blocks, keys := s.blocks()
+
+	paired := map[[2]int]bool{}
+	var duplicates []DuplicateRacers
+
+	for _, key := range keys {
+		block := blocks[key]
+		for i := 0; i < len(block); i++ {
+			for j := i + 1; j < len(block); j++ {
+				a := summaries[block[i]]
+				b := summaries[block[j]]
+
+				pair := [2]int{a.id, b.id}
+				if a.id > b.id {
+					pair = [2]int{b.id, a.id}
+				}
+				if paired[pair] {
+					continue
+				}
+
+				aBirthDates, aKnown := birthDates[a.id]
+				bBirthDates, bKnown := birthDates[b.id]
+				duplicate, ok := scoreDuplicate(a, b, aBirthDates, bBirthDates, aKnown && bKnown)
+				if !ok {
+					continue
+				}
+				paired[pair] = true
+				duplicates = append(duplicates, duplicate)
+			}
+		}
+	}
+
+	sort.Stable(byScore(duplicates))
+
+	return duplicates
+}
+
+//scoreDuplicate pairs the racers when they could be the same person.  When
+//either's birth dates are unknown they can't rule the pair out, nor add to it.
+func scoreDuplicate(a *racerSummary, b *racerSummary, aBirthDates [2]time.Time, bBirthDates [2]time.Time, birthDatesKnown bool) (DuplicateRacers, bool) {
+
+	if a.sex != b.sex {
+		return DuplicateRacers{}, false
+	}
+
+	for raceID := range a.races {
+		if b.races[raceID] {
+			return DuplicateRacers{}, false
+		}
+	}
+
+	//the strongest equivalence between any of their names
+	var nameA, nameB string
+	var equivalence names.Equivalence
+	found := false
+	for _, x := range a.names {
+		for _, y := range b.names {
+			e, ok := names.Equivalent(x, y)
+			if ok && (!found || nameScore(e) > nameScore(equivalence)) {
+				nameA, nameB, equivalence, found = x, y, e, true
+			}
+		}
+	}
+
+	if !found {
+		return DuplicateRacers{}, false
+	}
+
+	duplicate := DuplicateRacers{
+		Racer:     Racer{ID: a.id},
+		Duplicate: Racer{ID: b.id},
+		Score:     nameScore(equivalence),
+		Evidence:  []string{nameEvidence(nameA, nameB, equivalence)},
+	}
+
+	if birthDatesKnown {
+		low, high := aBirthDates[0], aBirthDates[1]
+		if bBirthDates[0].After(low) {
+			low = bBirthDates[0]
+		}
+		if bBirthDates[1].Before(high) {
+			high = bBirthDates[1]
+		}
+
+		if low.After(high) {
+			return DuplicateRacers{}, false
+		}
+
+		if agreement := birthDateAgreement(aBirthDates, bBirthDates, low, high); agreement > 0 {
+			duplicate.Score += 0.3 * agreement
+			duplicate.Evidence = append(duplicate.Evidence, fmt.Sprintf("birth date ranges overlap between %s and %s", low.Format("2006-01-02"), high.Format("2006-01-02")))
+		}
+	} else {
+		duplicate.Evidence = append(duplicate.Evidence, "no age category to compare birth dates by")
+	}
+
+	var clubs []string
+	for _, club := range a.clubs {
+		if containsName(b.clubs, club) {
+			clubs = append(clubs, club)
+		}
+	}
+
+	if len(clubs) > 0 {
+		duplicate.Score += 0.1
+		duplicate.Evidence = append(duplicate.Evidence, fmt.Sprintf("both ran for %s", strings.Join(clubs, ", ")))
+	}
+
+	duplicate.Evidence = append(duplicate.Evidence, "never ran the same race")
+
+	//the older record survives the merge
+	if b.id < a.id {
+		duplicate.Racer, duplicate.Duplicate = duplicate.Duplicate, duplicate.Racer
+	}
+
+	return duplicate, true
+}
+
+//birthDateAgreement is how closely the birth date ranges agree, from 0 to 1.
+//It is the overlap over the span of both ranges, so two racers placed in the
+//same narrow age category agree more than two in an open one.
+func birthDateAgreement(a [2]time.Time, b [2]time.Time, low time.Time, high time.Time) float64 {
+	first, last := a[0], a[1]
+	if b[0].Before(first) {
+		first = b[0]
+	}
+	if b[1].After(last) {
+		last = b[1]
+	}
+
+	span := last.Sub(first)
+	if span <= 0 {
+		return 0
+	}
+
+	return float64(high.Sub(low)) / float64(span)
+}
+
+//nameScore weighs the name evidence.  Closely agreeing birth dates add up to
+//0.3 and a shared club 0.1, so the surest duplicate scores 1.
+func nameScore(e names.Equivalence) float64 {
+	switch e.Reason() {
+	case names.ReasonExact:
+		return 0.6
+	case names.ReasonNickname:
+		return 0.45
+	default:
+		return 0.35
+	}
+}
+
+func nameEvidence(a string, b string, e names.Equivalence) string {
+	switch e.Reason() {
+	case names.ReasonExact:
+		return fmt.Sprintf("same name %s", a)
+	case names.ReasonNickname:
+		return fmt.Sprintf("%s and %s are nicknames of the same name", a, b)
+	default:
+		return fmt.Sprintf("%s and %s sound alike", a, b)
+	}
+}
+
+type byScore []DuplicateRacers
+
+func (d byScore) Len() int           { return len(d) }
+func (d byScore) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byScore) Less(i, j int) bool { return d[i].Score > d[j].Score }
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+//ErrorKind says what went wrong, so a caller can tell a missing record or a
+//bad request from a failing database
+type ErrorKind int
+
+const (
+	//KindStorage is a failure of the database itself
+	KindStorage ErrorKind = iota
+	//KindNotFound is a record that doesn't exist
+	KindNotFound
+	//KindConflict is a change the current state of the records doesn't allow
+	KindConflict
+	//KindValidation is a request with values that can't be saved
+	KindValidation
+)
+
+//Error is the error returned by the store.  Op names the method that failed
+//and Err is the cause, which may be one of the Err variables.
+type Error struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if len(e.Op) == 0 {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind ErrorKind, text string) error {
+	return &Error{Kind: kind, Err: errors.New(text)}
+}
+
+//KindOf returns the kind of the error.  Errors that didn't come from the store are storage errors.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindStorage
+}
+
+//wrapError records the operation that failed.  The kind of a store error is
+//kept, gorm's missing record becomes ErrRecordNotFoundError and anything else
+//is a storage error.
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if err == gorm.RecordNotFound {
+		err = ErrRecordNotFoundError
+	}
+
+	return &Error{Kind: KindOf(err), Op: op, Err: err}
+}
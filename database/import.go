@@ -0,0 +1,329 @@
+package database
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/model"
+	"github.com/chiefwhitecloud/running-man/names"
+)
+
+//batchSize bounds the rows handled by a single batch query or insert so the
+//number of bind parameters stays well below every driver's limit
+const batchSize = 50
+
+var raceResultColumns = []string{
+	"race_id", "racer_id", "name", "name_key", "position", "sex_position", "age_category_position",
+	"age_category_id", "bib_number", "time", "time_ms", "chip_time", "chip_time_ms", "sex", "club",
+}
+
+//SaveRace saves the race details and completes the import task.  Everything,
+//including the new racers, is saved in one transaction so a failure leaves the
+//database as it was.
+func (db *Db) SaveRace(task ImportTask, r *model.RaceDetails) (Race, error) {
+	var race Race
+
+	err := db.transaction(func(tx *Db) error {
+		var err error
+		race, err = tx.saveRace(task, r)
+		return err
+	})
+
+	return race, wrapError("SaveRace", err)
+}
+
+//saveRace loads every racer who could match a result, and their age category
+//history, up front.  The results are matched in memory and inserted in batches.
+func (db *Db) saveRace(task ImportTask, r *model.RaceDetails) (Race, error) {
+
+	//categories are matched by their name or one of their aliases
+	cats, err := db.loadAgeCategoryLookup()
+
+	if err != nil {
+		return Race{}, err
+	}
+
+	raceDate := time.Date(r.Year, time.Month(r.Month), r.Day, 0, 0, 0, 0, time.UTC)
+
+	race := Race{}
+	if err := db.orm.First(&race, task.RaceID).Error; err != nil {
+		return race, err
+	}
+	race.Name = r.Name
+	race.Date = raceDate
+	if err := db.orm.Save(&race).Error; err != nil {
+		return race, err
+	}
+
+	//racers who have raced under these names, nicknames or similar sounding names
+	var keys []string
+	seenKeys := map[string]bool{}
+	for i := range r.Racers {
+		key := names.Key(r.Racers[i].Name)
+		if len(key) > 0 && !seenKeys[key] {
+			seenKeys[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	candidates, err := db.findRacerNameCandidates(keys)
+
+	if err != nil {
+		return race, err
+	}
+
+	var candidateIds []int
+	seenIds := map[int]bool{}
+	for _, key := range keys {
+		for i := range candidates[key] {
+			if id := candidates[key][i].RacerID; !seenIds[id] {
+				seenIds[id] = true
+				candidateIds = append(candidateIds, id)
+			}
+		}
+	}
+
+	birthDates, err := db.getBirthDatesForRacers(candidateIds)
+
+	if err != nil {
+		return race, err
+	}
+
+	//racers already given a result in this race, under any of their names
+	inRace := map[int]bool{}
+
+	results := make([]RaceResult, 0, len(r.Racers))
+
+	//the results of new racers, given their racer once they are all created
+	var newRacerResults []int
+
+	for i := range r.Racers {
+
+		mRacer := r.Racers[i]
+
+		cat, knownCat := cats.find(mRacer.AgeCategory)
+
+		racerID, err := matchRacer(mRacer, candidates[names.Key(mRacer.Name)], inRace, birthDates, raceDate, cat, knownCat)
+
+		if err != nil {
+			return race, err
+		}
+
+		//must be a new racer.. no racer with that name or none that match
+		if racerID == 0 {
+			newRacerResults = append(newRacerResults, len(results))
+		} else {
+			inRace[racerID] = true
+		}
+
+		results = append(results, RaceResult{
+			RaceID:              race.ID,
+			RacerID:             racerID,
+			Name:                mRacer.Name,
+			NameKey:             names.Key(mRacer.Name),
+			Position:            mRacer.Position,
+			BibNumber:           mRacer.BibNumber,
+			SexPosition:         mRacer.SexPosition,
+			AgeCategoryPosition: mRacer.AgeCategoryPosition,
+			AgeCategoryID:       cat.ID,
+			Time:                mRacer.Time,
+			TimeMs:              mRacer.TimeMs,
+			ChipTime:            mRacer.ChipTime,
+			ChipTimeMs:          mRacer.ChipTimeMs,
+			Sex:                 mRacer.Sex,
+			Club:                mRacer.Club,
+		})
+	}
+
+	newRacerIDs, err := db.insertRacers(task, len(newRacerResults))
+
+	if err != nil {
+		return race, err
+	}
+
+	for i, id := range newRacerIDs {
+		results[newRacerResults[i]].RacerID = id
+		inRace[id] = true
+	}
+
+	if err := db.insertRaceResults(results); err != nil {
+		return race, err
+	}
+
+	//the new results can narrow down the age of everyone in the race
+	racerIDs := make([]int, 0, len(inRace))
+	for id := range inRace {
+		racerIDs = append(racerIDs, id)
+	}
+
+	if err := db.updateBrackets(racerIDs); err != nil {
+		return race, err
+	}
+
+	if err := db.touchRacers(racerIDs); err != nil {
+		return race, err
+	}
+
+	t := time.Now()
+
+	h := sha1.New()
+	h.Write([]byte(race.Name + race.Date.String() + t.String()))
+	bs := h.Sum(nil)
+
+	race.ImportStatus = "completed"
+	race.LastUpdated = time.Now()
+	race.Imported = &race.LastUpdated
+	race.ETag = hex.EncodeToString(bs)
+	if err := db.orm.Save(&race).Error; err != nil {
+		return race, err
+	}
+
+	task.Status = "completed"
+	if err := db.orm.Save(&task).Error; err != nil {
+		return race, err
+	}
+
+	return race, nil
+}
+
+//matchRacer finds the existing racer the result belongs to, or returns 0 for a new racer
+func matchRacer(mRacer model.Racer, candidates []RacerNameMatch, inRace map[int]bool, birthDates map[int][2]time.Time, raceDate time.Time, cat AgeCategory, knownCat bool) (int, error) {
+
+	//Time to match the race result with an existing Racer in the database.
+	for _, match := range rankRacerNameMatches(mRacer.Name, candidates) {
+		if inRace[match.RacerID] {
+			continue
+		}
+
+		//look at the racers age catgory history... does it look like a match?
+		dates := birthDates[match.RacerID]
+		minAge, maxAge := ageRangeOnDate(dates[0], dates[1], raceDate)
+
+		if !knownCat {
+			return 0, newError(KindValidation, "Failed to find age category "+mRacer.AgeCategory)
+		}
+
+		//check to see if the race is within the same age category
+		if isAgeRangeWithinCatgory(maxAge, minAge, cat) {
+			return match.RacerID, nil
+		}
+	}
+
+	return 0, nil
+}
+
+//insertRaceResults saves the results with multi row inserts
+func (db *Db) insertRaceResults(results []RaceResult) error {
+	scope := db.orm.NewScope(&RaceResult{})
+
+	columns := make([]string, len(raceResultColumns))
+	placeholders := make([]string, len(raceResultColumns))
+	for i := range raceResultColumns {
+		columns[i] = scope.Quote(raceResultColumns[i])
+		placeholders[i] = "?"
+	}
+	row := "(" + strings.Join(placeholders, ", ") + ")"
+
+	for start := 0; start < len(results); start += batchSize {
+		batch := results[start:minInt(start+batchSize, len(results))]
+
+		rows := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*len(raceResultColumns))
+
+		for i, result := range batch {
+			rows[i] = row
+			args = append(args, result.RaceID, result.RacerID, result.Name, result.NameKey, result.Position, result.SexPosition, result.AgeCategoryPosition,
+				result.AgeCategoryID, result.BibNumber, result.Time, result.TimeMs, result.ChipTime, result.ChipTimeMs, result.Sex, result.Club)
+		}
+
+		sql := "INSERT INTO " + scope.QuotedTableName() + " (" + strings.Join(columns, ", ") + ") VALUES " + strings.Join(rows, ", ")
+
+		if err := db.orm.Exec(sql, args...).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//insertRacers creates the new racers of the task's race in batches and
+//returns their ids in order.  Each is tagged with the task and its place in
+//the etag, which the race's racers are given fresh once the results are
+//saved, so the ids can be read back the same way on every database.
+func (db *Db) insertRacers(task ImportTask, count int) ([]int, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	//the new racers come after every racer there is now
+	var lastID int
+	if err := db.orm.Raw("SELECT COALESCE(MAX(id), 0) FROM racer").Row().Scan(&lastID); err != nil {
+		return nil, err
+	}
+
+	scope := db.orm.NewScope(&Racer{})
+	tag := fmt.Sprintf("import-%d-", task.ID)
+	created := time.Now()
+
+	for start := 0; start < count; start += batchSize {
+		end := minInt(start+batchSize, count)
+
+		rows := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*3)
+
+		for i := start; i < end; i++ {
+			rows = append(rows, "(?, ?, ?)")
+			args = append(args, created, tag+strconv.Itoa(i), created)
+		}
+
+		sql := "INSERT INTO " + scope.QuotedTableName() + " (" + scope.Quote("created") + ", " + scope.Quote("e_tag") + ", " + scope.Quote("last_updated") + ") VALUES " + strings.Join(rows, ", ")
+
+		if err := db.orm.Exec(sql, args...).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]int, count)
+
+	rows, err := db.orm.Raw("SELECT id, e_tag FROM racer WHERE id > ? AND e_tag LIKE ?", lastID, tag+"%").Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var etag string
+		if err := rows.Scan(&id, &etag); err != nil {
+			return nil, err
+		}
+		if i, err := strconv.Atoi(strings.TrimPrefix(etag, tag)); err == nil && i < count {
+			ids[i] = id
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range ids {
+		if ids[i] == 0 {
+			return nil, fmt.Errorf("new racer %d of import task %d was not saved", i, task.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,1924 @@
+package database
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/model"
+	"github.com/chiefwhitecloud/running-man/names"
+)
+
+//MemoryStore keeps everything in memory.  It behaves like Db so the handlers
+//can be tested without a database, and is only meant for tests: every change
+//copies all of the data so a failed one can be thrown away, which is cheap
+//for a test's few races but not for a real results history.  It is safe for
+//concurrent use.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+}
+
+type memoryData struct {
+	lastID             map[string]int
+	races              map[int]Race
+	raceGroups         map[int]RaceGroup
+	racers             map[int]Racer
+	results            map[int]RaceResult
+	tasks              map[int]ImportTask
+	racerAliases       map[int]RacerAlias
+	redirects          map[int]RacerRedirect
+	merges             map[int]RacerMerge
+	mergeChanges       map[int]RacerMergeChange
+	ageCategories      map[int]AgeCategory
+	ageCategoryAliases map[int]AgeCategoryAlias
+	auditEntries       map[int]AuditEntry
+}
+
+//NewMemoryStore returns an empty store with the age categories seeded, as
+//after migrating a new database
+func NewMemoryStore() *MemoryStore {
+	d := &memoryData{
+		lastID:             map[string]int{},
+		races:              map[int]Race{},
+		raceGroups:         map[int]RaceGroup{},
+		racers:             map[int]Racer{},
+		results:            map[int]RaceResult{},
+		tasks:              map[int]ImportTask{},
+		racerAliases:       map[int]RacerAlias{},
+		redirects:          map[int]RacerRedirect{},
+		merges:             map[int]RacerMerge{},
+		mergeChanges:       map[int]RacerMergeChange{},
+		ageCategories:      map[int]AgeCategory{},
+		ageCategoryAliases: map[int]AgeCategoryAlias{},
+		auditEntries:       map[int]AuditEntry{},
+	}
+
+	for _, name := range ageCategoryNames {
+		ages := legacyAgeCategoryAges[name]
+		id := d.nextID("age_category")
+		d.ageCategories[id] = AgeCategory{ID: id, Name: name, MinAge: ages[0], MaxAge: ages[1]}
+	}
+
+	return &MemoryStore{data: d}
+}
+
+func (d *memoryData) nextID(table string) int {
+	d.lastID[table]++
+	return d.lastID[table]
+}
+
+//copy is used to roll back changes that fail part way through
+func (d *memoryData) copy() *memoryData {
+	c := &memoryData{
+		lastID:             map[string]int{},
+		races:              map[int]Race{},
+		raceGroups:         map[int]RaceGroup{},
+		racers:             map[int]Racer{},
+		results:            map[int]RaceResult{},
+		tasks:              map[int]ImportTask{},
+		racerAliases:       map[int]RacerAlias{},
+		redirects:          map[int]RacerRedirect{},
+		merges:             map[int]RacerMerge{},
+		mergeChanges:       map[int]RacerMergeChange{},
+		ageCategories:      map[int]AgeCategory{},
+		ageCategoryAliases: map[int]AgeCategoryAlias{},
+		auditEntries:       map[int]AuditEntry{},
+	}
+	for k, v := range d.lastID {
+		c.lastID[k] = v
+	}
+	for k, v := range d.races {
+		c.races[k] = v
+	}
+	for k, v := range d.raceGroups {
+		c.raceGroups[k] = v
+	}
+	for k, v := range d.racers {
+		c.racers[k] = v
+	}
+	for k, v := range d.results {
+		c.results[k] = v
+	}
+	for k, v := range d.tasks {
+		c.tasks[k] = v
+	}
+	for k, v := range d.racerAliases {
+		c.racerAliases[k] = v
+	}
+	for k, v := range d.redirects {
+		c.redirects[k] = v
+	}
+	for k, v := range d.merges {
+		c.merges[k] = v
+	}
+	for k, v := range d.mergeChanges {
+		c.mergeChanges[k] = v
+	}
+	for k, v := range d.ageCategories {
+		c.ageCategories[k] = v
+	}
+	for k, v := range d.ageCategoryAliases {
+		c.ageCategoryAliases[k] = v
+	}
+	for k, v := range d.auditEntries {
+		c.auditEntries[k] = v
+	}
+	return c
+}
+
+//transaction runs fn against a copy of the data, which replaces the data
+//only when fn succeeds.  The caller holds the lock.
+func (m *MemoryStore) transaction(fn func(d *memoryData) error) error {
+	d := m.data.copy()
+	if err := fn(d); err != nil {
+		return err
+	}
+	m.data = d
+	return nil
+}
+
+//Transaction runs fn against a store over a copy of the data, which replaces
+//the data only when fn succeeds.  Everything else waits until it is done.
+func (m *MemoryStore) Transaction(fn func(tx Store) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &MemoryStore{data: m.data.copy()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.data = tx.data
+	return nil
+}
+
+func (d *memoryData) sortedRaces(keep func(race Race) bool) []Race {
+	races := []Race{}
+	for _, race := range d.races {
+		if keep(race) {
+			races = append(races, race)
+		}
+	}
+	sort.Slice(races, func(i, j int) bool { return races[i].ID < races[j].ID })
+	return races
+}
+
+//sortedResults returns the results that are kept, in the order they were saved
+func (d *memoryData) sortedResults(keep func(result RaceResult) bool) []RaceResult {
+	results := []RaceResult{}
+	for _, result := range d.results {
+		if keep(result) {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results
+}
+
+func (d *memoryData) sortedRacerAliases(keep func(alias RacerAlias) bool) []RacerAlias {
+	aliases := []RacerAlias{}
+	for _, alias := range d.racerAliases {
+		if keep(alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].ID < aliases[j].ID })
+	return aliases
+}
+
+func (d *memoryData) sortedAgeCategoryAliases(keep func(alias AgeCategoryAlias) bool) []AgeCategoryAlias {
+	aliases := []AgeCategoryAlias{}
+	for _, alias := range d.ageCategoryAliases {
+		if keep(alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].ID < aliases[j].ID })
+	return aliases
+}
+
+func (d *memoryData) ageCategoryLookup() ageCategoryLookup {
+	categories := []AgeCategory{}
+	for _, category := range d.ageCategories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+
+	return newAgeCategoryLookup(categories, d.sortedAgeCategoryAliases(func(AgeCategoryAlias) bool { return true }))
+}
+
+//withAgeCategory fills in the name of the result's category, as the result queries do
+func (d *memoryData) withAgeCategory(result RaceResult) RaceResult {
+	result.AgeCategory = AgeCategory{ID: result.AgeCategoryID, Name: d.ageCategories[result.AgeCategoryID].Name}
+	return result
+}
+
+//keep matches ResultFilter.apply, results of races in the trash are never kept
+func (f ResultFilter) keep(result RaceResult, race Race) bool {
+	if race.Deleted != nil {
+		return false
+	}
+	if len(f.Bracket) > 0 && result.Bracket != f.Bracket {
+		return false
+	}
+	if !f.From.IsZero() && race.Date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && race.Date.After(f.To) {
+		return false
+	}
+	if len(f.Sex) > 0 && !strings.EqualFold(result.Sex, f.Sex) {
+		return false
+	}
+	if f.AgeCategoryID > 0 && result.AgeCategoryID != f.AgeCategoryID {
+		return false
+	}
+	if len(f.Club) > 0 && !strings.Contains(strings.ToUpper(result.Club), strings.ToUpper(f.Club)) {
+		return false
+	}
+	if len(f.Bib) > 0 && result.BibNumber != f.Bib {
+		return false
+	}
+	if len(f.Name) > 0 && !strings.Contains(strings.ToUpper(result.Name), strings.ToUpper(f.Name)) {
+		return false
+	}
+	return true
+}
+
+//Races
+
+func (m *MemoryStore) GetRaces() ([]Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	races := m.data.sortedRaces(func(race Race) bool { return race.Deleted == nil })
+	sort.SliceStable(races, func(i, j int) bool { return races[i].Date.After(races[j].Date) })
+	return races, nil
+}
+
+func (m *MemoryStore) GetRacePage(filter RaceFilter) (RacePage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return filter.sortRaces(m.data.sortedRaces(filter.keep)), nil
+}
+
+func (m *MemoryStore) GetRace(id int) (Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	race, ok := m.data.races[id]
+	if !ok || race.Deleted != nil {
+		return Race{}, ErrRecordNotFoundError
+	}
+	return race, nil
+}
+
+func (m *MemoryStore) DeleteRace(id int) (Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	race, ok := m.data.races[id]
+	if !ok || race.Deleted != nil {
+		return Race{}, ErrRecordNotFoundError
+	}
+
+	deleted := time.Now()
+	race.Deleted = &deleted
+	m.data.races[id] = race
+	m.data.raceChanged(race)
+
+	return race, nil
+}
+
+func (m *MemoryStore) GetLastUpdatedRace() (Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.lastUpdatedRace()
+}
+
+func (d *memoryData) lastUpdatedRace() (Race, error) {
+	race := Race{}
+	for _, r := range d.races {
+		if r.Deleted == nil && (race.ID == 0 || r.LastUpdated.After(race.LastUpdated)) {
+			race = r
+		}
+	}
+
+	if race.Name == "" {
+		return race, ErrNoRecordsAvailable
+	}
+
+	return race, nil
+}
+
+func (m *MemoryStore) HasRaceBeenImported(url string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, race := range m.data.races {
+		if race.SrcUrl == url && race.ImportStatus == "completed" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) GetDeletedRaces() ([]Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	races := m.data.sortedRaces(func(race Race) bool { return race.Deleted != nil })
+	sort.SliceStable(races, func(i, j int) bool { return races[i].Deleted.After(*races[j].Deleted) })
+	return races, nil
+}
+
+func (m *MemoryStore) RestoreRace(id int) (Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	race, ok := m.data.races[id]
+	if !ok || race.Deleted == nil {
+		return Race{}, ErrRecordNotFoundError
+	}
+
+	race.Deleted = nil
+	race.ETag, race.LastUpdated = newETag(race.Name)
+	m.data.races[id] = race
+	m.data.raceChanged(race)
+
+	return m.data.races[id], nil
+}
+
+func (m *MemoryStore) PurgeDeletedRaces(before time.Time) ([]Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	races := m.data.sortedRaces(func(race Race) bool { return race.Deleted != nil && race.Deleted.Before(before) })
+	for _, race := range races {
+		m.data.purgeRace(race)
+	}
+	return races, nil
+}
+
+//purgeRace removes the race with its results and import task, and the racers
+//who only ran this race
+func (d *memoryData) purgeRace(race Race) {
+	seen := map[int]bool{}
+	var racerIDs []int
+	for resultID, result := range d.results {
+		if result.RaceID != race.ID {
+			continue
+		}
+		if !seen[result.RacerID] {
+			seen[result.RacerID] = true
+			racerIDs = append(racerIDs, result.RacerID)
+		}
+		delete(d.results, resultID)
+	}
+	sort.Ints(racerIDs)
+
+	for taskID, task := range d.tasks {
+		if task.RaceID == race.ID {
+			delete(d.tasks, taskID)
+		}
+	}
+
+	delete(d.races, race.ID)
+
+	orphanIDs := d.orphanRacerIDs(racerIDs)
+	d.deleteRacers(orphanIDs)
+
+	racerIDs = withoutIDs(racerIDs, orphanIDs)
+	d.updateBrackets(racerIDs)
+	d.touchRacers(racerIDs)
+}
+
+//raceChanged gives new etags to everything listing the race
+func (d *memoryData) raceChanged(race Race) {
+	seen := map[int]bool{}
+	var racerIDs []int
+	for _, result := range d.sortedResults(func(result RaceResult) bool { return result.RaceID == race.ID }) {
+		if !seen[result.RacerID] {
+			seen[result.RacerID] = true
+			racerIDs = append(racerIDs, result.RacerID)
+		}
+	}
+	d.touchRacers(racerIDs)
+
+	if raceGroup, ok := d.raceGroups[race.RaceGroupID]; ok && raceGroup.Deleted == nil {
+		raceGroup.ETag, raceGroup.LastUpdated = newETag(raceGroup.Name)
+		d.raceGroups[raceGroup.ID] = raceGroup
+	}
+
+	//update the etag for the newest item... this is the etag used to the list
+	if raceLastUpdated, err := d.lastUpdatedRace(); err == nil {
+		raceLastUpdated.ETag, raceLastUpdated.LastUpdated = newETag(raceLastUpdated.Name)
+		d.races[raceLastUpdated.ID] = raceLastUpdated
+	}
+}
+
+//Race groups
+
+func (m *MemoryStore) CreateRaceGroup(name string, distance string, distanceunit string) (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	etag, lastUpdated := newETag(name)
+	raceGroup := RaceGroup{ID: m.data.nextID("race_group"), Name: name, Distance: distance, DistanceUnit: distanceunit, LastUpdated: lastUpdated, ETag: etag}
+	m.data.raceGroups[raceGroup.ID] = raceGroup
+	return raceGroup, nil
+}
+
+func (m *MemoryStore) UpdateRaceGroup(id int, name string, distance string, distanceunit string) (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroup, ok := m.data.raceGroups[id]
+	if !ok || raceGroup.Deleted != nil {
+		return RaceGroup{}, ErrRecordNotFoundError
+	}
+
+	raceGroup.Name = name
+	raceGroup.Distance = distance
+	raceGroup.DistanceUnit = distanceunit
+	raceGroup.ETag, raceGroup.LastUpdated = newETag(name)
+	m.data.raceGroups[id] = raceGroup
+	return raceGroup, nil
+}
+
+func (m *MemoryStore) DeleteRaceGroup(id int) (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroup, ok := m.data.raceGroups[id]
+	if !ok || raceGroup.Deleted != nil {
+		return RaceGroup{}, ErrRecordNotFoundError
+	}
+
+	deleted := time.Now()
+	raceGroup.Deleted = &deleted
+	m.data.raceGroups[id] = raceGroup
+	m.data.raceGroupChanged(raceGroup)
+
+	return raceGroup, nil
+}
+
+func (m *MemoryStore) GetRaceGroup(id int) (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroup, ok := m.data.raceGroups[id]
+	if !ok || raceGroup.Deleted != nil {
+		return RaceGroup{}, ErrRecordNotFoundError
+	}
+	return raceGroup, nil
+}
+
+func (m *MemoryStore) GetRaceGroups() ([]RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroups := m.data.sortedRaceGroups(func(raceGroup RaceGroup) bool { return raceGroup.Deleted == nil })
+	sort.SliceStable(raceGroups, func(i, j int) bool { return raceGroups[i].Name < raceGroups[j].Name })
+	return raceGroups, nil
+}
+
+func (d *memoryData) sortedRaceGroups(keep func(raceGroup RaceGroup) bool) []RaceGroup {
+	raceGroups := []RaceGroup{}
+	for _, raceGroup := range d.raceGroups {
+		if keep(raceGroup) {
+			raceGroups = append(raceGroups, raceGroup)
+		}
+	}
+	sort.Slice(raceGroups, func(i, j int) bool { return raceGroups[i].ID < raceGroups[j].ID })
+	return raceGroups
+}
+
+func (m *MemoryStore) GetLastUpdatedRaceGroup() (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.lastUpdatedRaceGroup()
+}
+
+func (d *memoryData) lastUpdatedRaceGroup() (RaceGroup, error) {
+	raceGroup := RaceGroup{}
+	for _, g := range d.raceGroups {
+		if g.Deleted == nil && (raceGroup.ID == 0 || g.LastUpdated.After(raceGroup.LastUpdated)) {
+			raceGroup = g
+		}
+	}
+
+	if raceGroup.Name == "" {
+		return raceGroup, ErrNoRecordsAvailable
+	}
+
+	return raceGroup, nil
+}
+
+func (m *MemoryStore) GetRacesForRaceGroup(raceGroupId int) ([]Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.sortedRaces(func(race Race) bool { return race.RaceGroupID == raceGroupId && race.Deleted == nil }), nil
+}
+
+func (m *MemoryStore) AddRaceToRaceGroup(raceGroup RaceGroup, race Race) (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	race.ETag, race.LastUpdated = newETag(race.Name)
+	race.RaceGroupID = raceGroup.ID
+	m.data.races[race.ID] = race
+
+	raceGroup.ETag, raceGroup.LastUpdated = newETag(raceGroup.Name)
+	m.data.raceGroups[raceGroup.ID] = raceGroup
+
+	return raceGroup, nil
+}
+
+func (m *MemoryStore) GetDeletedRaceGroups() ([]RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroups := m.data.sortedRaceGroups(func(raceGroup RaceGroup) bool { return raceGroup.Deleted != nil })
+	sort.SliceStable(raceGroups, func(i, j int) bool { return raceGroups[i].Deleted.After(*raceGroups[j].Deleted) })
+	return raceGroups, nil
+}
+
+func (m *MemoryStore) RestoreRaceGroup(id int) (RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroup, ok := m.data.raceGroups[id]
+	if !ok || raceGroup.Deleted == nil {
+		return RaceGroup{}, ErrRecordNotFoundError
+	}
+
+	raceGroup.Deleted = nil
+	raceGroup.ETag, raceGroup.LastUpdated = newETag(raceGroup.Name)
+	m.data.raceGroups[id] = raceGroup
+	m.data.raceGroupChanged(raceGroup)
+
+	return m.data.raceGroups[id], nil
+}
+
+func (m *MemoryStore) PurgeDeletedRaceGroups(before time.Time) ([]RaceGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	raceGroups := m.data.sortedRaceGroups(func(raceGroup RaceGroup) bool {
+		return raceGroup.Deleted != nil && raceGroup.Deleted.Before(before)
+	})
+
+	for _, raceGroup := range raceGroups {
+		for _, race := range m.data.sortedRaces(func(race Race) bool { return race.RaceGroupID == raceGroup.ID }) {
+			race.RaceGroupID = 0
+			race.ETag, race.LastUpdated = newETag(race.Name)
+			m.data.races[race.ID] = race
+		}
+		delete(m.data.raceGroups, raceGroup.ID)
+	}
+
+	return raceGroups, nil
+}
+
+//raceGroupChanged gives new etags to the races in the group and the list of race groups
+func (d *memoryData) raceGroupChanged(raceGroup RaceGroup) {
+	for _, race := range d.sortedRaces(func(race Race) bool { return race.RaceGroupID == raceGroup.ID && race.Deleted == nil }) {
+		race.ETag, race.LastUpdated = newETag(race.Name)
+		d.races[race.ID] = race
+	}
+
+	//update the etag for the newest item... this is the etag used to the list
+	if raceGroupLastUpdated, err := d.lastUpdatedRaceGroup(); err == nil {
+		raceGroupLastUpdated.ETag, raceGroupLastUpdated.LastUpdated = newETag(raceGroupLastUpdated.Name)
+		d.raceGroups[raceGroupLastUpdated.ID] = raceGroupLastUpdated
+	}
+}
+
+//Racers
+
+func (m *MemoryStore) GetRacer(id int) (Racer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	racer, ok := m.data.racers[id]
+	if !ok {
+		return racer, ErrRecordNotFoundError
+	}
+	return racer, nil
+}
+
+func (m *MemoryStore) GetRacerNames(id int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.racerNames(id), nil
+}
+
+//racerNames lists the names the racer raced under, oldest first, followed by their aliases
+func (d *memoryData) racerNames(id int) []string {
+	var results []string
+
+	for _, result := range d.sortedResults(func(result RaceResult) bool { return result.RacerID == id }) {
+		if !containsName(results, result.Name) {
+			results = append(results, result.Name)
+		}
+	}
+
+	for _, alias := range d.racerAliasesFor(id) {
+		if !containsName(results, alias.Name) {
+			results = append(results, alias.Name)
+		}
+	}
+
+	return results
+}
+
+func (m *MemoryStore) GetRacerBirthDates(id int) (time.Time, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	birthDates := m.data.birthDatesForRacers([]int{id})
+	return birthDates[id][0], birthDates[id][1], nil
+}
+
+//birthDatesForRacers narrows down each racer's birth date from the age
+//categories they have raced in.  Racers without a category history are left out.
+func (d *memoryData) birthDatesForRacers(ids []int) map[int][2]time.Time {
+	wanted := map[int]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	results := d.sortedResults(func(result RaceResult) bool { return wanted[result.RacerID] })
+	sort.SliceStable(results, func(i, j int) bool {
+		return d.races[results[i].RaceID].Date.Before(d.races[results[j].RaceID].Date)
+	})
+
+	histories := map[int][]AgeResult{}
+	for _, result := range results {
+		race, raceOk := d.races[result.RaceID]
+		category, categoryOk := d.ageCategories[result.AgeCategoryID]
+		if !raceOk || !categoryOk {
+			continue
+		}
+		histories[result.RacerID] = append(histories[result.RacerID], AgeResult{RaceDate: race.Date, AgeCategory: category})
+	}
+
+	return birthDatesFromHistories(histories)
+}
+
+func (m *MemoryStore) FindRacersForName(name string) ([]RacerNameMatch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := names.Key(name)
+
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	return rankRacerNameMatches(name, m.data.racerNameCandidates([]string{key})[key]), nil
+}
+
+func (m *MemoryStore) SearchRacers(search RacerSearch) ([]RacerSearchMatch, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query, err := racerSearchQuery(search)
+
+	if err != nil {
+		return nil, 0, wrapError("SearchRacers", err)
+	}
+
+	//the same names the sql considers: containing the query or sharing a key
+	//with it, of racers with the sex and club searched for
+	keys := map[string]bool{}
+	for _, key := range racerSearchKeys(query) {
+		keys[key] = true
+	}
+	filtered := m.data.racersWithResult(func(result RaceResult) bool {
+		return (len(search.Sex) == 0 || strings.EqualFold(strings.TrimSpace(result.Sex), strings.TrimSpace(search.Sex))) &&
+			(len(search.Club) == 0 || strings.EqualFold(strings.TrimSpace(result.Club), strings.TrimSpace(search.Club)))
+	})
+	considered := func(racerID int, name string, key string) bool {
+		return filtered[racerID] && (strings.Contains(strings.ToUpper(name), query) || keys[key])
+	}
+
+	var candidates []racerSearchCandidate
+	seen := map[racerSearchCandidate]bool{}
+
+	for _, result := range m.data.sortedResults(func(result RaceResult) bool {
+		race, ok := m.data.races[result.RaceID]
+		return ok && race.Deleted == nil && considered(result.RacerID, result.Name, result.NameKey)
+	}) {
+		candidate := racerSearchCandidate{RacerID: result.RacerID, Name: result.Name}
+		if !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	for _, alias := range m.data.sortedRacerAliases(func(alias RacerAlias) bool { return considered(alias.RacerID, alias.Name, alias.NameKey) }) {
+		candidates = append(candidates, racerSearchCandidate{RacerID: alias.RacerID, Name: alias.Name})
+	}
+
+	matches := matchRacerSearchCandidates(query, capRacerSearchCandidates(query, candidates))
+
+	results := m.data.sortedResults(func(result RaceResult) bool {
+		race, ok := m.data.races[result.RaceID]
+		_, matched := matches[result.RacerID]
+		return ok && race.Deleted == nil && matched
+	})
+	sort.SliceStable(results, func(i, j int) bool {
+		dateI, dateJ := m.data.races[results[i].RaceID].Date, m.data.races[results[j].RaceID].Date
+		if !dateI.Equal(dateJ) {
+			return dateI.After(dateJ)
+		}
+		return results[i].ID > results[j].ID
+	})
+
+	profiles := map[int]*racerSearchProfile{}
+	for _, result := range results {
+		addToRacerSearchProfile(profiles, result.RacerID, result.RaceID, result.Sex, result.Club)
+	}
+
+	var birthDates map[int][2]time.Time
+	if search.BornFrom > 0 || search.BornTo > 0 {
+		ids := []int{}
+		for id := range matches {
+			ids = append(ids, id)
+		}
+		birthDates = m.data.birthDatesForRacers(ids)
+	}
+
+	page, total := filterRacerSearchMatches(search, matches, profiles, birthDates)
+	return page, total, nil
+}
+
+//racersWithResult returns the racers with a result outside the trash that keep returns true for
+func (d *memoryData) racersWithResult(keep func(result RaceResult) bool) map[int]bool {
+	racers := map[int]bool{}
+	for _, result := range d.results {
+		if race, ok := d.races[result.RaceID]; ok && race.Deleted == nil && keep(result) {
+			racers[result.RacerID] = true
+		}
+	}
+	return racers
+}
+
+func (m *MemoryStore) SuggestRacers(prefix string, limit int) ([]RacerSuggestion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(names.Normalize(prefix)) == 0 {
+		return nil, wrapError("SuggestRacers", newError(KindValidation, "prefix is required"))
+	}
+
+	n := racerNames{Names: map[int][]string{}, Races: map[int]int{}}
+	inRace := map[[2]int]bool{}
+
+	for _, result := range m.data.sortedResults(func(result RaceResult) bool {
+		race, ok := m.data.races[result.RaceID]
+		return ok && race.Deleted == nil
+	}) {
+		races := 0
+		if !inRace[[2]int{result.RacerID, result.RaceID}] {
+			inRace[[2]int{result.RacerID, result.RaceID}] = true
+			races = 1
+		}
+		n.add(result.RacerID, result.Name, races)
+	}
+
+	for _, alias := range m.data.sortedRacerAliases(func(RacerAlias) bool { return true }) {
+		n.add(alias.RacerID, alias.Name, 0)
+	}
+
+	index := newSuggestionIndex()
+	index.replace(nil, n)
+
+	return index.suggest(prefix, limit), nil
+}
+
+//racerNameCandidates returns the result names and aliases filed under each
+//of the phonetic keys.  Result names come first, oldest first, followed by the aliases.
+func (d *memoryData) racerNameCandidates(keys []string) map[string][]RacerNameMatch {
+	wanted := map[string]bool{}
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	candidates := map[string][]RacerNameMatch{}
+	seen := map[RacerNameMatch]bool{}
+
+	for _, result := range d.sortedResults(func(result RaceResult) bool { return wanted[result.NameKey] }) {
+		candidate := RacerNameMatch{RacerID: result.RacerID, Name: result.Name}
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		candidates[result.NameKey] = append(candidates[result.NameKey], candidate)
+	}
+
+	for _, alias := range d.sortedRacerAliases(func(alias RacerAlias) bool { return wanted[alias.NameKey] }) {
+		candidates[alias.NameKey] = append(candidates[alias.NameKey], RacerNameMatch{RacerID: alias.RacerID, Name: alias.Name, Alias: true})
+	}
+
+	return candidates
+}
+
+func (m *MemoryStore) GetRacerAliases(racerID int) ([]RacerAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.racerAliasesFor(racerID), nil
+}
+
+//racerAliasesFor returns the racer's aliases by name
+func (d *memoryData) racerAliasesFor(racerID int) []RacerAlias {
+	aliases := d.sortedRacerAliases(func(alias RacerAlias) bool { return alias.RacerID == racerID })
+	sort.SliceStable(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+	return aliases
+}
+
+func (m *MemoryStore) CreateRacerAlias(racer Racer, name string) (RacerAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, alias := range m.data.sortedRacerAliases(func(alias RacerAlias) bool { return alias.RacerID == racer.ID && alias.Name == name }) {
+		return alias, nil
+	}
+
+	alias := RacerAlias{ID: m.data.nextID("racer_alias"), RacerID: racer.ID, Name: name, NameKey: names.Key(name), Created: time.Now()}
+	m.data.racerAliases[alias.ID] = alias
+	m.data.touchRacers([]int{racer.ID})
+	return alias, nil
+}
+
+func (m *MemoryStore) DeleteRacerAlias(racerID int, id int) (RacerAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alias, ok := m.data.racerAliases[id]
+	if !ok || alias.RacerID != racerID {
+		return RacerAlias{}, ErrRecordNotFoundError
+	}
+
+	delete(m.data.racerAliases, id)
+	m.data.touchRacers([]int{racerID})
+	return alias, nil
+}
+
+func (m *MemoryStore) FindDuplicateRacers() ([]DuplicateRacers, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	summaries := newRacerSummaries()
+
+	results := m.data.sortedResults(func(result RaceResult) bool { return m.data.races[result.RaceID].Deleted == nil })
+	sort.SliceStable(results, func(i, j int) bool { return results[i].RacerID < results[j].RacerID })
+
+	for _, result := range results {
+		summaries.addResult(result.RacerID, result.Name, result.Sex, result.RaceID, result.Club)
+	}
+
+	for _, alias := range m.data.sortedRacerAliases(func(RacerAlias) bool { return true }) {
+		summaries.addAlias(alias.RacerID, alias.Name)
+	}
+
+	return summaries.duplicates(m.data.birthDatesForRacers(summaries.candidates())), nil
+}
+
+func (m *MemoryStore) PreviewRacerMerge(parentRacer Racer, racer Racer) (RacerMergePreview, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.previewRacerMerge(parentRacer, racer), nil
+}
+
+func (d *memoryData) previewRacerMerge(parentRacer Racer, racer Racer) RacerMergePreview {
+
+	preview := RacerMergePreview{Racer: parentRacer, MergedRacer: racer}
+
+	parentRaces := map[int]bool{}
+	parentResultCount := 0
+	for _, result := range d.results {
+		if result.RacerID == parentRacer.ID {
+			parentRaces[result.RaceID] = true
+			parentResultCount++
+		}
+	}
+
+	conflicted := map[int]bool{}
+	for _, result := range d.sortedResults(func(result RaceResult) bool { return result.RacerID == racer.ID }) {
+		preview.ResultsMoved++
+		if !parentRaces[result.RaceID] || conflicted[result.RaceID] {
+			continue
+		}
+		conflicted[result.RaceID] = true
+		preview.Conflicts = append(preview.Conflicts, MergeConflict{
+			Type:        MergeConflictSameRace,
+			RaceID:      result.RaceID,
+			Description: "both racers have results in " + d.races[result.RaceID].Name,
+		})
+	}
+
+	//a racer without results has no birth date range to contradict
+	if preview.ResultsMoved > 0 && parentResultCount > 0 {
+		birthDates := d.birthDatesForRacers([]int{parentRacer.ID, racer.ID})
+		parent := birthDates[parentRacer.ID]
+		merged := birthDates[racer.ID]
+
+		if conflict, ok := birthDateConflict(parent[0], parent[1], merged[0], merged[1]); ok {
+			preview.Conflicts = append(preview.Conflicts, conflict)
+		}
+	}
+
+	return preview
+}
+
+func (m *MemoryStore) MergeRacers(parentRacer Racer, racer Racer, force bool) (RacerMergePreview, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	preview := m.data.previewRacerMerge(parentRacer, racer)
+	if !preview.CanMerge(force) {
+		return preview, ErrMergeConflict
+	}
+
+	err := m.transaction(func(d *memoryData) error {
+		merge := RacerMerge{ID: d.nextID("racer_merge"), ParentRacerID: parentRacer.ID, MergedRacerID: racer.ID, MergedRacerCreated: racer.Created, Created: time.Now()}
+		d.merges[merge.ID] = merge
+
+		//the names the merged racer raced under become aliases of the parent
+		racerNames := d.racerNames(racer.ID)
+		parentNames := d.racerNames(parentRacer.ID)
+		aliases := d.sortedRacerAliases(func(alias RacerAlias) bool { return alias.RacerID == racer.ID })
+
+		changes := map[string][]int{}
+
+		for _, result := range d.sortedResults(func(result RaceResult) bool { return result.RacerID == racer.ID }) {
+			changes[mergeChangeResult] = append(changes[mergeChangeResult], result.ID)
+			result.RacerID = parentRacer.ID
+			d.results[result.ID] = result
+		}
+
+		for _, alias := range aliases {
+			changes[mergeChangeAlias] = append(changes[mergeChangeAlias], alias.ID)
+			alias.RacerID = parentRacer.ID
+			d.racerAliases[alias.ID] = alias
+		}
+
+		for _, name := range racerNames {
+			if containsName(parentNames, name) || containsAlias(aliases, name) {
+				continue
+			}
+			alias := RacerAlias{ID: d.nextID("racer_alias"), RacerID: parentRacer.ID, Name: name, NameKey: names.Key(name), Created: time.Now()}
+			d.racerAliases[alias.ID] = alias
+			changes[mergeChangeAliasCreated] = append(changes[mergeChangeAliasCreated], alias.ID)
+		}
+
+		//earlier merges into the racer now lead to the parent
+		var redirectIds []int
+		for id, redirect := range d.redirects {
+			if redirect.NewRacerID == racer.ID {
+				redirectIds = append(redirectIds, id)
+				redirect.NewRacerID = parentRacer.ID
+				d.redirects[id] = redirect
+			}
+		}
+		sort.Ints(redirectIds)
+		changes[mergeChangeRedirect] = redirectIds
+
+		redirect := RacerRedirect{ID: d.nextID("racer_redirect"), OldRacerID: racer.ID, NewRacerID: parentRacer.ID, Created: time.Now()}
+		d.redirects[redirect.ID] = redirect
+
+		for _, kind := range []string{mergeChangeResult, mergeChangeAlias, mergeChangeAliasCreated, mergeChangeRedirect} {
+			for _, id := range changes[kind] {
+				change := RacerMergeChange{ID: d.nextID("racer_merge_change"), RacerMergeID: merge.ID, Kind: kind, ItemID: id}
+				d.mergeChanges[change.ID] = change
+			}
+		}
+
+		delete(d.racers, racer.ID)
+
+		d.updateBrackets([]int{parentRacer.ID})
+		d.touchRacers([]int{parentRacer.ID})
+		return nil
+	})
+
+	return preview, err
+}
+
+func (m *MemoryStore) GetRacerMerges(parentRacerID int) ([]RacerMerge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parents := m.data.mergedRacerIDs(parentRacerID)
+
+	merges := []RacerMerge{}
+	for _, merge := range m.data.merges {
+		if parents[merge.ParentRacerID] {
+			merges = append(merges, merge)
+		}
+	}
+	sort.Slice(merges, func(i, j int) bool { return merges[i].ID > merges[j].ID })
+	return merges, nil
+}
+
+func (m *MemoryStore) GetRacerMerge(parentRacerID int, id int) (RacerMerge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merge, ok := m.data.merges[id]
+	if !ok || !m.data.mergedRacerIDs(parentRacerID)[merge.ParentRacerID] {
+		return RacerMerge{}, ErrRecordNotFoundError
+	}
+	return merge, nil
+}
+
+//mergedRacerIDs is the racer and every racer merged into it, directly or
+//through other merges
+func (d *memoryData) mergedRacerIDs(racerID int) map[int]bool {
+	ids := map[int]bool{racerID: true}
+	for _, redirect := range d.redirects {
+		if redirect.NewRacerID == racerID {
+			ids[redirect.OldRacerID] = true
+		}
+	}
+	return ids
+}
+
+//currentRacerID is the racer holding the racer's results, the racer it was
+//merged into when it is gone
+func (d *memoryData) currentRacerID(racerID int) (int, bool) {
+	if _, ok := d.racers[racerID]; ok {
+		return racerID, true
+	}
+
+	redirect := RacerRedirect{}
+	for _, r := range d.redirects {
+		if r.OldRacerID == racerID && r.ID > redirect.ID {
+			redirect = r
+		}
+	}
+	return redirect.NewRacerID, redirect.ID != 0
+}
+
+func (m *MemoryStore) UnmergeRacers(merge RacerMerge) (Racer, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	racer := Racer{ID: merge.MergedRacerID, Created: merge.MergedRacerCreated}
+
+	if current, ok := m.data.merges[merge.ID]; ok {
+		merge = current
+	}
+
+	if merge.Undone != nil {
+		return racer, 0, ErrMergeUndone
+	}
+
+	parentID, ok := m.data.currentRacerID(merge.ParentRacerID)
+	if !ok {
+		return racer, 0, ErrRecordNotFoundError
+	}
+
+	moved := 0
+
+	err := m.transaction(func(d *memoryData) error {
+		items := map[string]map[int]bool{}
+		for _, change := range d.mergeChanges {
+			if change.RacerMergeID != merge.ID {
+				continue
+			}
+			if items[change.Kind] == nil {
+				items[change.Kind] = map[int]bool{}
+			}
+			items[change.Kind][change.ItemID] = true
+		}
+
+		d.racers[racer.ID] = racer
+
+		for id := range items[mergeChangeResult] {
+			if result, ok := d.results[id]; ok && result.RacerID == parentID {
+				result.RacerID = racer.ID
+				d.results[id] = result
+				moved++
+			}
+		}
+
+		for id := range items[mergeChangeAlias] {
+			if alias, ok := d.racerAliases[id]; ok && alias.RacerID == parentID {
+				alias.RacerID = racer.ID
+				d.racerAliases[id] = alias
+			}
+		}
+
+		for id := range items[mergeChangeAliasCreated] {
+			delete(d.racerAliases, id)
+		}
+
+		for id := range items[mergeChangeRedirect] {
+			if redirect, ok := d.redirects[id]; ok {
+				redirect.NewRacerID = racer.ID
+				d.redirects[id] = redirect
+			}
+		}
+
+		for id, redirect := range d.redirects {
+			if redirect.OldRacerID == racer.ID {
+				delete(d.redirects, id)
+			}
+		}
+
+		undone := time.Now()
+		merge.Undone = &undone
+		d.merges[merge.ID] = merge
+
+		d.updateBrackets([]int{parentID, racer.ID})
+		d.touchRacers([]int{parentID, racer.ID})
+		return nil
+	})
+
+	if err != nil {
+		return racer, 0, err
+	}
+
+	return racer, moved, nil
+}
+
+func (m *MemoryStore) SplitRaceResults(racer Racer, resultIds []int, target *Racer) (Racer, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []RaceResult
+	for _, id := range resultIds {
+		if result, ok := m.data.results[id]; ok && result.RacerID == racer.ID {
+			results = append(results, result)
+		}
+	}
+
+	if len(results) == 0 || len(results) != len(resultIds) {
+		return racer, 0, ErrRecordNotFoundError
+	}
+
+	//the target can't have run the same races
+	if target != nil {
+		raceIds := map[int]bool{}
+		for i := range results {
+			raceIds[results[i].RaceID] = true
+		}
+		for _, result := range m.data.results {
+			if result.RacerID == target.ID && raceIds[result.RaceID] {
+				return *target, 0, ErrMergeConflict
+			}
+		}
+	}
+
+	newRacer := Racer{Created: time.Now()}
+	moved := 0
+
+	err := m.transaction(func(d *memoryData) error {
+		if target != nil {
+			newRacer = *target
+		} else {
+			newRacer.ID = d.nextID("racer")
+			d.racers[newRacer.ID] = newRacer
+		}
+
+		for _, result := range results {
+			result.RacerID = newRacer.ID
+			d.results[result.ID] = result
+		}
+		moved = len(results)
+
+		d.updateBrackets([]int{racer.ID, newRacer.ID})
+		d.touchRacers([]int{racer.ID, newRacer.ID})
+		return nil
+	})
+
+	if err != nil {
+		return newRacer, 0, err
+	}
+
+	return newRacer, moved, nil
+}
+
+func (m *MemoryStore) GetRacerRedirect(oldRacerID int) (RacerRedirect, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	redirect := RacerRedirect{}
+	for _, r := range m.data.redirects {
+		if r.OldRacerID == oldRacerID && r.ID > redirect.ID {
+			redirect = r
+		}
+	}
+
+	if redirect.ID == 0 {
+		return redirect, ErrRecordNotFoundError
+	}
+	return redirect, nil
+}
+
+//touchRacers gives the racers a new etag after their results or names change
+func (d *memoryData) touchRacers(racerIDs []int) {
+	etag, lastUpdated := newETag(fmt.Sprint(racerIDs))
+
+	for _, id := range racerIDs {
+		if racer, ok := d.racers[id]; ok {
+			racer.ETag = etag
+			racer.LastUpdated = lastUpdated
+			d.racers[id] = racer
+		}
+	}
+}
+
+func (m *MemoryStore) DeleteOrphanRacers() ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	racerIDs := make([]int, 0, len(m.data.racers))
+	for id := range m.data.racers {
+		racerIDs = append(racerIDs, id)
+	}
+	sort.Ints(racerIDs)
+
+	orphanIDs := m.data.orphanRacerIDs(racerIDs)
+	m.data.deleteRacers(orphanIDs)
+	return orphanIDs, nil
+}
+
+//orphanRacerIDs returns the racers, out of the ones given, without any results
+func (d *memoryData) orphanRacerIDs(racerIDs []int) []int {
+	hasResults := map[int]bool{}
+	for _, result := range d.results {
+		hasResults[result.RacerID] = true
+	}
+
+	var orphanIDs []int
+	for _, id := range racerIDs {
+		if _, ok := d.racers[id]; ok && !hasResults[id] {
+			orphanIDs = append(orphanIDs, id)
+		}
+	}
+	return orphanIDs
+}
+
+//deleteRacers removes the racers along with their aliases, the redirects to
+//them and the merges into them
+func (d *memoryData) deleteRacers(racerIDs []int) {
+	removed := map[int]bool{}
+	for _, id := range racerIDs {
+		removed[id] = true
+		delete(d.racers, id)
+	}
+
+	for id, alias := range d.racerAliases {
+		if removed[alias.RacerID] {
+			delete(d.racerAliases, id)
+		}
+	}
+
+	for id, redirect := range d.redirects {
+		if removed[redirect.NewRacerID] {
+			delete(d.redirects, id)
+		}
+	}
+
+	for id, merge := range d.merges {
+		if !removed[merge.ParentRacerID] {
+			continue
+		}
+		for changeID, change := range d.mergeChanges {
+			if change.RacerMergeID == id {
+				delete(d.mergeChanges, changeID)
+			}
+		}
+		delete(d.merges, id)
+	}
+}
+
+//updateBrackets recalculates the brackets of every result of the racers and
+//gives the races whose results changed bracket a new etag
+func (d *memoryData) updateBrackets(racerIDs []int) {
+	birthDates := d.birthDatesForRacers(racerIDs)
+
+	wanted := map[int]bool{}
+	for _, id := range racerIDs {
+		wanted[id] = true
+	}
+
+	changedRaces := map[int]bool{}
+
+	for id, result := range d.results {
+		if !wanted[result.RacerID] {
+			continue
+		}
+		dates, ok := birthDates[result.RacerID]
+		bracket := resultBracket(dates, ok, d.races[result.RaceID].Date, d.ageCategories[result.AgeCategoryID])
+		if bracket == result.Bracket {
+			continue
+		}
+		result.Bracket = bracket
+		d.results[id] = result
+		changedRaces[result.RaceID] = true
+	}
+
+	etag, lastUpdated := newETag(fmt.Sprint(changedRaces))
+	for id := range changedRaces {
+		if race, ok := d.races[id]; ok {
+			race.ETag = etag
+			race.LastUpdated = lastUpdated
+			d.races[id] = race
+		}
+	}
+}
+
+//Results
+
+func (m *MemoryStore) GetRaceResultsForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter) ([]RaceResult, []Racer, []Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.data.races[raceid]
+	if !ok || r.Deleted != nil {
+		return nil, nil, nil, ErrRecordNotFoundError
+	}
+
+	chip := filter.Ranking == RankChipTime
+
+	//by chip time the start position is a place by chip time
+	rows := m.data.sortedResults(func(result RaceResult) bool {
+		return result.RaceID == r.ID && (chip || result.Position >= startPosition) && filter.keep(result, r)
+	})
+
+	if chip {
+		rows = withChipPlaces(rows, m.data.sortedResults(func(result RaceResult) bool { return result.RaceID == r.ID }), startPosition)
+	}
+
+	rows = filter.page(rows, numOfRecords)
+
+	var results []RaceResult
+	var racers []Racer
+	races := []Race{r}
+
+	for _, result := range rows {
+		results = append(results, m.data.withAgeCategory(result))
+		racers = append(racers, Racer{ID: result.RacerID})
+	}
+
+	return results, racers, races, nil
+}
+
+func (m *MemoryStore) GetRaceWinners(raceIDs []int) ([]RaceResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inRaces := map[int]bool{}
+	for _, id := range raceIDs {
+		inRaces[id] = true
+	}
+
+	winners := m.data.sortedResults(func(result RaceResult) bool {
+		return inRaces[result.RaceID] && (result.Position == 1 || result.SexPosition == 1)
+	})
+	sort.SliceStable(winners, func(i, j int) bool {
+		if winners[i].RaceID != winners[j].RaceID {
+			return winners[i].RaceID < winners[j].RaceID
+		}
+		return winners[i].Position < winners[j].Position
+	})
+
+	return winners, nil
+}
+
+func (m *MemoryStore) CountRaceResultsForRace(raceid int, filter ResultFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.data.races[raceid]
+	if !ok || r.Deleted != nil {
+		return 0, nil
+	}
+
+	rows := m.data.sortedResults(func(result RaceResult) bool {
+		return result.RaceID == r.ID && filter.keep(result, r)
+	})
+
+	return len(rows), nil
+}
+
+func (m *MemoryStore) GetRaceResultsForRacer(racerid uint, filter ResultFilter) ([]RaceResult, []Racer, []Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.data.racers[int(racerid)]
+
+	rows := m.data.sortedResults(func(result RaceResult) bool {
+		race, ok := m.data.races[result.RaceID]
+		return ok && result.RacerID == r.ID && filter.keep(result, race)
+	})
+	sort.SliceStable(rows, func(i, j int) bool {
+		return m.data.races[rows[i].RaceID].Date.After(m.data.races[rows[j].RaceID].Date)
+	})
+
+	var results []RaceResult
+	var races []Race
+	racers := []Racer{r}
+
+	for _, result := range rows {
+		race := m.data.races[result.RaceID]
+		results = append(results, m.data.withAgeCategory(result))
+		races = append(races, Race{ID: race.ID, Name: race.Name, Date: race.Date, RaceGroupID: race.RaceGroupID, Imported: race.Imported})
+	}
+
+	return results, racers, races, nil
+}
+
+func (m *MemoryStore) EachRaceResultForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter, fn func(result RaceResult, race Race) error) error {
+	results, _, races, err := m.GetRaceResultsForRace(raceid, startPosition, numOfRecords, filter)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := fn(result, races[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) EachRaceResultForRacer(racerid uint, filter ResultFilter, fn func(result RaceResult, race Race) error) error {
+	results, _, races, err := m.GetRaceResultsForRacer(racerid, filter)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		if err := fn(results[i], races[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetRaceResults(filter ResultFilter) ([]RaceResult, []Racer, []Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows := m.data.sortedResults(func(result RaceResult) bool {
+		race, ok := m.data.races[result.RaceID]
+		return ok && filter.keep(result, race)
+	})
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := m.data.races[rows[i].RaceID], m.data.races[rows[j].RaceID]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return rows[i].Position < rows[j].Position
+	})
+
+	var results []RaceResult
+	var racers []Racer
+	var races []Race
+
+	seenRacers := map[int]bool{}
+	seenRaces := map[int]bool{}
+
+	for _, result := range rows {
+		results = append(results, m.data.withAgeCategory(result))
+
+		if !seenRacers[result.RacerID] {
+			seenRacers[result.RacerID] = true
+			racers = append(racers, Racer{ID: result.RacerID})
+		}
+
+		if !seenRaces[result.RaceID] {
+			seenRaces[result.RaceID] = true
+			race := m.data.races[result.RaceID]
+			races = append(races, Race{ID: race.ID, Name: race.Name, Date: race.Date, RaceGroupID: race.RaceGroupID, Imported: race.Imported})
+		}
+	}
+
+	return results, racers, races, nil
+}
+
+//Import tasks
+
+func (m *MemoryStore) CreateImportTask(url string) (ImportTask, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	race := Race{ID: m.data.nextID("race"), Name: "Pending", ImportStatus: "pending", SrcUrl: url, Date: time.Now(), LastUpdated: time.Now()}
+	m.data.races[race.ID] = race
+
+	task := ImportTask{ID: m.data.nextID("import_task"), RaceID: race.ID, Status: "pending", SrcUrl: url}
+	m.data.tasks[task.ID] = task
+
+	return task, nil
+}
+
+func (m *MemoryStore) GetImportTask(id int) (ImportTask, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.data.tasks[id]
+	if !ok {
+		return task, ErrRecordNotFoundError
+	}
+	return task, nil
+}
+
+func (m *MemoryStore) GetPendingImportTasks() ([]ImportTask, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tasks := []ImportTask{}
+	for _, task := range m.data.tasks {
+		if task.Status == "pending" {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks, nil
+}
+
+func (m *MemoryStore) FailedImport(task ImportTask, importErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task.Status = "failed"
+	task.ErrorText = importErr.Error()
+	m.data.tasks[task.ID] = task
+
+	for id, result := range m.data.results {
+		if result.RaceID == task.RaceID {
+			delete(m.data.results, id)
+		}
+	}
+
+	delete(m.data.races, task.RaceID)
+	return nil
+}
+
+//SaveRace matches the results to racers the same way Db does.  Nothing is
+//saved when the race fails to save.
+func (m *MemoryStore) SaveRace(task ImportTask, r *model.RaceDetails) (Race, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var race Race
+
+	err := m.transaction(func(d *memoryData) error {
+		var ok bool
+		if race, ok = d.races[task.RaceID]; !ok {
+			return ErrRecordNotFoundError
+		}
+
+		cats := d.ageCategoryLookup()
+
+		raceDate := time.Date(r.Year, time.Month(r.Month), r.Day, 0, 0, 0, 0, time.UTC)
+		race.Name = r.Name
+		race.Date = raceDate
+		d.races[race.ID] = race
+
+		var keys []string
+		seenKeys := map[string]bool{}
+		for i := range r.Racers {
+			key := names.Key(r.Racers[i].Name)
+			if len(key) > 0 && !seenKeys[key] {
+				seenKeys[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		candidates := d.racerNameCandidates(keys)
+
+		var candidateIds []int
+		for _, key := range keys {
+			for i := range candidates[key] {
+				candidateIds = append(candidateIds, candidates[key][i].RacerID)
+			}
+		}
+
+		birthDates := d.birthDatesForRacers(candidateIds)
+
+		inRace := map[int]bool{}
+		var racerIDs []int
+
+		for i := range r.Racers {
+			mRacer := r.Racers[i]
+
+			cat, knownCat := cats.find(mRacer.AgeCategory)
+
+			racerID, err := matchRacer(mRacer, candidates[names.Key(mRacer.Name)], inRace, birthDates, raceDate, cat, knownCat)
+
+			if err != nil {
+				return err
+			}
+
+			//must be a new racer.. no racer with that name or none that match
+			if racerID == 0 {
+				racerID = d.nextID("racer")
+				d.racers[racerID] = Racer{ID: racerID, Created: time.Now()}
+			}
+
+			inRace[racerID] = true
+			racerIDs = append(racerIDs, racerID)
+
+			result := RaceResult{
+				ID:                  d.nextID("race_result"),
+				RaceID:              race.ID,
+				RacerID:             racerID,
+				Name:                mRacer.Name,
+				NameKey:             names.Key(mRacer.Name),
+				Position:            mRacer.Position,
+				BibNumber:           mRacer.BibNumber,
+				SexPosition:         mRacer.SexPosition,
+				AgeCategoryPosition: mRacer.AgeCategoryPosition,
+				AgeCategoryID:       cat.ID,
+				Time:                mRacer.Time,
+				TimeMs:              mRacer.TimeMs,
+				ChipTime:            mRacer.ChipTime,
+				ChipTimeMs:          mRacer.ChipTimeMs,
+				Sex:                 mRacer.Sex,
+				Club:                mRacer.Club,
+			}
+			d.results[result.ID] = result
+		}
+
+		d.updateBrackets(racerIDs)
+		d.touchRacers(racerIDs)
+
+		t := time.Now()
+
+		h := sha1.New()
+		h.Write([]byte(race.Name + race.Date.String() + t.String()))
+
+		race.ImportStatus = "completed"
+		race.LastUpdated = t
+		race.Imported = &t
+		race.ETag = hex.EncodeToString(h.Sum(nil))
+		d.races[race.ID] = race
+
+		task.Status = "completed"
+		d.tasks[task.ID] = task
+
+		return nil
+	})
+
+	return race, err
+}
+
+//Age categories
+
+func (m *MemoryStore) FindAgeCategory(name string) (AgeCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if category, ok := m.data.ageCategoryLookup().find(name); ok {
+		return category, nil
+	}
+	return AgeCategory{}, ErrRecordNotFoundError
+}
+
+func (m *MemoryStore) GetAgeCategories() ([]AgeCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	categories := []AgeCategory{}
+	for _, category := range m.data.ageCategories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if a.MinAge != b.MinAge {
+			return a.MinAge < b.MinAge
+		}
+		if a.MaxAge != b.MaxAge {
+			return a.MaxAge < b.MaxAge
+		}
+		return a.ID < b.ID
+	})
+	return categories, nil
+}
+
+func (m *MemoryStore) GetAgeCategory(id int) (AgeCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	category, ok := m.data.ageCategories[id]
+	if !ok {
+		return category, ErrRecordNotFoundError
+	}
+	return category, nil
+}
+
+func (m *MemoryStore) GetAgeCategoryAliases() ([]AgeCategoryAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	aliases := m.data.sortedAgeCategoryAliases(func(AgeCategoryAlias) bool { return true })
+	sort.SliceStable(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+	return aliases, nil
+}
+
+func (m *MemoryStore) GetAliasesForAgeCategory(categoryID int) ([]AgeCategoryAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	aliases := m.data.sortedAgeCategoryAliases(func(alias AgeCategoryAlias) bool { return alias.AgeCategoryID == categoryID })
+	sort.SliceStable(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+	return aliases, nil
+}
+
+func (m *MemoryStore) CreateAgeCategory(name string, minAge int, maxAge int, aliases []string) (AgeCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	category := AgeCategory{Name: strings.TrimSpace(name), MinAge: minAge, MaxAge: maxAge}
+
+	if err := validateAgeCategory(category); err != nil {
+		return category, err
+	}
+
+	err := m.transaction(func(d *memoryData) error {
+		if _, ok := d.ageCategoryLookup().find(category.Name); ok {
+			return ErrAgeCategoryExists
+		}
+
+		category.ID = d.nextID("age_category")
+		d.ageCategories[category.ID] = category
+
+		for i := range aliases {
+			if _, err := d.createAgeCategoryAlias(category, aliases[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return category, err
+}
+
+func (m *MemoryStore) UpdateAgeCategory(id int, name string, minAge int, maxAge int) (AgeCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	category, ok := m.data.ageCategories[id]
+	if !ok {
+		return category, ErrRecordNotFoundError
+	}
+
+	category.Name = strings.TrimSpace(name)
+	category.MinAge = minAge
+	category.MaxAge = maxAge
+
+	if err := validateAgeCategory(category); err != nil {
+		return category, err
+	}
+
+	if existing, ok := m.data.ageCategoryLookup().find(category.Name); ok && existing.ID != category.ID {
+		return category, ErrAgeCategoryExists
+	}
+
+	m.data.ageCategories[id] = category
+
+	//new ages change what is known about the racers in the category
+	var racerIDs []int
+	for _, result := range m.data.results {
+		if result.AgeCategoryID == id {
+			racerIDs = append(racerIDs, result.RacerID)
+		}
+	}
+	m.data.updateBrackets(racerIDs)
+	m.data.touchRacers(racerIDs)
+
+	return category, nil
+}
+
+func (m *MemoryStore) DeleteAgeCategory(id int) (AgeCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	category, ok := m.data.ageCategories[id]
+	if !ok {
+		return category, ErrRecordNotFoundError
+	}
+
+	for _, result := range m.data.results {
+		if result.AgeCategoryID == id {
+			return category, ErrAgeCategoryInUse
+		}
+	}
+
+	for aliasID, alias := range m.data.ageCategoryAliases {
+		if alias.AgeCategoryID == id {
+			delete(m.data.ageCategoryAliases, aliasID)
+		}
+	}
+	delete(m.data.ageCategories, id)
+
+	return category, nil
+}
+
+func (m *MemoryStore) CreateAgeCategoryAlias(category AgeCategory, name string) (AgeCategoryAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.data.createAgeCategoryAlias(category, name)
+}
+
+func (d *memoryData) createAgeCategoryAlias(category AgeCategory, name string) (AgeCategoryAlias, error) {
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 {
+		return AgeCategoryAlias{}, ErrInvalidAgeCategory
+	}
+
+	for _, alias := range d.sortedAgeCategoryAliases(func(alias AgeCategoryAlias) bool { return alias.AgeCategoryID == category.ID }) {
+		if normalizeAgeCategoryName(alias.Name) == normalizeAgeCategoryName(name) {
+			return alias, nil
+		}
+	}
+
+	if _, ok := d.ageCategoryLookup().find(name); ok {
+		return AgeCategoryAlias{}, ErrAgeCategoryExists
+	}
+
+	alias := AgeCategoryAlias{ID: d.nextID("age_category_alias"), AgeCategoryID: category.ID, Name: name}
+	d.ageCategoryAliases[alias.ID] = alias
+	return alias, nil
+}
+
+func (m *MemoryStore) DeleteAgeCategoryAlias(categoryID int, id int) (AgeCategoryAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alias, ok := m.data.ageCategoryAliases[id]
+	if !ok || alias.AgeCategoryID != categoryID {
+		return AgeCategoryAlias{}, ErrRecordNotFoundError
+	}
+
+	delete(m.data.ageCategoryAliases, id)
+	return alias, nil
+}
+
+//Audit
+
+func (m *MemoryStore) RecordAudit(entry AuditEntry) (AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.ID = m.data.nextID("audit_entry")
+	entry.Created = time.Now()
+	m.data.auditEntries[entry.ID] = entry
+	return entry, nil
+}
+
+func (m *MemoryStore) GetAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range m.data.auditEntries {
+		if filter.keep(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+//keep matches the filter against an entry, as GetAuditEntries does
+func (f AuditFilter) keep(entry AuditEntry) bool {
+	if len(f.Entity) > 0 && entry.Entity != f.Entity {
+		return false
+	}
+	if f.EntityID > 0 && entry.EntityID != f.EntityID {
+		return false
+	}
+	if len(f.Actor) > 0 && entry.Actor != f.Actor {
+		return false
+	}
+	if len(f.Action) > 0 && entry.Action != f.Action {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,518 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/names"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MergeConflictSameRace   = "same-race"
+	MergeConflictBirthDates = "birth-dates"
+)
+
+//the changes a merge makes, recorded so that it can be undone
+const (
+	mergeChangeResult       = "result"
+	mergeChangeAlias        = "alias"
+	mergeChangeAliasCreated = "alias-created"
+	mergeChangeRedirect     = "redirect"
+)
+
+var ErrMergeUndone = newError(KindConflict, "Merge has already been undone")
+
+// MergeConflict is a reason the two racers may not be the same person
+type MergeConflict struct {
+	Type        string
+	RaceID      int
+	Description string
+}
+
+// RacerMergePreview describes what merging the racer into the parent racer would do
+type RacerMergePreview struct {
+	Racer        Racer
+	MergedRacer  Racer
+	ResultsMoved int
+	Conflicts    []MergeConflict
+}
+
+//CanMerge reports whether the merge can go ahead.  Racers who ran the same
+//race are never merged, contradicting birth dates can be overridden with force.
+func (p RacerMergePreview) CanMerge(force bool) bool {
+	for i := range p.Conflicts {
+		if p.Conflicts[i].Type == MergeConflictSameRace || !force {
+			return false
+		}
+	}
+	return true
+}
+
+//PreviewRacerMerge lists the conflicts found when merging the racer into the parent racer
+func (db *Db) PreviewRacerMerge(parentRacer Racer, racer Racer) (RacerMergePreview, error) {
+
+	preview := RacerMergePreview{Racer: parentRacer, MergedRacer: racer}
+
+	if err := db.orm.Model(&RaceResult{}).Where("racer_id = ?", racer.ID).Count(&preview.ResultsMoved).Error; err != nil {
+		return preview, wrapError("PreviewRacerMerge", err)
+	}
+
+	var parentResultCount int
+	if err := db.orm.Model(&RaceResult{}).Where("racer_id = ?", parentRacer.ID).Count(&parentResultCount).Error; err != nil {
+		return preview, wrapError("PreviewRacerMerge", err)
+	}
+
+	rows, err := db.orm.Raw("SELECT race.id, race.name FROM race_result JOIN race ON race.id = race_result.race_id JOIN race_result other ON other.race_id = race_result.race_id WHERE race_result.racer_id = ? AND other.racer_id = ? GROUP BY race.id, race.name", parentRacer.ID, racer.ID).Rows()
+
+	if err != nil {
+		return preview, wrapError("PreviewRacerMerge", err)
+	}
+
+	for rows.Next() {
+		var raceID int
+		var raceName string
+		if err := rows.Scan(&raceID, &raceName); err != nil {
+			rows.Close()
+			return preview, wrapError("PreviewRacerMerge", err)
+		}
+		preview.Conflicts = append(preview.Conflicts, MergeConflict{
+			Type:        MergeConflictSameRace,
+			RaceID:      raceID,
+			Description: fmt.Sprintf("both racers have results in %s", raceName),
+		})
+	}
+	rows.Close()
+
+	//a racer without results has no birth date range to contradict
+	if preview.ResultsMoved > 0 && parentResultCount > 0 {
+		parentLow, parentHigh, err := db.GetRacerBirthDates(parentRacer.ID)
+		if err != nil {
+			return preview, wrapError("PreviewRacerMerge", err)
+		}
+
+		low, high, err := db.GetRacerBirthDates(racer.ID)
+		if err != nil {
+			return preview, wrapError("PreviewRacerMerge", err)
+		}
+
+		if conflict, ok := birthDateConflict(parentLow, parentHigh, low, high); ok {
+			preview.Conflicts = append(preview.Conflicts, conflict)
+		}
+	}
+
+	return preview, nil
+}
+
+//birthDateConflict reports birth date ranges that don't overlap
+func birthDateConflict(parentLow time.Time, parentHigh time.Time, low time.Time, high time.Time) (MergeConflict, bool) {
+	if low.After(parentHigh) || parentLow.After(high) {
+		return MergeConflict{
+			Type: MergeConflictBirthDates,
+			Description: fmt.Sprintf("birth date ranges %s to %s and %s to %s do not overlap",
+				parentLow.Format("2006-01-02"), parentHigh.Format("2006-01-02"),
+				low.Format("2006-01-02"), high.Format("2006-01-02")),
+		}, true
+	}
+	return MergeConflict{}, false
+}
+
+//MergeRacers moves the racer's results and names to the parent racer and
+//removes the racer.  Requests for the removed racer are redirected to the
+//parent.  The conflicts are checked in the same transaction as the merge, and
+//returned with ErrMergeConflict when they prevent it.  ResultsMoved is the
+//number of results the merge moved.
+func (db *Db) MergeRacers(parentRacer Racer, racer Racer, force bool) (RacerMergePreview, error) {
+
+	var preview RacerMergePreview
+
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.lockRacers(parentRacer.ID, racer.ID); err != nil {
+			return err
+		}
+
+		var err error
+		if preview, err = tx.PreviewRacerMerge(parentRacer, racer); err != nil {
+			return err
+		}
+
+		if !preview.CanMerge(force) {
+			return ErrMergeConflict
+		}
+
+		//the names the merged racer raced under become aliases of the parent
+		racerNames, err := tx.GetRacerNames(racer.ID)
+		if err != nil {
+			return err
+		}
+
+		parentNames, err := tx.GetRacerNames(parentRacer.ID)
+		if err != nil {
+			return err
+		}
+
+		aliases, err := tx.GetRacerAliases(racer.ID)
+		if err != nil {
+			return err
+		}
+
+		if preview.ResultsMoved, err = mergeRacers(&tx.orm, parentRacer, racer, racerNames, parentNames, aliases); err != nil {
+			return err
+		}
+		if err := tx.updateBrackets([]int{parentRacer.ID}); err != nil {
+			return err
+		}
+		//the merged racer is gone from the suggestions
+		tx.racersChanged([]int{racer.ID})
+		return tx.touchRacers([]int{parentRacer.ID})
+	})
+
+	return preview, wrapError("MergeRacers", err)
+}
+
+//lockRacers locks the racers' rows until the transaction ends, so changes to
+//the same racers wait their turn rather than acting on what they read before
+//the other committed.  SQLite only lets one transaction write at a time.  A
+//racer that is gone by then is not found.
+func (db *Db) lockRacers(racerIDs ...int) error {
+	query := "SELECT id FROM racer WHERE id IN (?)"
+	if db.dialect != "sqlite3" {
+		query += " FOR UPDATE"
+	}
+
+	rows, err := db.orm.Raw(query, racerIDs).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range racerIDs {
+		if !found[id] {
+			return ErrRecordNotFoundError
+		}
+	}
+	return nil
+}
+
+func mergeRacers(tx *gorm.DB, parentRacer Racer, racer Racer, racerNames []string, parentNames []string, aliases []RacerAlias) (int, error) {
+
+	merge := RacerMerge{ParentRacerID: parentRacer.ID, MergedRacerID: racer.ID, MergedRacerCreated: racer.Created, Created: time.Now()}
+	if err := tx.Create(&merge).Error; err != nil {
+		return 0, err
+	}
+
+	//remember what is about to move
+	var resultIds, redirectIds []int
+	if err := tx.Model(&RaceResult{}).Where("racer_id = ?", racer.ID).Pluck("id", &resultIds).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&RacerRedirect{}).Where("new_racer_id = ?", racer.ID).Pluck("id", &redirectIds).Error; err != nil {
+		return 0, err
+	}
+
+	changes := map[string][]int{mergeChangeResult: resultIds, mergeChangeRedirect: redirectIds}
+	for i := range aliases {
+		changes[mergeChangeAlias] = append(changes[mergeChangeAlias], aliases[i].ID)
+	}
+
+	//move over any aliases the merged racer already had
+	if err := tx.Exec("UPDATE racer_alias SET racer_id=? WHERE racer_id =?", parentRacer.ID, racer.ID).Error; err != nil {
+		return 0, err
+	}
+
+	for i := range racerNames {
+		if containsName(parentNames, racerNames[i]) || containsAlias(aliases, racerNames[i]) {
+			continue
+		}
+		alias := RacerAlias{RacerID: parentRacer.ID, Name: racerNames[i], NameKey: names.Key(racerNames[i]), Created: time.Now()}
+		if err := tx.Create(&alias).Error; err != nil {
+			return 0, err
+		}
+		changes[mergeChangeAliasCreated] = append(changes[mergeChangeAliasCreated], alias.ID)
+	}
+
+	//update all race results with the new id
+	update := tx.Exec("UPDATE race_result SET racer_id=? WHERE racer_id =?", parentRacer.ID, racer.ID)
+	if update.Error != nil {
+		return 0, update.Error
+	}
+	moved := int(update.RowsAffected)
+
+	//earlier merges into the racer now lead to the parent
+	if err := tx.Exec("UPDATE racer_redirect SET new_racer_id=? WHERE new_racer_id =?", parentRacer.ID, racer.ID).Error; err != nil {
+		return 0, err
+	}
+
+	redirect := RacerRedirect{OldRacerID: racer.ID, NewRacerID: parentRacer.ID, Created: time.Now()}
+	if err := tx.Create(&redirect).Error; err != nil {
+		return 0, err
+	}
+
+	for _, kind := range []string{mergeChangeResult, mergeChangeAlias, mergeChangeAliasCreated, mergeChangeRedirect} {
+		for _, id := range changes[kind] {
+			change := RacerMergeChange{RacerMergeID: merge.ID, Kind: kind, ItemID: id}
+			if err := tx.Create(&change).Error; err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return moved, tx.Delete(&racer).Error
+}
+
+//GetRacerMerges returns the merges into the racer, newest first.  Merges into
+//racers that were since merged into the racer are included.
+func (db *Db) GetRacerMerges(parentRacerID int) ([]RacerMerge, error) {
+	merges := []RacerMerge{}
+
+	parentIDs, err := db.mergedRacerIDs(parentRacerID)
+	if err != nil {
+		return merges, wrapError("GetRacerMerges", err)
+	}
+
+	if err := db.orm.Where("parent_racer_id IN (?)", parentIDs).Order("id desc").Find(&merges).Error; err != nil {
+		return merges, wrapError("GetRacerMerges", err)
+	}
+	return merges, nil
+}
+
+//GetRacerMerge returns a merge into the racer, or into a racer since merged into it
+func (db *Db) GetRacerMerge(parentRacerID int, id int) (RacerMerge, error) {
+	merge := RacerMerge{}
+
+	parentIDs, err := db.mergedRacerIDs(parentRacerID)
+	if err != nil {
+		return merge, wrapError("GetRacerMerge", err)
+	}
+
+	if err := db.orm.Where("parent_racer_id IN (?)", parentIDs).First(&merge, id).Error; err != nil {
+		return merge, wrapError("GetRacerMerge", err)
+	}
+	return merge, nil
+}
+
+//mergedRacerIDs is the racer and every racer merged into it, directly or
+//through other merges.  The redirects of earlier merges are moved along to the
+//racer each merge ends up in.
+func (db *Db) mergedRacerIDs(racerID int) ([]int, error) {
+	var ids []int
+	if err := db.orm.Model(&RacerRedirect{}).Where("new_racer_id = ?", racerID).Pluck("old_racer_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return append([]int{racerID}, ids...), nil
+}
+
+//currentRacerID is the racer holding the racer's results, the racer it was
+//merged into when it is gone
+func (db *Db) currentRacerID(racerID int) (int, error) {
+	var count int
+	if err := db.orm.Model(&Racer{}).Where("id = ?", racerID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return racerID, nil
+	}
+
+	redirect, err := db.GetRacerRedirect(racerID)
+	if err != nil {
+		return 0, err
+	}
+	return redirect.NewRacerID, nil
+}
+
+//UnmergeRacers undoes a merge.  The merged racer is restored with its original
+//id, and gets back the results and aliases it brought that the parent still
+//has.  When the parent has since been merged into another racer, they are
+//taken back from that racer.
+func (db *Db) UnmergeRacers(merge RacerMerge) (Racer, int, error) {
+
+	racer := Racer{ID: merge.MergedRacerID, Created: merge.MergedRacerCreated}
+
+	moved := 0
+
+	err := db.transaction(func(tx *Db) error {
+		parentID, err := tx.currentRacerID(merge.ParentRacerID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.lockRacers(parentID); err != nil {
+			return err
+		}
+
+		//the merge as it is now, in case it was undone meanwhile
+		if err := tx.orm.First(&merge, merge.ID).Error; err != nil {
+			return err
+		}
+		if merge.Undone != nil {
+			return ErrMergeUndone
+		}
+
+		changes := []RacerMergeChange{}
+		if err := tx.orm.Where("racer_merge_id = ?", merge.ID).Find(&changes).Error; err != nil {
+			return err
+		}
+
+		items := map[string][]int{}
+		for i := range changes {
+			items[changes[i].Kind] = append(items[changes[i].Kind], changes[i].ItemID)
+		}
+
+		if moved, err = unmergeRacers(&tx.orm, merge, parentID, racer, items); err != nil {
+			return err
+		}
+		if err := tx.updateBrackets([]int{parentID, racer.ID}); err != nil {
+			return err
+		}
+		return tx.touchRacers([]int{parentID, racer.ID})
+	})
+
+	if err != nil {
+		return racer, 0, wrapError("UnmergeRacers", err)
+	}
+
+	return racer, moved, nil
+}
+
+func unmergeRacers(tx *gorm.DB, merge RacerMerge, parentID int, racer Racer, items map[string][]int) (int, error) {
+
+	if err := tx.Create(&racer).Error; err != nil {
+		return 0, err
+	}
+
+	moved := 0
+
+	if ids := items[mergeChangeResult]; len(ids) > 0 {
+		update := tx.Exec("UPDATE race_result SET racer_id=? WHERE racer_id =? AND id IN (?)", racer.ID, parentID, ids)
+		if update.Error != nil {
+			return 0, update.Error
+		}
+		moved = int(update.RowsAffected)
+	}
+
+	if ids := items[mergeChangeAlias]; len(ids) > 0 {
+		if err := tx.Exec("UPDATE racer_alias SET racer_id=? WHERE racer_id =? AND id IN (?)", racer.ID, parentID, ids).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if ids := items[mergeChangeAliasCreated]; len(ids) > 0 {
+		if err := tx.Exec("DELETE FROM racer_alias WHERE id IN (?)", ids).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if ids := items[mergeChangeRedirect]; len(ids) > 0 {
+		if err := tx.Exec("UPDATE racer_redirect SET new_racer_id=? WHERE id IN (?)", racer.ID, ids).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Exec("DELETE FROM racer_redirect WHERE old_racer_id = ?", racer.ID).Error; err != nil {
+		return 0, err
+	}
+
+	undone := time.Now()
+	merge.Undone = &undone
+	if err := tx.Save(&merge).Error; err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
+
+//SplitRaceResults moves the racer's results to another racer, or to a new
+//racer when no racer is given.  Used to separate people with the same name.
+//It returns the racer the results were moved to and how many were moved.
+func (db *Db) SplitRaceResults(racer Racer, resultIds []int, target *Racer) (Racer, int, error) {
+
+	newRacer := Racer{Created: time.Now()}
+	lockIDs := []int{racer.ID}
+	if target != nil {
+		newRacer = *target
+		lockIDs = append(lockIDs, target.ID)
+	}
+
+	moved := 0
+
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.lockRacers(lockIDs...); err != nil {
+			return err
+		}
+
+		var results []RaceResult
+		if err := tx.orm.Where("racer_id = ? AND id IN (?)", racer.ID, resultIds).Find(&results).Error; err != nil {
+			return err
+		}
+
+		if len(results) == 0 || len(results) != len(resultIds) {
+			return ErrRecordNotFoundError
+		}
+
+		if target == nil {
+			if err := tx.orm.Create(&newRacer).Error; err != nil {
+				return err
+			}
+		} else {
+			//the target can't have run the same races
+			raceIds := make([]int, len(results))
+			for i := range results {
+				raceIds[i] = results[i].RaceID
+			}
+			var count int
+			if err := tx.orm.Model(&RaceResult{}).Where("racer_id = ? AND race_id IN (?)", target.ID, raceIds).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrMergeConflict
+			}
+		}
+
+		update := tx.orm.Exec("UPDATE race_result SET racer_id=? WHERE racer_id =? AND id IN (?)", newRacer.ID, racer.ID, resultIds)
+		if update.Error != nil {
+			return update.Error
+		}
+		moved = int(update.RowsAffected)
+
+		if err := tx.updateBrackets([]int{racer.ID, newRacer.ID}); err != nil {
+			return err
+		}
+		return tx.touchRacers([]int{racer.ID, newRacer.ID})
+	})
+
+	if err != nil {
+		return newRacer, 0, wrapError("SplitRaceResults", err)
+	}
+
+	return newRacer, moved, nil
+}
+
+//GetRacerRedirect returns the racer that a merged racer now lives on as
+func (db *Db) GetRacerRedirect(oldRacerID int) (RacerRedirect, error) {
+	redirect := RacerRedirect{}
+	if err := db.orm.Where("old_racer_id = ?", oldRacerID).Order("id desc").First(&redirect).Error; err != nil {
+		return redirect, wrapError("GetRacerRedirect", err)
+	}
+	return redirect, nil
+}
+
+func containsAlias(aliases []RacerAlias, name string) bool {
+	for i := range aliases {
+		if aliases[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
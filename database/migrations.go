@@ -0,0 +1,808 @@
+package database
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/model"
+	"github.com/chiefwhitecloud/running-man/names"
+	"github.com/jinzhu/gorm"
+)
+
+//SchemaMigration records a migration that has been applied.  The ID is the
+//migration version.
+type SchemaMigration struct {
+	ID      int
+	Name    string
+	Applied time.Time
+}
+
+//MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version int
+	Name    string
+	Applied *time.Time
+}
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+	down    func(tx *gorm.DB) error
+}
+
+//The tables as each migration left them.  The migrations change the schema
+//through these rather than the models, so what a migration does doesn't
+//change as the models do.  A migration that adds columns to a table has its
+//own struct holding just those columns.
+
+type importTaskV1 struct {
+	ID        int
+	RaceID    int
+	Status    string
+	SrcUrl    string
+	ErrorText string
+}
+
+func (importTaskV1) TableName() string { return "import_task" }
+
+type racerV1 struct {
+	ID      int
+	Created time.Time
+}
+
+func (racerV1) TableName() string { return "racer" }
+
+type raceGroupV1 struct {
+	ID           int
+	Name         string
+	Distance     string `gorm:"size:10"`
+	DistanceUnit string `gorm:"size:1"`
+	ETag         string
+	LastUpdated  time.Time
+}
+
+func (raceGroupV1) TableName() string { return "race_group" }
+
+type raceV1 struct {
+	ID           int
+	Name         string
+	Date         time.Time
+	RaceGroupID  int `sql:"index"`
+	ImportStatus string
+	SrcUrl       string
+	ETag         string
+	LastUpdated  time.Time
+}
+
+func (raceV1) TableName() string { return "race" }
+
+type raceResultV1 struct {
+	ID                  int
+	Name                string
+	Position            int
+	SexPosition         int
+	AgeCategoryPosition int
+	RaceID              int `sql:"index"`
+	RacerID             int `sql:"index"`
+	AgeCategoryID       int `sql:"index"`
+	BibNumber           string
+	Time                string
+	ChipTime            string
+	Sex                 string
+	Club                string
+}
+
+func (raceResultV1) TableName() string { return "race_result" }
+
+type ageCategoryV1 struct {
+	ID   int
+	Name string
+}
+
+func (ageCategoryV1) TableName() string { return "age_category" }
+
+//ageCategorySeedV2 records the age categories migration 2 added, so rolling
+//it back removes those and not the ones that were already there
+type ageCategorySeedV2 struct {
+	ID            int
+	AgeCategoryID int
+}
+
+func (ageCategorySeedV2) TableName() string { return "age_category_seed" }
+
+type raceResultV4 struct {
+	ID      int
+	NameKey string `sql:"index"`
+}
+
+func (raceResultV4) TableName() string { return "race_result" }
+
+type racerAliasV4 struct {
+	ID      int
+	RacerID int    `sql:"index"`
+	Name    string `sql:"index"`
+	NameKey string `sql:"index"`
+	Created time.Time
+}
+
+func (racerAliasV4) TableName() string { return "racer_alias" }
+
+type racerRedirectV5 struct {
+	ID         int
+	OldRacerID int `sql:"index"`
+	NewRacerID int
+	Created    time.Time
+}
+
+func (racerRedirectV5) TableName() string { return "racer_redirect" }
+
+type racerMergeV5 struct {
+	ID                 int
+	ParentRacerID      int `sql:"index"`
+	MergedRacerID      int `sql:"index"`
+	MergedRacerCreated time.Time
+	Created            time.Time
+	Undone             *time.Time
+}
+
+func (racerMergeV5) TableName() string { return "racer_merge" }
+
+type racerMergeChangeV5 struct {
+	ID           int
+	RacerMergeID int `sql:"index"`
+	Kind         string
+	ItemID       int
+}
+
+func (racerMergeChangeV5) TableName() string { return "racer_merge_change" }
+
+type raceResultV6 struct {
+	ID         int
+	TimeMs     int
+	ChipTimeMs int
+}
+
+func (raceResultV6) TableName() string { return "race_result" }
+
+type ageCategoryV7 struct {
+	ID     int
+	MinAge int
+	MaxAge int
+}
+
+func (ageCategoryV7) TableName() string { return "age_category" }
+
+type ageCategoryAliasV7 struct {
+	ID            int
+	AgeCategoryID int    `sql:"index"`
+	Name          string `sql:"index"`
+}
+
+func (ageCategoryAliasV7) TableName() string { return "age_category_alias" }
+
+type raceResultV8 struct {
+	ID      int
+	Bracket string `sql:"index"`
+}
+
+func (raceResultV8) TableName() string { return "race_result" }
+
+type racerV9 struct {
+	ID          int
+	ETag        string
+	LastUpdated time.Time
+}
+
+func (racerV9) TableName() string { return "racer" }
+
+type raceV10 struct {
+	ID      int
+	Deleted *time.Time `sql:"index"`
+}
+
+func (raceV10) TableName() string { return "race" }
+
+type raceGroupV10 struct {
+	ID      int
+	Deleted *time.Time `sql:"index"`
+}
+
+func (raceGroupV10) TableName() string { return "race_group" }
+
+type auditEntryV11 struct {
+	ID       int
+	Actor    string
+	Action   string
+	Entity   string `sql:"index"`
+	EntityID int    `sql:"index"`
+	Before   string `sql:"type:text"`
+	After    string `sql:"type:text"`
+	Created  time.Time
+}
+
+func (auditEntryV11) TableName() string { return "audit_entry" }
+
+type raceV12 struct {
+	ID       int
+	Imported *time.Time `sql:"index"`
+}
+
+func (raceV12) TableName() string { return "race" }
+
+//dropColumns removes the columns, and first the indexes on them, from the
+//table of the value
+func dropColumns(tx *gorm.DB, value interface{}, indexes []string, columns ...string) error {
+	for i := range indexes {
+		if err := tx.Model(value).RemoveIndex(indexes[i]).Error; err != nil {
+			return err
+		}
+	}
+	for i := range columns {
+		if err := tx.Model(value).DropColumn(columns[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//migrations are applied in order and never renumbered.  Schema changes and
+//the data fixes that go with them are added to the end of the list.  Each
+//down undoes exactly what its up did.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create tables",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&racerV1{}, &raceV1{}, &raceResultV1{}, &ageCategoryV1{}, &importTaskV1{}, &raceGroupV1{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTable(&racerV1{}, &raceV1{}, &raceResultV1{}, &ageCategoryV1{}, &importTaskV1{}, &raceGroupV1{}).Error
+		},
+	},
+	{
+		version: 2,
+		name:    "seed age categories",
+		up:      seedAgeCategories,
+		down:    unseedAgeCategories,
+	},
+	{
+		version: 3,
+		name:    "remove duplicate age categories",
+		up:      removeDuplicateAgeCategories,
+		down: func(tx *gorm.DB) error {
+			//the duplicates were never meant to exist
+			return nil
+		},
+	},
+	{
+		version: 4,
+		name:    "racer aliases and result name keys",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&raceResultV4{}, &racerAliasV4{}).Error; err != nil {
+				return err
+			}
+			return backfillNameKeys(tx)
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.DropTable(&racerAliasV4{}).Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &raceResultV4{}, []string{"idx_race_result_name_key"}, "name_key")
+		},
+	},
+	{
+		version: 5,
+		name:    "racer merges and redirects",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&racerRedirectV5{}, &racerMergeV5{}, &racerMergeChangeV5{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTable(&racerRedirectV5{}, &racerMergeV5{}, &racerMergeChangeV5{}).Error
+		},
+	},
+	{
+		version: 6,
+		name:    "finish times in milliseconds",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&raceResultV6{}).Error; err != nil {
+				return err
+			}
+			return backfillTimes(tx)
+		},
+		down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &raceResultV6{}, nil, "time_ms", "chip_time_ms")
+		},
+	},
+	{
+		version: 7,
+		name:    "age category ages and aliases",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&ageCategoryV7{}, &ageCategoryAliasV7{}).Error; err != nil {
+				return err
+			}
+			return backfillAgeCategoryAges(tx)
+		},
+		down: func(tx *gorm.DB) error {
+			if err := tx.DropTable(&ageCategoryAliasV7{}).Error; err != nil {
+				return err
+			}
+			return dropColumns(tx, &ageCategoryV7{}, nil, "min_age", "max_age")
+		},
+	},
+	{
+		version: 8,
+		name:    "canonical age brackets",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&raceResultV8{}).Error; err != nil {
+				return err
+			}
+			return backfillBrackets(tx)
+		},
+		down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &raceResultV8{}, []string{"idx_race_result_bracket"}, "bracket")
+		},
+	},
+	{
+		version: 9,
+		name:    "racer etags",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&racerV9{}).Error; err != nil {
+				return err
+			}
+			etag, lastUpdated := migrationETag("racer etags")
+			return tx.Exec("UPDATE racer SET e_tag=?, last_updated=?", etag, lastUpdated).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &racerV9{}, nil, "e_tag", "last_updated")
+		},
+	},
+	{
+		version: 10,
+		name:    "race and race group trash",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&raceV10{}, &raceGroupV10{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			if err := dropColumns(tx, &raceV10{}, []string{"idx_race_deleted"}, "deleted"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &raceGroupV10{}, []string{"idx_race_group_deleted"}, "deleted")
+		},
+	},
+	{
+		version: 11,
+		name:    "audit log",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&auditEntryV11{}).Error
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.DropTable(&auditEntryV11{}).Error
+		},
+	},
+	{
+		version: 12,
+		name:    "race import times",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&raceV12{}).Error; err != nil {
+				return err
+			}
+			//the races already imported were last updated when they were imported, or since
+			return tx.Exec("UPDATE race SET imported = last_updated WHERE import_status = ?", "completed").Error
+		},
+		down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &raceV12{}, []string{"idx_race_imported"}, "imported")
+		},
+	},
+}
+
+var ageCategoryNames = []string{
+	"U20", "-19", "<20",
+	"20-24", "25-29", "20-29",
+	"30-34", "35-39", "30-39",
+	"40-44", "45-49", "40-49",
+	"50-54", "55-59", "50-59",
+	"60-64", "65-69", "60-69",
+	"70-74", "75-79", "70-79",
+	"70+", "80-84", "85-89",
+	"80-89", "80+", "A", "NOAGE",
+}
+
+//ErrSeedNotRecorded is returned rolling back the age category seed of a
+//database seeded before the categories it added were recorded
+var ErrSeedNotRecorded = newError(KindConflict, "The age categories seeded were not recorded, so the seed can't be rolled back")
+
+//seedAgeCategories only adds the categories that are missing so it can run
+//against databases seeded before migrations were recorded.  The categories
+//added are recorded for rolling back.
+func seedAgeCategories(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&ageCategorySeedV2{}).Error; err != nil {
+		return err
+	}
+
+	for i := range ageCategoryNames {
+		var count int
+		if err := tx.Model(&ageCategoryV1{}).Where("name = ?", ageCategoryNames[i]).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		category := ageCategoryV1{Name: ageCategoryNames[i]}
+		if err := tx.Create(&category).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&ageCategorySeedV2{AgeCategoryID: category.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//unseedAgeCategories removes the categories seedAgeCategories added.  The
+//categories that were already there are kept.
+func unseedAgeCategories(tx *gorm.DB) error {
+	if !tx.HasTable(&ageCategorySeedV2{}) {
+		return ErrSeedNotRecorded
+	}
+
+	var categoryIDs []int
+	if err := tx.Model(&ageCategorySeedV2{}).Pluck("age_category_id", &categoryIDs).Error; err != nil {
+		return err
+	}
+
+	for start := 0; start < len(categoryIDs); start += batchSize {
+		if err := tx.Exec("DELETE FROM age_category WHERE id IN (?)", categoryIDs[start:minInt(start+batchSize, len(categoryIDs))]).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.DropTable(&ageCategorySeedV2{}).Error
+}
+
+//removeDuplicateAgeCategories repairs databases where the categories were
+//seeded more than once.  Results are moved to the oldest category of each
+//name before the copies are deleted.
+func removeDuplicateAgeCategories(tx *gorm.DB) error {
+	categories := []ageCategoryV1{}
+	if err := tx.Order("id asc").Find(&categories).Error; err != nil {
+		return err
+	}
+
+	kept := map[string]int{}
+	for i := range categories {
+		keep, ok := kept[categories[i].Name]
+		if !ok {
+			kept[categories[i].Name] = categories[i].ID
+			continue
+		}
+		if err := tx.Exec("UPDATE race_result SET age_category_id=? WHERE age_category_id=?", keep, categories[i].ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&categories[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//legacyAgeCategoryAges are the age ranges of the seeded categories, which
+//used to be hard coded
+var legacyAgeCategoryAges = map[string][2]int{
+	"U20": {5, 19}, "-19": {5, 19}, "<20": {5, 19},
+	"20-24": {20, 24}, "25-29": {25, 29}, "20-29": {20, 29},
+	"30-34": {30, 34}, "35-39": {35, 39}, "30-39": {30, 39},
+	"40-44": {40, 44}, "45-49": {45, 49}, "40-49": {40, 49},
+	"50-54": {50, 54}, "55-59": {55, 59}, "50-59": {50, 59},
+	"60-64": {60, 64}, "65-69": {65, 69}, "60-69": {60, 69},
+	"70-74": {70, 74}, "75-79": {75, 79}, "70-79": {70, 79},
+	"70+": {70, 100}, "80-84": {80, 84}, "85-89": {85, 89},
+	"80-89": {80, 89}, "80+": {80, 100}, "A": {5, 100}, "NOAGE": {5, 100},
+}
+
+//backfillAgeCategoryAges sets the ages of the seeded categories that have none
+func backfillAgeCategoryAges(tx *gorm.DB) error {
+	categories := []ageCategoryV1{}
+	if err := tx.Select("id, name").Where("(min_age IS NULL OR min_age = 0) AND (max_age IS NULL OR max_age = 0)").Find(&categories).Error; err != nil {
+		return err
+	}
+
+	for i := range categories {
+		ages, ok := legacyAgeCategoryAges[categories[i].Name]
+		if !ok {
+			continue
+		}
+		if err := tx.Exec("UPDATE age_category SET min_age=?, max_age=? WHERE id=?", ages[0], ages[1], categories[i].ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//backfillNameKeys sets the phonetic name key on rows saved before the key existed
+func backfillNameKeys(tx *gorm.DB) error {
+	results := []raceResultV1{}
+	if err := tx.Select("id, name").Where("name_key IS NULL OR name_key = ?", "").Find(&results).Error; err != nil {
+		return err
+	}
+	for i := range results {
+		if err := tx.Exec("UPDATE race_result SET name_key=? WHERE id=?", names.Key(results[i].Name), results[i].ID).Error; err != nil {
+			return err
+		}
+	}
+
+	aliases := []racerAliasV4{}
+	if err := tx.Where("name_key IS NULL OR name_key = ?", "").Find(&aliases).Error; err != nil {
+		return err
+	}
+	for i := range aliases {
+		if err := tx.Exec("UPDATE racer_alias SET name_key=? WHERE id=?", names.Key(aliases[i].Name), aliases[i].ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//backfillTimes parses the finish times of the results saved before times were
+//stored in milliseconds.  Times that can't be parsed are left at zero.
+func backfillTimes(tx *gorm.DB) error {
+	results := []raceResultV1{}
+	if err := tx.Select("id, time, chip_time").Where("time_ms IS NULL").Find(&results).Error; err != nil {
+		return err
+	}
+
+	for i := range results {
+		timeMs, _ := model.ParseTime(results[i].Time)
+		chipTimeMs, _ := model.ParseTime(results[i].ChipTime)
+
+		if err := tx.Exec("UPDATE race_result SET time_ms=?, chip_time_ms=? WHERE id=?", timeMs, chipTimeMs, results[i].ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//bracketsV8 are the brackets results were placed in by migration 8, with the
+//youngest and oldest ages in each
+var bracketsV8 = []struct {
+	name string
+	ages [2]int
+}{
+	{"U20", [2]int{0, 19}}, {"20-24", [2]int{20, 24}}, {"25-29", [2]int{25, 29}}, {"30-34", [2]int{30, 34}},
+	{"35-39", [2]int{35, 39}}, {"40-44", [2]int{40, 44}}, {"45-49", [2]int{45, 49}}, {"50-54", [2]int{50, 54}},
+	{"55-59", [2]int{55, 59}}, {"60-64", [2]int{60, 64}}, {"65-69", [2]int{65, 69}}, {"70-74", [2]int{70, 74}},
+	{"75-79", [2]int{75, 79}}, {"80+", [2]int{80, 200}},
+}
+
+type resultV8 struct {
+	id         int
+	racerID    int
+	raceID     int
+	raceDate   time.Time
+	categoryID int
+	minAge     int
+	maxAge     int
+}
+
+//backfillBrackets places every result in the bracket holding the racer's age
+//on the race date, from the birth date range their categories narrow down, or
+//else in the bracket holding the ages of the result's category.  The races
+//with results placed are given a new etag.
+func backfillBrackets(tx *gorm.DB) error {
+	rows, err := tx.Raw("SELECT race_result.id, race_result.racer_id, race_result.race_id, race.date, COALESCE(age_category.id, 0), COALESCE(age_category.min_age, 0), COALESCE(age_category.max_age, 0) FROM race_result JOIN race ON race.id = race_result.race_id LEFT JOIN age_category ON age_category.id = race_result.age_category_id ORDER BY race.date ASC").Rows()
+	if err != nil {
+		return err
+	}
+
+	var results []resultV8
+	birthDates := map[int][2]time.Time{}
+
+	for rows.Next() {
+		var r resultV8
+		if err := rows.Scan(&r.id, &r.racerID, &r.raceID, &r.raceDate, &r.categoryID, &r.minAge, &r.maxAge); err != nil {
+			rows.Close()
+			return err
+		}
+		results = append(results, r)
+
+		if r.categoryID == 0 {
+			continue
+		}
+
+		//the racer was born in the range of each category they raced in
+		low := r.raceDate.AddDate(-r.maxAge-1, 0, 1)
+		high := low.AddDate(r.maxAge-r.minAge+1, 0, -1)
+		if dates, ok := birthDates[r.racerID]; ok {
+			if dates[0].After(low) {
+				low = dates[0]
+			}
+			if dates[1].Before(high) {
+				high = dates[1]
+			}
+		}
+		birthDates[r.racerID] = [2]time.Time{low, high}
+	}
+	rows.Close()
+
+	placed := map[string][]int{}
+	changedRaces := map[int]bool{}
+
+	for _, r := range results {
+		var bracket string
+		if dates, ok := birthDates[r.racerID]; ok && !dates[0].After(dates[1]) {
+			bracket = bracketV8(ageOnDateV8(dates[1], r.raceDate), ageOnDateV8(dates[0], r.raceDate))
+		} else if r.categoryID != 0 && r.maxAge > 0 {
+			bracket = bracketV8(r.minAge, r.maxAge)
+		}
+
+		if len(bracket) > 0 {
+			placed[bracket] = append(placed[bracket], r.id)
+			changedRaces[r.raceID] = true
+		}
+	}
+
+	for bracket, ids := range placed {
+		for start := 0; start < len(ids); start += batchSize {
+			if err := tx.Exec("UPDATE race_result SET bracket=? WHERE id IN (?)", bracket, ids[start:minInt(start+batchSize, len(ids))]).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	raceIDs := make([]int, 0, len(changedRaces))
+	for id := range changedRaces {
+		raceIDs = append(raceIDs, id)
+	}
+
+	etag, lastUpdated := migrationETag("canonical age brackets")
+	for start := 0; start < len(raceIDs); start += batchSize {
+		if err := tx.Exec("UPDATE race SET e_tag=?, last_updated=? WHERE id IN (?)", etag, lastUpdated, raceIDs[start:minInt(start+batchSize, len(raceIDs))]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//bracketV8 is the bracket holding both ages, or an empty string when they
+//fall in different brackets
+func bracketV8(minAge int, maxAge int) string {
+	for _, bracket := range bracketsV8 {
+		if minAge >= bracket.ages[0] && maxAge <= bracket.ages[1] {
+			return bracket.name
+		}
+	}
+	return ""
+}
+
+//ageOnDateV8 is the age on the date of someone born on the birth date
+func ageOnDateV8(birthDate time.Time, date time.Time) int {
+	age := date.Year() - birthDate.Year()
+	if date.Month() < birthDate.Month() || (date.Month() == birthDate.Month() && date.Day() < birthDate.Day()) {
+		age--
+	}
+	return age
+}
+
+//migrationETag is a new etag for the rows a migration changed, and the time
+//they were last updated
+func migrationETag(name string) (string, time.Time) {
+	t := time.Now()
+	h := sha1.New()
+	h.Write([]byte(name + t.String()))
+	return hex.EncodeToString(h.Sum(nil)), t
+}
+
+//Migrate applies every migration that has not been recorded yet, each in its
+//own transaction.  It returns the migrations that were applied.
+func (db *Db) Migrate() ([]SchemaMigration, error) {
+	if err := db.orm.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, wrapError("Migrate", err)
+	}
+
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, wrapError("Migrate", err)
+	}
+
+	var done []SchemaMigration
+
+	for i := range migrations {
+		if _, ok := applied[migrations[i].version]; ok {
+			continue
+		}
+
+		record := SchemaMigration{ID: migrations[i].version, Name: migrations[i].name, Applied: time.Now()}
+
+		tx := db.orm.Begin()
+		if err := migrations[i].up(tx); err != nil {
+			tx.Rollback()
+			return done, wrapError("Migrate", err)
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			return done, wrapError("Migrate", err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return done, wrapError("Migrate", err)
+		}
+
+		done = append(done, record)
+	}
+
+	//the migrations may have changed the racers without reindexing them
+	if len(done) > 0 {
+		db.resetSuggestions()
+	}
+
+	return done, nil
+}
+
+//Rollback undoes the most recently applied migration
+func (db *Db) Rollback() (SchemaMigration, error) {
+	if !db.orm.HasTable(&SchemaMigration{}) {
+		return SchemaMigration{}, wrapError("Rollback", ErrNoRecordsAvailable)
+	}
+
+	record := SchemaMigration{}
+	if err := db.orm.Order("id desc").First(&record).Error; err == gorm.RecordNotFound {
+		return record, wrapError("Rollback", ErrNoRecordsAvailable)
+	} else if err != nil {
+		return record, wrapError("Rollback", err)
+	}
+
+	for i := range migrations {
+		if migrations[i].version != record.ID {
+			continue
+		}
+
+		tx := db.orm.Begin()
+		if err := migrations[i].down(tx); err != nil {
+			tx.Rollback()
+			return record, wrapError("Rollback", err)
+		}
+		if err := tx.Delete(&record).Error; err != nil {
+			tx.Rollback()
+			return record, wrapError("Rollback", err)
+		}
+		return record, wrapError("Rollback", tx.Commit().Error)
+	}
+
+	return record, wrapError("Rollback", ErrRecordNotFoundError)
+}
+
+//MigrationStatus lists every known migration and when it was applied
+func (db *Db) MigrationStatus() ([]MigrationStatus, error) {
+	applied := map[int]SchemaMigration{}
+
+	if db.orm.HasTable(&SchemaMigration{}) {
+		var err error
+		if applied, err = db.appliedMigrations(); err != nil {
+			return nil, wrapError("MigrationStatus", err)
+		}
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i := range migrations {
+		status[i] = MigrationStatus{Version: migrations[i].version, Name: migrations[i].name}
+		if record, ok := applied[migrations[i].version]; ok {
+			appliedAt := record.Applied
+			status[i].Applied = &appliedAt
+		}
+	}
+
+	return status, nil
+}
+
+func (db *Db) appliedMigrations() (map[int]SchemaMigration, error) {
+	records := []SchemaMigration{}
+	if err := db.orm.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]SchemaMigration{}
+	for i := range records {
+		applied[records[i].ID] = records[i]
+	}
+	return applied, nil
+}
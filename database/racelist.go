@@ -0,0 +1,241 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//The orders races can be listed in
+const (
+	RaceSortDate = "date"
+	RaceSortName = "name"
+)
+
+//RaceFilter narrows down and orders the races listed.  Empty fields don't
+//filter.  Races are listed a page of Limit at a time, after or before the
+//race at the cursor.  Without a limit every race is listed.
+type RaceFilter struct {
+	Year        int
+	From        time.Time
+	To          time.Time
+	RaceGroupID int
+	Grouped     *bool
+	Name        string
+	Sort        string
+	Descending  bool
+	After       *RaceCursor
+	Before      *RaceCursor
+	Limit       int
+}
+
+//RaceCursor is the place of a race in the list: the value it is sorted by and its id
+type RaceCursor struct {
+	Date time.Time
+	Name string
+	ID   int
+}
+
+//CursorFor the race, in the filter's order
+func (f RaceFilter) CursorFor(race Race) RaceCursor {
+	return RaceCursor{Date: race.Date, Name: race.Name, ID: race.ID}
+}
+
+//RacePage is a page of races and whether there are more either side of it
+type RacePage struct {
+	Races       []Race
+	HasNext     bool
+	HasPrevious bool
+}
+
+func (f RaceFilter) sortColumn() string {
+	if f.Sort == RaceSortName {
+		return "name"
+	}
+	return "date"
+}
+
+func (f RaceFilter) cursorValue(c RaceCursor) interface{} {
+	if f.Sort == RaceSortName {
+		return c.Name
+	}
+	return c.Date
+}
+
+//apply adds the filters to a query on race.  Races in the trash are never included.
+func (f RaceFilter) apply(query *gorm.DB) *gorm.DB {
+	query = query.Where("deleted IS NULL")
+	if f.Year > 0 {
+		query = query.Where("date >= ? AND date < ?", time.Date(f.Year, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(f.Year+1, time.January, 1, 0, 0, 0, 0, time.UTC))
+	}
+	if !f.From.IsZero() {
+		query = query.Where("date >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("date <= ?", f.To)
+	}
+	if f.RaceGroupID > 0 {
+		query = query.Where("race_group_id = ?", f.RaceGroupID)
+	}
+	if f.Grouped != nil {
+		if *f.Grouped {
+			query = query.Where("race_group_id > 0")
+		} else {
+			query = query.Where("race_group_id = 0 OR race_group_id IS NULL")
+		}
+	}
+	if len(f.Name) > 0 {
+		query = query.Where("UPPER(name) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToUpper(f.Name))+"%")
+	}
+	return query
+}
+
+//GetRacePage returns a page of the races matching the filter
+func (db *Db) GetRacePage(filter RaceFilter) (RacePage, error) {
+	page := RacePage{}
+
+	column := filter.sortColumn()
+
+	//going back a page reads the races before the cursor backwards
+	backwards := filter.Before != nil
+	descending := filter.Descending != backwards
+
+	direction, compare := "asc", ">"
+	if descending {
+		direction, compare = "desc", "<"
+	}
+
+	query := filter.apply(db.orm.Model(&Race{})).Order(column + " " + direction).Order("id " + direction)
+
+	cursor := filter.After
+	if backwards {
+		cursor = filter.Before
+	}
+
+	if cursor != nil {
+		value := filter.cursorValue(*cursor)
+		query = query.Where(column+" "+compare+" ? OR ("+column+" = ? AND id "+compare+" ?)", value, value, cursor.ID)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit + 1)
+	}
+
+	races := []Race{}
+	if err := query.Find(&races).Error; err != nil {
+		return page, wrapError("GetRacePage", err)
+	}
+
+	return filter.page(races), nil
+}
+
+//page turns the races read from the cursor, one more than the limit when
+//there are more, into the page
+func (f RaceFilter) page(races []Race) RacePage {
+	more := f.Limit > 0 && len(races) > f.Limit
+	if more {
+		races = races[:f.Limit]
+	}
+
+	page := RacePage{Races: races}
+
+	if f.Before != nil {
+		for i, j := 0, len(races)-1; i < j; i, j = i+1, j-1 {
+			races[i], races[j] = races[j], races[i]
+		}
+		page.HasPrevious = more
+		page.HasNext = true
+	} else {
+		page.HasNext = more
+		page.HasPrevious = f.After != nil
+	}
+
+	return page
+}
+
+//keep reports whether the race matches the filters, as apply does in sql
+func (f RaceFilter) keep(race Race) bool {
+	if race.Deleted != nil {
+		return false
+	}
+	if f.Year > 0 && race.Date.Year() != f.Year {
+		return false
+	}
+	if !f.From.IsZero() && race.Date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && race.Date.After(f.To) {
+		return false
+	}
+	if f.RaceGroupID > 0 && race.RaceGroupID != f.RaceGroupID {
+		return false
+	}
+	if f.Grouped != nil && *f.Grouped != (race.RaceGroupID > 0) {
+		return false
+	}
+	if len(f.Name) > 0 && !strings.Contains(strings.ToUpper(race.Name), strings.ToUpper(f.Name)) {
+		return false
+	}
+	return true
+}
+
+//compare orders the race against the cursor: negative when it comes first
+//in ascending order
+func (f RaceFilter) compare(race Race, c RaceCursor) int {
+	if f.Sort == RaceSortName {
+		if race.Name != c.Name {
+			return strings.Compare(race.Name, c.Name)
+		}
+	} else if !race.Date.Equal(c.Date) {
+		if race.Date.Before(c.Date) {
+			return -1
+		}
+		return 1
+	}
+
+	switch {
+	case race.ID < c.ID:
+		return -1
+	case race.ID > c.ID:
+		return 1
+	}
+	return 0
+}
+
+//sortRaces sorts and pages the races as GetRacePage does in sql
+func (f RaceFilter) sortRaces(races []Race) RacePage {
+	backwards := f.Before != nil
+	descending := f.Descending != backwards
+
+	sort.Slice(races, func(i, j int) bool {
+		c := f.compare(races[i], f.CursorFor(races[j]))
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	cursor := f.After
+	if backwards {
+		cursor = f.Before
+	}
+
+	if cursor != nil {
+		kept := []Race{}
+		for _, race := range races {
+			c := f.compare(race, *cursor)
+			if (descending && c < 0) || (!descending && c > 0) {
+				kept = append(kept, race)
+			}
+		}
+		races = kept
+	}
+
+	if f.Limit > 0 && len(races) > f.Limit+1 {
+		races = races[:f.Limit+1]
+	}
+
+	return f.page(races)
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+//The ways the results of a race can be placed.  Results are placed by gun
+//time as they were published, or again by chip time.
+const (
+	RankGunTime  = "gun"
+	RankChipTime = "chip"
+)
+
+//chipTimeMs is the time the result is placed by when ranking by chip time
+func (r RaceResult) chipTimeMs() int {
+	if r.ChipTimeMs != 0 {
+		return r.ChipTimeMs
+	}
+	return r.TimeMs
+}
+
+//rankByChipTime places the results of a race by chip time, using the gun time
+//of results without one.  Results without a time come last in the order they
+//were placed.  Results are placed in their sex and category only when they
+//have one.
+func rankByChipTime(results []RaceResult) map[int]RaceResult {
+	ordered := make([]RaceResult, len(results))
+	copy(ordered, results)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i].chipTimeMs(), ordered[j].chipTimeMs()
+		switch {
+		case (a > 0) != (b > 0):
+			return a > 0
+		case a != b:
+			return a < b
+		case ordered[i].Position != ordered[j].Position:
+			return ordered[i].Position < ordered[j].Position
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+
+	sexes := map[string]int{}
+	categories := map[int]int{}
+	ranked := map[int]RaceResult{}
+
+	for i, result := range ordered {
+		result.ChipPosition = i + 1
+		if sex := strings.ToUpper(result.Sex); len(sex) > 0 {
+			sexes[sex]++
+			result.ChipSexPosition = sexes[sex]
+		}
+		if result.AgeCategoryID > 0 {
+			categories[result.AgeCategoryID]++
+			result.ChipAgeCategoryPosition = categories[result.AgeCategoryID]
+		}
+		ranked[result.ID] = result
+	}
+
+	return ranked
+}
+
+//withChipPlaces sets the places by chip time of the results from the places
+//of every result of the race.  Results placed before the start position are
+//dropped.
+func withChipPlaces(results []RaceResult, race []RaceResult, startPosition int) []RaceResult {
+	ranked := rankByChipTime(race)
+
+	placed := []RaceResult{}
+	for _, result := range results {
+		place := ranked[result.ID]
+		result.ChipPosition = place.ChipPosition
+		result.ChipSexPosition = place.ChipSexPosition
+		result.ChipAgeCategoryPosition = place.ChipAgeCategoryPosition
+		if result.ChipPosition >= startPosition {
+			placed = append(placed, result)
+		}
+	}
+	return placed
+}
+
+//sortValue is what the result is ordered by, as sortColumn is in sql
+func (f ResultFilter) sortValue(result RaceResult) int {
+	switch f.Sort {
+	case ResultSortTime:
+		return result.TimeMs
+	case ResultSortChipTime:
+		return result.chipTimeMs()
+	case ResultSortCategory:
+		if f.Ranking == RankChipTime {
+			return result.ChipAgeCategoryPosition
+		}
+		return result.AgeCategoryPosition
+	}
+	if f.Ranking == RankChipTime {
+		return result.ChipPosition
+	}
+	return result.Position
+}
+
+//sortResults sorts the results of a race as order does in sql, and by the
+//places by chip time when the results are ranked by it
+func (f ResultFilter) sortResults(results []RaceResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := f.sortValue(results[i]), f.sortValue(results[j])
+		if (a > 0) != (b > 0) {
+			return a > 0
+		}
+
+		less := func(x, y int) bool {
+			if f.Descending {
+				return x > y
+			}
+			return x < y
+		}
+
+		switch {
+		case a != b:
+			return less(a, b)
+		case results[i].Position != results[j].Position:
+			return less(results[i].Position, results[j].Position)
+		}
+		return less(results[i].ID, results[j].ID)
+	})
+}
+
+//page sorts the results and returns the page of them the filter asks for.
+//numOfRecords limits the page when the filter doesn't.
+func (f ResultFilter) page(results []RaceResult, numOfRecords int) []RaceResult {
+	f.sortResults(results)
+
+	if f.Offset >= len(results) {
+		return nil
+	}
+	results = results[f.Offset:]
+
+	limit := f.Limit
+	if limit == 0 {
+		limit = numOfRecords
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
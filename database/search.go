@@ -0,0 +1,416 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chiefwhitecloud/running-man/names"
+)
+
+//How a racer's name matched the search, best first
+const (
+	MatchExact     = "exact"
+	MatchPrefix    = "prefix"
+	MatchSubstring = "substring"
+	MatchFuzzy     = "fuzzy"
+)
+
+var matchRanks = map[string]int{MatchExact: 0, MatchPrefix: 1, MatchSubstring: 2, MatchFuzzy: 3}
+
+//RacerSearchMinLength is the shortest query searched for.  Shorter ones match
+//nearly every racer.
+const RacerSearchMinLength = 2
+
+//racerSearchCandidateLimit caps the names a search considers, best matches
+//first, so a broad query reads a bounded number of rows.  A search matching
+//more names than this counts only the racers of the names considered.
+const racerSearchCandidateLimit = 500
+
+//racerSearchRankSQL ranks a name against the query as matchRacerName does:
+//exact, prefix, substring and anything else found by its phonetic key last
+const racerSearchRankSQL = "CASE WHEN UPPER(%[1]s) = ? THEN 0 WHEN UPPER(%[1]s) LIKE ? ESCAPE '!' OR UPPER(%[1]s) LIKE ? ESCAPE '!' THEN 1 WHEN UPPER(%[1]s) LIKE ? ESCAPE '!' THEN 2 ELSE 3 END"
+
+//racerSearchFilterSQL keeps the racers with a result outside the trash with the sex or club
+const racerSearchFilterSQL = " AND %s IN (SELECT rr.racer_id FROM race_result rr JOIN race rc ON rc.id = rr.race_id WHERE rc.deleted IS NULL AND UPPER(TRIM(rr.%s)) = ?)"
+
+//RacerSearch finds racers by any of the names they raced under or are known by.
+//Empty filters don't filter.  Racers are matched on their birth year when it
+//could fall between BornFrom and BornTo.
+type RacerSearch struct {
+	Query    string
+	Sex      string
+	Club     string
+	BornFrom int
+	BornTo   int
+	Offset   int
+	Limit    int
+}
+
+//RacerSearchMatch is a racer found by the search with the name that matched best
+type RacerSearchMatch struct {
+	RacerID int
+	Name    string
+	Match   string
+	Races   int
+	Sex     string
+	Clubs   []string
+}
+
+//racerSearchCandidate is a name a racer is known by that may match the search
+type racerSearchCandidate struct {
+	RacerID int
+	Name    string
+}
+
+//racerSearchProfile is what the search filters racers on, from their results
+//outside the trash
+type racerSearchProfile struct {
+	Sexes []string
+	Clubs []string
+	Races map[int]bool
+}
+
+//SearchRacers returns a page of the racers matching the search, best match
+//first, and the number of racers matching
+func (db *Db) SearchRacers(search RacerSearch) ([]RacerSearchMatch, int, error) {
+	query, err := racerSearchQuery(search)
+
+	if err != nil {
+		return nil, 0, wrapError("SearchRacers", err)
+	}
+
+	candidates, err := db.findRacerSearchCandidates(query, search)
+
+	if err != nil {
+		return nil, 0, wrapError("SearchRacers", err)
+	}
+
+	matches := matchRacerSearchCandidates(query, candidates)
+
+	ids := make([]int, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	profiles := map[int]*racerSearchProfile{}
+
+	for start := 0; start < len(ids); start += batchSize {
+		batch := ids[start:minInt(start+batchSize, len(ids))]
+
+		rows, err := db.orm.Raw("SELECT race_result.racer_id, race_result.race_id, race_result.sex, race_result.club FROM race_result JOIN race ON race.id = race_result.race_id WHERE race.deleted IS NULL AND race_result.racer_id IN (?) ORDER BY race.date DESC, race_result.id DESC", batch).Rows()
+
+		if err != nil {
+			return nil, 0, wrapError("SearchRacers", err)
+		}
+
+		for rows.Next() {
+			var racerID, raceID int
+			var sex, club string
+			if err := rows.Scan(&racerID, &raceID, &sex, &club); err != nil {
+				rows.Close()
+				return nil, 0, wrapError("SearchRacers", err)
+			}
+			addToRacerSearchProfile(profiles, racerID, raceID, sex, club)
+		}
+		rows.Close()
+	}
+
+	var birthDates map[int][2]time.Time
+	if search.BornFrom > 0 || search.BornTo > 0 {
+		if birthDates, err = db.getBirthDatesForRacers(ids); err != nil {
+			return nil, 0, wrapError("SearchRacers", err)
+		}
+	}
+
+	page, total := filterRacerSearchMatches(search, matches, profiles, birthDates)
+	return page, total, nil
+}
+
+//racerSearchQuery is the normalized query, which must be long enough to narrow
+//down the racers
+func racerSearchQuery(search RacerSearch) (string, error) {
+	query := names.Normalize(search.Query)
+
+	if len(query) == 0 {
+		return "", newError(KindValidation, "search query is required")
+	}
+
+	if utf8.RuneCountInString(query) < RacerSearchMinLength {
+		return "", newError(KindValidation, fmt.Sprintf("search query must be at least %d characters", RacerSearchMinLength))
+	}
+
+	return query, nil
+}
+
+//findRacerSearchCandidates returns the result names and aliases containing the
+//query or sharing a phonetic key with it, of racers with the sex and club
+//searched for.  They are ranked in the database and cut off at
+//racerSearchCandidateLimit, the result names ahead of the aliases.
+func (db *Db) findRacerSearchCandidates(query string, search RacerSearch) ([]racerSearchCandidate, error) {
+	escaped := escapeLike(query)
+	like := "%" + escaped + "%"
+	rankValues := []interface{}{query, escaped + "%", "% " + escaped + "%", like}
+	keys := racerSearchKeys(query)
+
+	sql := "SELECT race_result.racer_id, race_result.name, " + fmt.Sprintf(racerSearchRankSQL, "race_result.name") + " AS match_rank, MIN(race_result.id) AS first_id FROM race_result JOIN race ON race.id = race_result.race_id WHERE race.deleted IS NULL AND (UPPER(race_result.name) LIKE ? ESCAPE '!' OR race_result.name_key IN (?))"
+	aliasSQL := "SELECT racer_id, name, " + fmt.Sprintf(racerSearchRankSQL, "name") + " AS match_rank, id AS first_id FROM racer_alias WHERE (UPPER(name) LIKE ? ESCAPE '!' OR name_key IN (?))"
+	values := append(rankValues, like, keys)
+
+	for _, filter := range [][2]string{{"sex", search.Sex}, {"club", search.Club}} {
+		if value := strings.TrimSpace(filter[1]); len(value) > 0 {
+			sql += fmt.Sprintf(racerSearchFilterSQL, "race_result.racer_id", filter[0])
+			aliasSQL += fmt.Sprintf(racerSearchFilterSQL, "racer_id", filter[0])
+			values = append(values, strings.ToUpper(value))
+		}
+	}
+
+	sql += " GROUP BY race_result.racer_id, race_result.name ORDER BY match_rank ASC, first_id ASC LIMIT ?"
+	aliasSQL += " ORDER BY match_rank ASC, first_id ASC LIMIT ?"
+
+	candidates, err := db.queryRacerSearchCandidates(sql, append(values, racerSearchCandidateLimit)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := db.queryRacerSearchCandidates(aliasSQL, append(values, racerSearchCandidateLimit)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return capRacerSearchCandidates(query, append(candidates, aliases...)), nil
+}
+
+func (db *Db) queryRacerSearchCandidates(sql string, values ...interface{}) ([]racerSearchCandidate, error) {
+	rows, err := db.orm.Raw(sql, values...).Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var candidates []racerSearchCandidate
+	for rows.Next() {
+		var candidate racerSearchCandidate
+		var rank, firstID int
+		if err := rows.Scan(&candidate.RacerID, &candidate.Name, &rank, &firstID); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+//racerSearchRank ranks a name against the query as racerSearchRankSQL does
+func racerSearchRank(query string, name string) int {
+	name = strings.ToUpper(name)
+
+	switch {
+	case name == query:
+		return 0
+	case strings.HasPrefix(name, query) || strings.Contains(name, " "+query):
+		return 1
+	case strings.Contains(name, query):
+		return 2
+	}
+	return 3
+}
+
+//capRacerSearchCandidates keeps the racerSearchCandidateLimit best ranked
+//candidates, in the order they were found within a rank
+func capRacerSearchCandidates(query string, candidates []racerSearchCandidate) []racerSearchCandidate {
+	if len(candidates) <= racerSearchCandidateLimit {
+		return candidates
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return racerSearchRank(query, candidates[i].Name) < racerSearchRank(query, candidates[j].Name)
+	})
+	return candidates[:racerSearchCandidateLimit]
+}
+
+//racerSearchKeys are the phonetic keys of the words in the query, so names
+//that sound like the query are considered as well as those containing it
+func racerSearchKeys(query string) []string {
+	keys := []string{}
+	for _, word := range strings.Fields(query) {
+		if key := names.Metaphone(word); len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		//an IN with nothing in it isn't valid sql
+		keys = append(keys, "")
+	}
+	return keys
+}
+
+//escapeLike escapes the wildcards in a LIKE pattern with !
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+//matchRacerName reports how the name matches the normalized query
+func matchRacerName(query string, name string) (string, bool) {
+	name = names.Normalize(name)
+
+	switch {
+	case name == query:
+		return MatchExact, true
+	case strings.HasPrefix(name, query) || strings.Contains(name, " "+query):
+		return MatchPrefix, true
+	case strings.Contains(name, query):
+		return MatchSubstring, true
+	}
+
+	//every word of the query must be close to a word of the name
+	words := strings.Fields(name)
+	for _, q := range strings.Fields(query) {
+		close := false
+		for _, w := range words {
+			allowed := minInt(len(q), len(w)) / 3
+			if allowed < 1 {
+				allowed = 1
+			}
+			if names.Distance(q, w) <= allowed {
+				close = true
+				break
+			}
+		}
+		if !close {
+			//a nickname or similar sounding name of the same racer
+			if _, ok := names.Equivalent(query, name); ok {
+				return MatchFuzzy, true
+			}
+			return "", false
+		}
+	}
+
+	return MatchFuzzy, true
+}
+
+//matchRacerSearchCandidates keeps the best matching name of each racer
+func matchRacerSearchCandidates(query string, candidates []racerSearchCandidate) map[int]RacerSearchMatch {
+	matches := map[int]RacerSearchMatch{}
+
+	for i := range candidates {
+		match, ok := matchRacerName(query, candidates[i].Name)
+		if !ok {
+			continue
+		}
+
+		if best, seen := matches[candidates[i].RacerID]; seen && matchRanks[best.Match] <= matchRanks[match] {
+			continue
+		}
+
+		matches[candidates[i].RacerID] = RacerSearchMatch{RacerID: candidates[i].RacerID, Name: candidates[i].Name, Match: match}
+	}
+
+	return matches
+}
+
+//addToRacerSearchProfile adds a result to the racer's profile.  Results are
+//added newest first so the racer's current club comes first.
+func addToRacerSearchProfile(profiles map[int]*racerSearchProfile, racerID int, raceID int, sex string, club string) {
+	profile, ok := profiles[racerID]
+	if !ok {
+		profile = &racerSearchProfile{Races: map[int]bool{}}
+		profiles[racerID] = profile
+	}
+
+	profile.Races[raceID] = true
+
+	if sex = strings.TrimSpace(sex); len(sex) > 0 && !containsFold(profile.Sexes, sex) {
+		profile.Sexes = append(profile.Sexes, sex)
+	}
+
+	if club = strings.TrimSpace(club); len(club) > 0 && !containsFold(profile.Clubs, club) {
+		profile.Clubs = append(profile.Clubs, club)
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for i := range values {
+		if strings.EqualFold(values[i], value) {
+			return true
+		}
+	}
+	return false
+}
+
+//filterRacerSearchMatches applies the filters to the matches, ranks them and
+//returns the page asked for along with the number of racers matching.  Racers
+//without results outside the trash are left out.
+func filterRacerSearchMatches(search RacerSearch, matches map[int]RacerSearchMatch, profiles map[int]*racerSearchProfile, birthDates map[int][2]time.Time) ([]RacerSearchMatch, int) {
+	found := []RacerSearchMatch{}
+
+	for id, match := range matches {
+		profile, ok := profiles[id]
+		if !ok {
+			continue
+		}
+
+		if len(search.Sex) > 0 && !containsFold(profile.Sexes, search.Sex) {
+			continue
+		}
+
+		if len(search.Club) > 0 && !containsFold(profile.Clubs, strings.TrimSpace(search.Club)) {
+			continue
+		}
+
+		if search.BornFrom > 0 || search.BornTo > 0 {
+			dates, ok := birthDates[id]
+			if !ok {
+				continue
+			}
+			if search.BornFrom > 0 && dates[1].Year() < search.BornFrom {
+				continue
+			}
+			if search.BornTo > 0 && dates[0].Year() > search.BornTo {
+				continue
+			}
+		}
+
+		match.Races = len(profile.Races)
+		match.Clubs = profile.Clubs
+		if len(profile.Sexes) > 0 {
+			match.Sex = profile.Sexes[0]
+		}
+
+		found = append(found, match)
+	}
+
+	//best match first, then the racers with the most races
+	sort.Slice(found, func(i, j int) bool {
+		if rankI, rankJ := matchRanks[found[i].Match], matchRanks[found[j].Match]; rankI != rankJ {
+			return rankI < rankJ
+		}
+		if found[i].Races != found[j].Races {
+			return found[i].Races > found[j].Races
+		}
+		if found[i].Name != found[j].Name {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].RacerID < found[j].RacerID
+	})
+
+	total := len(found)
+
+	if search.Offset >= total {
+		return []RacerSearchMatch{}, total
+	}
+
+	found = found[search.Offset:]
+	if search.Limit > 0 && len(found) > search.Limit {
+		found = found[:search.Limit]
+	}
+
+	return found, total
+}
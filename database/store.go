@@ -0,0 +1,117 @@
+package database
+
+import (
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/model"
+)
+
+//RaceStore keeps the imported races
+type RaceStore interface {
+	GetRaces() ([]Race, error)
+	GetRacePage(filter RaceFilter) (RacePage, error)
+	GetRace(id int) (Race, error)
+	DeleteRace(id int) (Race, error)
+	GetLastUpdatedRace() (Race, error)
+	HasRaceBeenImported(url string) (bool, error)
+	GetDeletedRaces() ([]Race, error)
+	RestoreRace(id int) (Race, error)
+	PurgeDeletedRaces(before time.Time) ([]Race, error)
+}
+
+//RaceGroupStore keeps the groups of races run over the same course
+type RaceGroupStore interface {
+	CreateRaceGroup(name string, distance string, distanceunit string) (RaceGroup, error)
+	UpdateRaceGroup(id int, name string, distance string, distanceunit string) (RaceGroup, error)
+	DeleteRaceGroup(id int) (RaceGroup, error)
+	GetRaceGroup(id int) (RaceGroup, error)
+	GetRaceGroups() ([]RaceGroup, error)
+	GetLastUpdatedRaceGroup() (RaceGroup, error)
+	GetRacesForRaceGroup(raceGroupId int) ([]Race, error)
+	AddRaceToRaceGroup(raceGroup RaceGroup, race Race) (RaceGroup, error)
+	GetDeletedRaceGroups() ([]RaceGroup, error)
+	RestoreRaceGroup(id int) (RaceGroup, error)
+	PurgeDeletedRaceGroups(before time.Time) ([]RaceGroup, error)
+}
+
+//RacerStore keeps the racers, the names they are known by and their merges
+type RacerStore interface {
+	GetRacer(id int) (Racer, error)
+	GetRacerNames(id int) ([]string, error)
+	GetRacerBirthDates(id int) (time.Time, time.Time, error)
+	FindRacersForName(name string) ([]RacerNameMatch, error)
+	SearchRacers(search RacerSearch) ([]RacerSearchMatch, int, error)
+	SuggestRacers(prefix string, limit int) ([]RacerSuggestion, error)
+	GetRacerAliases(racerID int) ([]RacerAlias, error)
+	CreateRacerAlias(racer Racer, name string) (RacerAlias, error)
+	DeleteRacerAlias(racerID int, id int) (RacerAlias, error)
+	FindDuplicateRacers() ([]DuplicateRacers, error)
+	PreviewRacerMerge(parentRacer Racer, racer Racer) (RacerMergePreview, error)
+	MergeRacers(parentRacer Racer, racer Racer, force bool) (RacerMergePreview, error)
+	GetRacerMerges(parentRacerID int) ([]RacerMerge, error)
+	GetRacerMerge(parentRacerID int, id int) (RacerMerge, error)
+	UnmergeRacers(merge RacerMerge) (Racer, int, error)
+	SplitRaceResults(racer Racer, resultIds []int, target *Racer) (Racer, int, error)
+	GetRacerRedirect(oldRacerID int) (RacerRedirect, error)
+	DeleteOrphanRacers() ([]int, error)
+}
+
+//ResultStore keeps the race results.  The Each methods pass the results on a
+//page at a time, for sending more than is worth holding in memory without
+//holding a connection while they are sent.
+type ResultStore interface {
+	GetRaceResultsForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter) ([]RaceResult, []Racer, []Race, error)
+	EachRaceResultForRace(raceid int, startPosition int, numOfRecords int, filter ResultFilter, fn func(result RaceResult, race Race) error) error
+	CountRaceResultsForRace(raceid int, filter ResultFilter) (int, error)
+	GetRaceWinners(raceIDs []int) ([]RaceResult, error)
+	GetRaceResultsForRacer(racerid uint, filter ResultFilter) ([]RaceResult, []Racer, []Race, error)
+	EachRaceResultForRacer(racerid uint, filter ResultFilter, fn func(result RaceResult, race Race) error) error
+	GetRaceResults(filter ResultFilter) ([]RaceResult, []Racer, []Race, error)
+}
+
+//ImportTaskStore keeps the import tasks and saves the races they import
+type ImportTaskStore interface {
+	CreateImportTask(url string) (ImportTask, error)
+	GetImportTask(id int) (ImportTask, error)
+	GetPendingImportTasks() ([]ImportTask, error)
+	SaveRace(task ImportTask, r *model.RaceDetails) (Race, error)
+	FailedImport(task ImportTask, importErr error) error
+}
+
+//AgeCategoryStore keeps the age categories and the other names they are known by
+type AgeCategoryStore interface {
+	FindAgeCategory(name string) (AgeCategory, error)
+	GetAgeCategories() ([]AgeCategory, error)
+	GetAgeCategory(id int) (AgeCategory, error)
+	GetAgeCategoryAliases() ([]AgeCategoryAlias, error)
+	GetAliasesForAgeCategory(categoryID int) ([]AgeCategoryAlias, error)
+	CreateAgeCategory(name string, minAge int, maxAge int, aliases []string) (AgeCategory, error)
+	UpdateAgeCategory(id int, name string, minAge int, maxAge int) (AgeCategory, error)
+	DeleteAgeCategory(id int) (AgeCategory, error)
+	CreateAgeCategoryAlias(category AgeCategory, name string) (AgeCategoryAlias, error)
+	DeleteAgeCategoryAlias(categoryID int, id int) (AgeCategoryAlias, error)
+}
+
+//AuditStore keeps the record of changes.  A change is recorded in the
+//transaction that makes it, so the change is undone when the record can't be
+//saved.
+type AuditStore interface {
+	Transaction(fn func(tx Store) error) error
+	RecordAudit(entry AuditEntry) (AuditEntry, error)
+	GetAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+}
+
+//Store is everything the service keeps.  Db keeps it in a SQL database and
+//MemoryStore keeps it in memory for tests.
+type Store interface {
+	RaceStore
+	RaceGroupStore
+	RacerStore
+	ResultStore
+	ImportTaskStore
+	AgeCategoryStore
+	AuditStore
+}
+
+var _ Store = &Db{}
+var _ Store = &MemoryStore{}
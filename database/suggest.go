@@ -0,0 +1,310 @@
+package database
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chiefwhitecloud/running-man/names"
+)
+
+//RacerSuggestion is a racer with a name starting with what was typed
+type RacerSuggestion struct {
+	RacerID int
+	Name    string
+	Races   int
+}
+
+//suggestionKey is a name of a racer from one of its words on, so typing the
+//start of any word of the name finds it
+type suggestionKey struct {
+	Key     string
+	Name    string
+	RacerID int
+}
+
+//suggestionIndex keeps the racer names sorted for finding them by prefix.
+//The index is built from the database the first time it is needed and the
+//racers are reindexed as their results and names change.
+type suggestionIndex struct {
+	mu    sync.Mutex
+	built bool
+	keys  []suggestionKey
+	races map[int]int
+}
+
+func newSuggestionIndex() *suggestionIndex {
+	return &suggestionIndex{races: map[int]int{}}
+}
+
+//racerNames are the names of racers with the number of races outside the trash
+type racerNames struct {
+	Names map[int][]string
+	Races map[int]int
+}
+
+func (n racerNames) add(racerID int, name string, races int) {
+	if !containsName(n.Names[racerID], name) {
+		n.Names[racerID] = append(n.Names[racerID], name)
+	}
+	n.Races[racerID] += races
+}
+
+//suggestionKeysFor the names, sorted
+func suggestionKeysFor(n racerNames) []suggestionKey {
+	keys := []suggestionKey{}
+
+	for racerID, racerNames := range n.Names {
+		if n.Races[racerID] == 0 {
+			continue
+		}
+
+		for _, name := range racerNames {
+			words := strings.Fields(names.Normalize(name))
+			for i := range words {
+				keys = append(keys, suggestionKey{Key: strings.Join(words[i:], " "), Name: name, RacerID: racerID})
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	return keys
+}
+
+func (k suggestionKey) less(other suggestionKey) bool {
+	if k.Key != other.Key {
+		return k.Key < other.Key
+	}
+	return k.RacerID < other.RacerID
+}
+
+//replace the racers' keys with the names given.  Racers without names are
+//removed from the index.
+func (s *suggestionIndex) replace(racerIDs []int, n racerNames) {
+	replaced := map[int]bool{}
+	for _, id := range racerIDs {
+		replaced[id] = true
+		delete(s.races, id)
+	}
+
+	kept := make([]suggestionKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if !replaced[key.RacerID] {
+			kept = append(kept, key)
+		}
+	}
+
+	added := suggestionKeysFor(n)
+	for id, races := range n.Races {
+		if races > 0 {
+			s.races[id] = races
+		}
+	}
+
+	//merge the two sorted lists
+	s.keys = make([]suggestionKey, 0, len(kept)+len(added))
+	i, j := 0, 0
+	for i < len(kept) && j < len(added) {
+		if added[j].less(kept[i]) {
+			s.keys = append(s.keys, added[j])
+			j++
+		} else {
+			s.keys = append(s.keys, kept[i])
+			i++
+		}
+	}
+	s.keys = append(s.keys, kept[i:]...)
+	s.keys = append(s.keys, added[j:]...)
+}
+
+//suggest the racers with a name starting with the prefix, most races first
+func (s *suggestionIndex) suggest(prefix string, limit int) []RacerSuggestion {
+	prefix = names.Normalize(prefix)
+
+	found := map[int]RacerSuggestion{}
+
+	start := sort.Search(len(s.keys), func(i int) bool { return s.keys[i].Key >= prefix })
+	for i := start; i < len(s.keys) && strings.HasPrefix(s.keys[i].Key, prefix); i++ {
+		key := s.keys[i]
+		//the name matching from its first word is the better one to show
+		if _, ok := found[key.RacerID]; ok && !strings.HasPrefix(names.Normalize(key.Name), prefix) {
+			continue
+		}
+		found[key.RacerID] = RacerSuggestion{RacerID: key.RacerID, Name: key.Name, Races: s.races[key.RacerID]}
+	}
+
+	suggestions := make([]RacerSuggestion, 0, len(found))
+	for _, suggestion := range found {
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Races != suggestions[j].Races {
+			return suggestions[i].Races > suggestions[j].Races
+		}
+		if suggestions[i].Name != suggestions[j].Name {
+			return suggestions[i].Name < suggestions[j].Name
+		}
+		return suggestions[i].RacerID < suggestions[j].RacerID
+	})
+
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions
+}
+
+//BuildRacerNameIndex loads the racer names into the index used for suggestions
+func (db *Db) BuildRacerNameIndex() error {
+	if db.suggestions == nil {
+		return nil
+	}
+
+	db.suggestions.mu.Lock()
+	defer db.suggestions.mu.Unlock()
+
+	return wrapError("BuildRacerNameIndex", db.buildSuggestions())
+}
+
+func (db *Db) buildSuggestions() error {
+	n, err := db.loadRacerNames(nil)
+	if err != nil {
+		return err
+	}
+
+	db.suggestions.keys = suggestionKeysFor(n)
+	db.suggestions.races = map[int]int{}
+	for id, races := range n.Races {
+		if races > 0 {
+			db.suggestions.races[id] = races
+		}
+	}
+	db.suggestions.built = true
+	return nil
+}
+
+//SuggestRacers returns the racers with a name starting with the prefix, the
+//racers with the most races first
+func (db *Db) SuggestRacers(prefix string, limit int) ([]RacerSuggestion, error) {
+	if len(names.Normalize(prefix)) == 0 {
+		return nil, wrapError("SuggestRacers", newError(KindValidation, "prefix is required"))
+	}
+
+	if db.suggestions == nil {
+		return nil, wrapError("SuggestRacers", newError(KindStorage, "the database is not open"))
+	}
+
+	db.suggestions.mu.Lock()
+	defer db.suggestions.mu.Unlock()
+
+	if !db.suggestions.built {
+		if err := db.buildSuggestions(); err != nil {
+			return nil, wrapError("SuggestRacers", err)
+		}
+	}
+
+	return db.suggestions.suggest(prefix, limit), nil
+}
+
+//racersChanged reindexes the racers' names.  In a transaction it waits until
+//the transaction commits.  The change is already saved so when the names
+//can't be loaded the index is built again when it is next needed.
+func (db *Db) racersChanged(racerIDs []int) {
+	if db.changedRacers != nil {
+		for _, id := range racerIDs {
+			db.changedRacers[id] = true
+		}
+		return
+	}
+
+	if db.suggestions == nil || len(racerIDs) == 0 {
+		return
+	}
+
+	db.suggestions.mu.Lock()
+	defer db.suggestions.mu.Unlock()
+
+	//the index will be built with the changes when it is first needed
+	if !db.suggestions.built {
+		return
+	}
+
+	n, err := db.loadRacerNames(racerIDs)
+	if err != nil {
+		log.Printf("failed to reindex the names of racers %v: %s", racerIDs, err)
+		db.suggestions.built = false
+		return
+	}
+
+	db.suggestions.replace(racerIDs, n)
+}
+
+//resetSuggestions forgets the index, it is built again when next needed
+func (db *Db) resetSuggestions() {
+	if db.suggestions == nil {
+		return
+	}
+
+	db.suggestions.mu.Lock()
+	defer db.suggestions.mu.Unlock()
+
+	db.suggestions.built = false
+	db.suggestions.keys = nil
+	db.suggestions.races = map[int]int{}
+}
+
+//loadRacerNames loads the names of the racers, or every racer when racerIDs is nil
+func (db *Db) loadRacerNames(racerIDs []int) (racerNames, error) {
+	n := racerNames{Names: map[int][]string{}, Races: map[int]int{}}
+
+	load := func(where string, args ...interface{}) error {
+		rows, err := db.orm.Raw("SELECT race_result.racer_id, race_result.name, COUNT(DISTINCT race_result.race_id) FROM race_result JOIN race ON race.id = race_result.race_id WHERE race.deleted IS NULL"+where+" GROUP BY race_result.racer_id, race_result.name ORDER BY MIN(race_result.id) ASC", args...).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var racerID, races int
+			var name string
+			if err := rows.Scan(&racerID, &name, &races); err != nil {
+				return err
+			}
+			n.add(racerID, name, races)
+		}
+		return rows.Err()
+	}
+
+	aliases := []RacerAlias{}
+
+	if racerIDs == nil {
+		if err := load(""); err != nil {
+			return n, err
+		}
+		if err := db.orm.Order("id asc").Find(&aliases).Error; err != nil {
+			return n, err
+		}
+	}
+
+	for start := 0; start < len(racerIDs); start += batchSize {
+		batch := racerIDs[start:minInt(start+batchSize, len(racerIDs))]
+
+		if err := load(" AND race_result.racer_id IN (?)", batch); err != nil {
+			return n, err
+		}
+
+		batchAliases := []RacerAlias{}
+		if err := db.orm.Where("racer_id IN (?)", batch).Order("id asc").Find(&batchAliases).Error; err != nil {
+			return n, err
+		}
+		aliases = append(aliases, batchAliases...)
+	}
+
+	for i := range aliases {
+		n.add(aliases[i].RacerID, aliases[i].Name, 0)
+	}
+
+	return n, nil
+}
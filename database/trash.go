@@ -0,0 +1,237 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//GetDeletedRaces returns the races in the trash, most recently deleted first
+func (db *Db) GetDeletedRaces() ([]Race, error) {
+	races := []Race{}
+	if err := db.orm.Where("deleted IS NOT NULL").Order("deleted desc").Find(&races).Error; err != nil {
+		return races, wrapError("GetDeletedRaces", err)
+	}
+	return races, nil
+}
+
+//GetDeletedRaceGroups returns the race groups in the trash, most recently deleted first
+func (db *Db) GetDeletedRaceGroups() ([]RaceGroup, error) {
+	raceGroups := []RaceGroup{}
+	if err := db.orm.Where("deleted IS NOT NULL").Order("deleted desc").Find(&raceGroups).Error; err != nil {
+		return raceGroups, wrapError("GetDeletedRaceGroups", err)
+	}
+	return raceGroups, nil
+}
+
+//RestoreRace takes the race out of the trash, with its results
+func (db *Db) RestoreRace(id int) (Race, error) {
+	race := Race{}
+	if err := db.orm.Where("deleted IS NOT NULL").First(&race, id).Error; err != nil {
+		return race, wrapError("RestoreRace", err)
+	}
+
+	err := db.transaction(func(tx *Db) error {
+		race.Deleted = nil
+		race.ETag, race.LastUpdated = newETag(race.Name)
+		if err := tx.orm.Save(&race).Error; err != nil {
+			return err
+		}
+		return tx.raceChanged(race)
+	})
+
+	if err != nil {
+		return race, wrapError("RestoreRace", err)
+	}
+
+	race, err = db.GetRace(id)
+	return race, wrapError("RestoreRace", err)
+}
+
+//RestoreRaceGroup takes the race group out of the trash.  The races still in
+//the group are listed in it again.
+func (db *Db) RestoreRaceGroup(id int) (RaceGroup, error) {
+	raceGroup := RaceGroup{}
+	if err := db.orm.Where("deleted IS NOT NULL").First(&raceGroup, id).Error; err != nil {
+		return raceGroup, wrapError("RestoreRaceGroup", err)
+	}
+
+	err := db.transaction(func(tx *Db) error {
+		raceGroup.Deleted = nil
+		raceGroup.ETag, raceGroup.LastUpdated = newETag(raceGroup.Name)
+		if err := tx.orm.Save(&raceGroup).Error; err != nil {
+			return err
+		}
+		return tx.raceGroupChanged(raceGroup)
+	})
+
+	if err != nil {
+		return raceGroup, wrapError("RestoreRaceGroup", err)
+	}
+
+	raceGroup, err = db.GetRaceGroup(id)
+	return raceGroup, wrapError("RestoreRaceGroup", err)
+}
+
+//PurgeDeletedRaces permanently removes the races put in the trash before the
+//given time, along with their results and import tasks.  Racers left without
+//any results are removed too.  It returns the races removed.
+func (db *Db) PurgeDeletedRaces(before time.Time) ([]Race, error) {
+	races := []Race{}
+
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.orm.Where("deleted IS NOT NULL AND deleted < ?", before).Order("id asc").Find(&races).Error; err != nil {
+			return err
+		}
+		for i := range races {
+			if err := tx.purgeRace(races[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError("PurgeDeletedRaces", err)
+	}
+
+	return races, nil
+}
+
+//PurgeDeletedRaceGroups permanently removes the race groups put in the trash
+//before the given time.  Their races are left without a group.  It returns
+//the race groups removed.
+func (db *Db) PurgeDeletedRaceGroups(before time.Time) ([]RaceGroup, error) {
+	raceGroups := []RaceGroup{}
+
+	err := db.transaction(func(tx *Db) error {
+		if err := tx.orm.Where("deleted IS NOT NULL AND deleted < ?", before).Order("id asc").Find(&raceGroups).Error; err != nil {
+			return err
+		}
+
+		for i := range raceGroups {
+			races := []Race{}
+			if err := tx.orm.Where("race_group_id = ?", raceGroups[i].ID).Find(&races).Error; err != nil {
+				return err
+			}
+
+			for j := range races {
+				races[j].RaceGroupID = 0
+				races[j].ETag, races[j].LastUpdated = newETag(races[j].Name)
+				if err := tx.orm.Save(&races[j]).Error; err != nil {
+					return err
+				}
+			}
+
+			if err := tx.orm.Delete(&raceGroups[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, wrapError("PurgeDeletedRaceGroups", err)
+	}
+
+	return raceGroups, nil
+}
+
+//purgeRace removes the race with its results and import task.  Racers who
+//only ran this race are removed, the rest know less about their age.
+func (db *Db) purgeRace(race Race) error {
+	var racerIDs []int
+	if err := db.orm.Model(&RaceResult{}).Where("race_id = ?", race.ID).Group("racer_id").Pluck("racer_id", &racerIDs).Error; err != nil {
+		return err
+	}
+
+	if err := db.orm.Where("race_id = ?", race.ID).Delete(RaceResult{}).Error; err != nil {
+		return err
+	}
+
+	if err := db.orm.Where("race_id = ?", race.ID).Delete(ImportTask{}).Error; err != nil {
+		return err
+	}
+
+	if err := db.orm.Delete(&race).Error; err != nil {
+		return err
+	}
+
+	orphanIDs, err := db.orphanRacerIDs(racerIDs)
+	if err != nil {
+		return err
+	}
+
+	if err := db.deleteRacers(orphanIDs); err != nil {
+		return err
+	}
+
+	racerIDs = withoutIDs(racerIDs, orphanIDs)
+
+	if err := db.updateBrackets(racerIDs); err != nil {
+		return err
+	}
+
+	return db.touchRacers(racerIDs)
+}
+
+//raceChanged gives new etags to everything listing the race: the racers with
+//results in it, its race group and the list of races
+func (db *Db) raceChanged(race Race) error {
+	var racerIDs []int
+	if err := db.orm.Model(&RaceResult{}).Where("race_id = ?", race.ID).Group("racer_id").Pluck("racer_id", &racerIDs).Error; err != nil {
+		return err
+	}
+
+	if err := db.touchRacers(racerIDs); err != nil {
+		return err
+	}
+
+	if race.RaceGroupID != 0 {
+		raceGroup := RaceGroup{}
+		if err := db.orm.Where("deleted IS NULL").First(&raceGroup, race.RaceGroupID).Error; err == nil {
+			raceGroup.ETag, raceGroup.LastUpdated = newETag(raceGroup.Name)
+			if err := db.orm.Save(&raceGroup).Error; err != nil {
+				return err
+			}
+		} else if err != gorm.RecordNotFound {
+			return err
+		}
+	}
+
+	//update the etag for the newest item... this is the etag used to the list
+	raceLastUpdated, err := db.GetLastUpdatedRace()
+	if KindOf(err) == KindNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	raceLastUpdated.ETag, raceLastUpdated.LastUpdated = newETag(raceLastUpdated.Name)
+	return db.orm.Save(&raceLastUpdated).Error
+}
+
+//raceGroupChanged gives new etags to the races in the group and the list of
+//race groups
+func (db *Db) raceGroupChanged(raceGroup RaceGroup) error {
+	races, err := db.GetRacesForRaceGroup(raceGroup.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range races {
+		races[i].ETag, races[i].LastUpdated = newETag(races[i].Name)
+		if err := db.orm.Save(&races[i]).Error; err != nil {
+			return err
+		}
+	}
+
+	//update the etag for the newest item... this is the etag used to the list
+	raceGroupLastUpdated, err := db.GetLastUpdatedRaceGroup()
+	if KindOf(err) == KindNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	raceGroupLastUpdated.ETag, raceGroupLastUpdated.LastUpdated = newETag(raceGroupLastUpdated.Name)
+	return db.orm.Save(&raceGroupLastUpdated).Error
+}
@@ -0,0 +1,292 @@
+package feed
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/chiefwhitecloud/running-man/api"
+	"github.com/chiefwhitecloud/running-man/database"
+	"github.com/gorilla/mux"
+)
+
+//ListAgeCategories Get the age categories and the other names they are known by
+func (r *FeedResource) ListAgeCategories(w http.ResponseWriter, req *http.Request) {
+
+	categories, err := r.Db.GetAgeCategories()
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	aliases, err := r.Db.GetAgeCategoryAliases()
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatAgeCategoriesForFeed(req, categories, aliases))
+}
+
+//GetAgeCategory Fetch the requested age category
+func (r *FeedResource) GetAgeCategory(w http.ResponseWriter, req *http.Request) {
+
+	category := r.getAgeCategoryOrSendError(w, req)
+
+	if category == nil {
+		return
+	}
+
+	r.sendAgeCategory(w, req, *category, http.StatusOK)
+}
+
+//CreateAgeCategory Add an age category, with its min and max ages and aliases
+func (r *FeedResource) CreateAgeCategory(w http.ResponseWriter, req *http.Request) {
+
+	var categoryCreate api.AgeCategoryCreate
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&categoryCreate); err != nil {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	var category database.AgeCategory
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		var err error
+		if category, err = tx.CreateAgeCategory(categoryCreate.Name, categoryCreate.MinAge, categoryCreate.MaxAge, categoryCreate.Aliases); err != nil {
+			return err
+		}
+
+		after, err := snapshotAgeCategory(tx, req, category)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "create", database.AuditAgeCategory, category.ID, nil, after)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	r.sendAgeCategory(w, req, category, http.StatusCreated)
+}
+
+//UpdateAgeCategory Change the name or ages of the age category
+func (r *FeedResource) UpdateAgeCategory(w http.ResponseWriter, req *http.Request) {
+
+	category := r.getAgeCategoryOrSendError(w, req)
+
+	if category == nil {
+		return
+	}
+
+	var categoryUpdate api.AgeCategoryCreate
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&categoryUpdate); err != nil {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	var updated database.AgeCategory
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		current, err := tx.GetAgeCategory(category.ID)
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshotAgeCategory(tx, req, current)
+		if err != nil {
+			return err
+		}
+
+		if updated, err = tx.UpdateAgeCategory(current.ID, categoryUpdate.Name, categoryUpdate.MinAge, categoryUpdate.MaxAge); err != nil {
+			return err
+		}
+
+		after, err := snapshotAgeCategory(tx, req, updated)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "update", database.AuditAgeCategory, updated.ID, before, after)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	r.sendAgeCategory(w, req, updated, http.StatusOK)
+}
+
+//DeleteAgeCategory Remove an age category that has no results
+func (r *FeedResource) DeleteAgeCategory(w http.ResponseWriter, req *http.Request) {
+
+	category := r.getAgeCategoryOrSendError(w, req)
+
+	if category == nil {
+		return
+	}
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		current, err := tx.GetAgeCategory(category.ID)
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshotAgeCategory(tx, req, current)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.DeleteAgeCategory(current.ID); err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "delete", database.AuditAgeCategory, current.ID, before, nil)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+//CreateAgeCategoryAlias Record another name used for the age category
+func (r *FeedResource) CreateAgeCategoryAlias(w http.ResponseWriter, req *http.Request) {
+
+	category := r.getAgeCategoryOrSendError(w, req)
+
+	if category == nil {
+		return
+	}
+
+	var aliasCreate api.AgeCategoryAliasCreate
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&aliasCreate); err != nil {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	var aliasFeed api.AgeCategoryAlias
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		alias, err := tx.CreateAgeCategoryAlias(*category, aliasCreate.Name)
+		if err != nil {
+			return err
+		}
+		aliasFeed = FormatAgeCategoryAliasForFeed(req, alias)
+		return RecordAudit(tx, req, "create-alias", database.AuditAgeCategory, category.ID, nil, aliasFeed)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	b, err := json.Marshal(aliasFeed)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	jsonResponse(w)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+//DeleteAgeCategoryAlias Remove a name from the age category
+func (r *FeedResource) DeleteAgeCategoryAlias(w http.ResponseWriter, req *http.Request) {
+
+	category := r.getAgeCategoryOrSendError(w, req)
+
+	if category == nil {
+		return
+	}
+
+	aliasID, err := strconv.Atoi(mux.Vars(req)["aliasId"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return
+	}
+
+	err = r.Db.Transaction(func(tx database.Store) error {
+		alias, err := tx.DeleteAgeCategoryAlias(category.ID, aliasID)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "delete-alias", database.AuditAgeCategory, category.ID, FormatAgeCategoryAliasForFeed(req, alias), nil)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *FeedResource) sendAgeCategory(w http.ResponseWriter, req *http.Request, category database.AgeCategory, status int) {
+
+	aliases, err := r.Db.GetAliasesForAgeCategory(category.ID)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	b, err := json.Marshal(FormatAgeCategoryForFeed(req, category, aliases))
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	jsonResponse(w)
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+//snapshotAgeCategory The age category with its aliases for the audit log
+func snapshotAgeCategory(db database.Store, req *http.Request, category database.AgeCategory) (interface{}, error) {
+
+	aliases, err := db.GetAliasesForAgeCategory(category.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return FormatAgeCategoryForFeed(req, category, aliases), nil
+}
+
+func (r *FeedResource) getAgeCategoryOrSendError(w http.ResponseWriter, req *http.Request) *database.AgeCategory {
+
+	categoryID, err := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return nil
+	}
+
+	category, err := r.Db.GetAgeCategory(categoryID)
+
+	if err != nil {
+		HandleError(err, w)
+		return nil
+	}
+
+	return &category
+}
@@ -0,0 +1,166 @@
+package feed
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chiefwhitecloud/running-man/api"
+	"github.com/chiefwhitecloud/running-man/database"
+)
+
+//Actor Who is making the request: the X-Actor header, or the address the
+//request came from
+func Actor(req *http.Request) string {
+	if actor := strings.TrimSpace(req.Header.Get("X-Actor")); len(actor) > 0 {
+		return actor
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+//RecordAudit Add an entry to the audit log with the json of the entity before
+//and after the change.  It is recorded through the store making the change,
+//so a change that can't be audited is rolled back with it.
+func RecordAudit(db database.Store, req *http.Request, action string, entity string, entityID int, before interface{}, after interface{}) error {
+
+	beforeJSON, err := auditSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := auditSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	entry := database.AuditEntry{
+		Actor:    Actor(req),
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Before:   beforeJSON,
+		After:    afterJSON,
+	}
+
+	_, err = db.RecordAudit(entry)
+	return err
+}
+
+func auditSnapshot(entity interface{}) (string, error) {
+	if entity == nil {
+		return "", nil
+	}
+
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+//racerSnapshot The names and results of a racer, the parts of it that change
+type racerSnapshot struct {
+	Racer   api.Racer `json:"racer"`
+	Names   []string  `json:"names"`
+	Results []string  `json:"results"`
+}
+
+func snapshotRacer(db database.Store, req *http.Request, racer database.Racer) (interface{}, error) {
+
+	names, err := db.GetRacerNames(racer.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rr, _, _, err := db.GetRaceResultsForRacer(uint(racer.ID), database.ResultFilter{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]string, len(rr))
+	for i := range rr {
+		results[i] = strconv.Itoa(rr[i].ID)
+	}
+
+	return racerSnapshot{Racer: FormatRacerForFeed(req, racer), Names: names, Results: results}, nil
+}
+
+//snapshotRacers The snapshots of the racers taking part in a change, by their role in it
+func snapshotRacers(db database.Store, req *http.Request, racers map[string]database.Racer) (map[string]interface{}, error) {
+
+	snapshots := map[string]interface{}{}
+
+	for role, racer := range racers {
+		snapshot, err := snapshotRacer(db, req, racer)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[role] = snapshot
+	}
+
+	return snapshots, nil
+}
+
+//ListAudit Get the audit log, newest first.  It can be filtered by entity, id,
+//actor and action.
+func (r *FeedResource) ListAudit(w http.ResponseWriter, req *http.Request) {
+
+	query := req.URL.Query()
+
+	filter := database.AuditFilter{
+		Entity: query.Get("entity"),
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  100,
+	}
+
+	if len(filter.Entity) > 0 && !validAuditEntity(filter.Entity) {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	if id := query.Get("id"); len(id) > 0 {
+		entityID, err := strconv.Atoi(id)
+		if err != nil || len(filter.Entity) == 0 {
+			HandleError(ErrBadRequest, w)
+			return
+		}
+		filter.EntityID = entityID
+	}
+
+	if limit := query.Get("limit"); len(limit) > 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			HandleError(ErrBadRequest, w)
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := r.Db.GetAuditEntries(filter)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatAuditEntriesForFeed(req, entries))
+}
+
+func validAuditEntity(entity string) bool {
+	for _, e := range database.AuditEntities {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
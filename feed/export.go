@@ -0,0 +1,300 @@
+package feed
+
+import (
+	"encoding/csv"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chiefwhitecloud/running-man/api"
+	"github.com/chiefwhitecloud/running-man/database"
+)
+
+//The formats results can be sent in
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+)
+
+var formatContentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatCSV:  "text/csv",
+	formatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+//resultRow is a result with the race it was in, flattened into columns for export
+type resultRow struct {
+	Result api.RaceResult
+	Race   api.Race
+}
+
+type resultColumn struct {
+	Name  string
+	Value func(row resultRow) interface{}
+}
+
+//resultColumns are the columns results can be exported with, named as in the json
+var resultColumns = []resultColumn{
+	{"position", func(row resultRow) interface{} { return row.Result.Position }},
+	{"sexPosition", func(row resultRow) interface{} { return row.Result.SexPosition }},
+	{"ageCategoryPosition", func(row resultRow) interface{} { return row.Result.AgeCategoryPosition }},
+	{"gunPosition", func(row resultRow) interface{} { return row.Result.GunPosition }},
+	{"gunSexPosition", func(row resultRow) interface{} { return row.Result.GunSexPosition }},
+	{"gunAgeCategoryPosition", func(row resultRow) interface{} { return row.Result.GunAgeCategoryPosition }},
+	{"name", func(row resultRow) interface{} { return row.Result.Name }},
+	{"bibNumber", func(row resultRow) interface{} { return row.Result.BibNumber }},
+	{"sex", func(row resultRow) interface{} { return row.Result.Sex }},
+	{"ageCategory", func(row resultRow) interface{} { return row.Result.AgeCategory }},
+	{"bracket", func(row resultRow) interface{} { return row.Result.Bracket }},
+	{"club", func(row resultRow) interface{} { return row.Result.Club }},
+	{"time", func(row resultRow) interface{} { return row.Result.Time }},
+	{"timeMs", func(row resultRow) interface{} { return row.Result.TimeMs }},
+	{"chipTime", func(row resultRow) interface{} { return row.Result.ChipTime }},
+	{"chipTimeMs", func(row resultRow) interface{} { return row.Result.ChipTimeMs }},
+	{"race", func(row resultRow) interface{} { return row.Race.Name }},
+	{"raceDate", func(row resultRow) interface{} { return row.Race.Date }},
+	{"raceId", func(row resultRow) interface{} { return row.Result.RaceID }},
+	{"racerId", func(row resultRow) interface{} { return row.Result.RacerID }},
+	{"id", func(row resultRow) interface{} { return row.Result.Id }},
+}
+
+//defaultResultColumns are exported when no columns are asked for.  The
+//published places are added when the results are placed by chip time.
+var defaultResultColumns = []string{"position", "sexPosition", "ageCategoryPosition", "name", "bibNumber", "sex", "ageCategory", "club", "time", "chipTime", "race", "raceDate"}
+
+var gunPlaceColumns = []string{"gunPosition", "gunSexPosition", "gunAgeCategoryPosition"}
+
+//resultExport is how results are to be sent: the format and, for a
+//spreadsheet, the columns
+type resultExport struct {
+	Format  string
+	Columns []resultColumn
+}
+
+//parseResultExport reads the format from the format querystring parameter,
+//or else the Accept header, and the comma separated columns parameter
+func parseResultExport(req *http.Request) (resultExport, error) {
+	export := resultExport{Format: formatJSON}
+
+	query := req.URL.Query()
+
+	if format := strings.ToLower(query.Get("format")); len(format) > 0 {
+		if _, ok := formatContentTypes[format]; !ok {
+			return export, ErrBadRequest
+		}
+		export.Format = format
+	} else {
+		export.Format = acceptedFormat(req.Header.Get("Accept"))
+	}
+
+	if columns := query.Get("columns"); len(columns) > 0 {
+		for _, name := range strings.Split(columns, ",") {
+			column, ok := findResultColumn(strings.TrimSpace(name))
+			if !ok {
+				return export, ErrBadRequest
+			}
+			export.Columns = append(export.Columns, column)
+		}
+	}
+
+	return export, nil
+}
+
+//acceptedFormat is the first format in the Accept header results can be sent
+//in, json when there isn't one
+func acceptedFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for format, contentType := range formatContentTypes {
+			if mediaType == contentType {
+				return format
+			}
+		}
+	}
+	return formatJSON
+}
+
+func findResultColumn(name string) (resultColumn, bool) {
+	for _, column := range resultColumns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return resultColumn{}, false
+}
+
+func (e resultExport) columns(ranking string) []resultColumn {
+	if len(e.Columns) > 0 {
+		return e.Columns
+	}
+
+	names := defaultResultColumns
+	if ranking == database.RankChipTime {
+		names = []string{}
+		names = append(names, defaultResultColumns[:3]...)
+		names = append(names, gunPlaceColumns...)
+		names = append(names, defaultResultColumns[3:]...)
+	}
+
+	columns := make([]resultColumn, len(names))
+	for i := range names {
+		columns[i], _ = findResultColumn(names[i])
+	}
+	return columns
+}
+
+//varyOnAccept says the response depends on the Accept header, as the format
+//results are sent in does.  It goes on every response, 304s included.
+func varyOnAccept(res http.ResponseWriter) {
+	res.Header().Add("Vary", "Accept")
+}
+
+//resultRowWriter writes the rows of a spreadsheet
+type resultRowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+//sendRows sends the results in the spreadsheet format asked for, with the
+//etag, as an attachment named after the file.  eachRow reads the results,
+//calling write with each, and they are written as they are passed on.  Nothing is
+//sent before the first result so an error reading the results is reported.
+func (e resultExport) sendRows(res http.ResponseWriter, etag string, filename string, ranking string, eachRow func(write func(row resultRow) error) error) {
+	columns := e.columns(ranking)
+
+	header := make([]interface{}, len(columns))
+	for i := range columns {
+		header[i] = columns[i].Name
+	}
+
+	var w resultRowWriter
+
+	start := func() error {
+		contentType := formatContentTypes[e.Format]
+		if e.Format == formatCSV {
+			contentType += "; charset=utf-8"
+		}
+
+		res.Header().Set("ETag", etag)
+		res.Header().Set("Content-Type", contentType)
+		res.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + "." + e.Format}))
+		res.WriteHeader(http.StatusOK)
+
+		if e.Format == formatCSV {
+			w = &csvRowWriter{w: csv.NewWriter(res)}
+		} else {
+			x, err := newXlsxWriter(res, "Results")
+			if err != nil {
+				return err
+			}
+			w = x
+		}
+		return w.WriteRow(header)
+	}
+
+	values := make([]interface{}, len(columns))
+
+	err := eachRow(func(row resultRow) error {
+		if w == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		for i := range columns {
+			values[i] = exportValue(columns[i].Value(row))
+		}
+		return w.WriteRow(values)
+	})
+
+	if err != nil && w == nil {
+		HandleError(err, res)
+		return
+	}
+
+	if err == nil && w == nil {
+		err = start()
+	}
+
+	if err == nil {
+		err = w.Close()
+	}
+
+	//the headers are sent, all that can be done is stop
+	if err != nil {
+		log.Printf("failed to export %s: %s", filename, err)
+	}
+}
+
+//exportValue is the value as it is written to a spreadsheet, with strings trimmed
+func exportValue(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s)
+	}
+	return value
+}
+
+//exportRows formats the results read from the store as rows for export,
+//placed by chip time when that is the ranking
+func exportRows(req *http.Request, ranking string, write func(row resultRow) error) func(result database.RaceResult, race database.Race) error {
+	races := map[int]api.Race{}
+
+	return func(result database.RaceResult, race database.Race) error {
+		feedRace, ok := races[race.ID]
+		if !ok {
+			feedRace = FormatRaceForFeed(req, race)
+			races[race.ID] = feedRace
+		}
+
+		feedResult := FormatRaceResultForFeed(result)
+		if ranking == database.RankChipTime {
+			feedResult = chipPlacedResult(feedResult, result)
+		}
+
+		return write(resultRow{Result: feedResult, Race: feedRace})
+	}
+}
+
+//csvRowWriter writes the rows of a csv file
+type csvRowWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (c *csvRowWriter) WriteRow(values []interface{}) error {
+	if len(c.record) != len(values) {
+		c.record = make([]string, len(values))
+	}
+	for i, value := range values {
+		switch v := value.(type) {
+		case int:
+			c.record[i] = strconv.Itoa(v)
+		case string:
+			c.record[i] = csvCell(v)
+		}
+	}
+	return c.w.Write(c.record)
+}
+
+//csvCell quotes a value a spreadsheet would take for a formula with a leading
+//' so opening an export can't run one.  Numbers, like the -19 age category,
+//are left as they are.  The xlsx cells are strings and never run.
+func csvCell(s string) string {
+	if len(s) == 0 || !strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s
+	}
+	return "'" + s
+}
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
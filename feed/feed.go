@@ -15,17 +15,30 @@ var ErrNotFound = errors.New("Record not found")
 var ErrBadRequest = errors.New("Bad Request")
 
 type FeedResource struct {
-	Db database.Db
+	Db database.Store
 }
 
-func handleError(err error, w http.ResponseWriter) {
+//HandleError Send the status for the error.  Store errors are sent by their
+//kind, failures of the store are logged and sent as a 500 without the details.
+func HandleError(err error, w http.ResponseWriter) {
 
-	if err == database.ErrRecordNotFoundError {
+	switch {
+	case errors.Is(err, ErrNotFound):
 		http.Error(w, err.Error(), http.StatusNotFound)
-	} else if err == ErrBadRequest {
+	case errors.Is(err, ErrBadRequest):
 		http.Error(w, err.Error(), http.StatusBadRequest)
-	} else {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		switch database.KindOf(err) {
+		case database.KindNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case database.KindValidation:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case database.KindConflict:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Print(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
 	}
 
 }
@@ -1,9 +1,12 @@
 package feed
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/chiefwhitecloud/running-man/api"
 	"github.com/chiefwhitecloud/running-man/database"
@@ -28,7 +31,7 @@ func FormatRaceGroupsForFeed(req *http.Request, raceGroups []database.RaceGroup)
 }
 
 func FormatRaceGroupForFeed(req *http.Request, raceGroup database.RaceGroup) api.RaceGroup {
-	return api.RaceGroup{
+	raceGroupStruct := api.RaceGroup{
 		Id:           strconv.Itoa(raceGroup.ID),
 		Name:         raceGroup.Name,
 		Distance:     raceGroup.Distance,
@@ -36,6 +39,13 @@ func FormatRaceGroupForFeed(req *http.Request, raceGroup database.RaceGroup) api
 		SelfPath:     fmt.Sprintf("http://%s/feed/racegroup/%d", req.Host, raceGroup.ID),
 		RacesPath:    fmt.Sprintf("http://%s/feed/racegroup/%d/races", req.Host, raceGroup.ID),
 	}
+
+	if raceGroup.Deleted != nil {
+		raceGroupStruct.Deleted = raceGroup.Deleted.Format(time.RFC3339)
+		raceGroupStruct.RestorePath = fmt.Sprintf("http://%s/feed/racegroup/%d/restore", req.Host, raceGroup.ID)
+	}
+
+	return raceGroupStruct
 }
 
 func FormatRacesForFeed(req *http.Request, races []database.Race) api.RaceFeed {
@@ -48,6 +58,66 @@ func FormatRacesForFeed(req *http.Request, races []database.Race) api.RaceFeed {
 	return api.RaceFeed{Races: raceList}
 }
 
+func FormatRacePageForFeed(req *http.Request, filter database.RaceFilter, page database.RacePage) api.RaceFeed {
+
+	raceFeed := FormatRacesForFeed(req, page.Races)
+	raceFeed.SelfPath = racePagePath(req, "", "")
+
+	if page.HasNext && len(page.Races) > 0 {
+		raceFeed.NextPath = racePagePath(req, "after", formatRaceCursor(filter.CursorFor(page.Races[len(page.Races)-1])))
+	}
+
+	if page.HasPrevious && len(page.Races) > 0 {
+		raceFeed.PreviousPath = racePagePath(req, "before", formatRaceCursor(filter.CursorFor(page.Races[0])))
+	}
+
+	return raceFeed
+}
+
+//racePagePath is the race list with the cursor of another page
+func racePagePath(req *http.Request, param string, cursor string) string {
+	query := req.URL.Query()
+	query.Del("after")
+	query.Del("before")
+	if len(param) > 0 {
+		query.Set(param, cursor)
+	}
+
+	path := fmt.Sprintf("http://%s%s", req.Host, req.URL.Path)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+//raceCursor is the json of a cursor in the race list
+type raceCursor struct {
+	Date string `json:"d"`
+	Name string `json:"n"`
+	ID   int    `json:"id"`
+}
+
+func formatRaceCursor(cursor database.RaceCursor) string {
+	b, _ := json.Marshal(raceCursor{Date: cursor.Date.UTC().Format(time.RFC3339Nano), Name: cursor.Name, ID: cursor.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseRaceCursor(s string) (database.RaceCursor, error) {
+	var c raceCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return database.RaceCursor{}, ErrBadRequest
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, c.Date)
+	if err != nil {
+		return database.RaceCursor{}, ErrBadRequest
+	}
+
+	return database.RaceCursor{Date: date, Name: c.Name, ID: c.ID}, nil
+}
+
 func FormatRaceForFeed(req *http.Request, race database.Race) api.Race {
 	raceStruct := api.Race{
 		Id:          strconv.Itoa(race.ID),
@@ -61,6 +131,11 @@ func FormatRaceForFeed(req *http.Request, race database.Race) api.Race {
 		raceStruct.RaceGroupPath = fmt.Sprintf("http://%s/feed/racegroup/%d", req.Host, race.RaceGroupID)
 	}
 
+	if race.Deleted != nil {
+		raceStruct.Deleted = race.Deleted.Format(time.RFC3339)
+		raceStruct.RestorePath = fmt.Sprintf("http://%s/feed/race/%d/restore", req.Host, race.ID)
+	}
+
 	return raceStruct
 }
 
@@ -71,42 +146,139 @@ func FormatRacerForFeed(req *http.Request, racer database.Racer) api.Racer {
 		ResultsPath: fmt.Sprintf("http://%s/feed/racer/%d/results", req.Host, racer.ID),
 		ProfilePath: fmt.Sprintf("http://%s/feed/racer/%d/profile", req.Host, racer.ID),
 		MergePath:   fmt.Sprintf("http://%s/feed/racer/%d/merge", req.Host, racer.ID),
+		MergesPath:  fmt.Sprintf("http://%s/feed/racer/%d/merges", req.Host, racer.ID),
+		SplitPath:   fmt.Sprintf("http://%s/feed/racer/%d/split", req.Host, racer.ID),
+		AliasesPath: fmt.Sprintf("http://%s/feed/racer/%d/aliases", req.Host, racer.ID),
 	}
 }
 
-func FormatRaceResultsForFeed(req *http.Request, raceresults []database.RaceResult, racers []database.Racer, races []database.Race) api.RaceResults {
+func FormatRacerMatchesForFeed(req *http.Request, matches []database.RacerNameMatch) api.RacerMatchFeed {
 
-	ageMap := map[int]string{
-		1:  "U20",
-		2:  "-19",
-		3:  "<20",
-		4:  "20-24",
-		5:  "25-29",
-		6:  "20-29",
-		7:  "30-34",
-		8:  "35-39",
-		9:  "30-39",
-		10: "40-44",
-		11: "45-49",
-		12: "40-49",
-		13: "50-54",
-		14: "55-59",
-		15: "50-59",
-		16: "60-64",
-		17: "65-69",
-		18: "60-69",
-		19: "70-74",
-		20: "75-79",
-		21: "70-79",
-		22: "70+",
-		23: "80-84",
-		24: "85-89",
-		25: "80-89",
-		26: "80+",
-		27: "A",
-		28: "NOAGE",
+	matchList := make([]api.RacerMatch, len(matches))
+	for i := range matches {
+		matchList[i] = api.RacerMatch{
+			Name:            matches[i].Name,
+			Reason:          matches[i].Reason(),
+			FirstNameReason: matches[i].Equivalence.FirstName,
+			LastNameReason:  matches[i].Equivalence.LastName,
+			Racer:           FormatRacerForFeed(req, database.Racer{ID: matches[i].RacerID}),
+		}
 	}
 
+	return api.RacerMatchFeed{Matches: matchList}
+}
+
+func FormatRacerMergePreviewForFeed(req *http.Request, preview database.RacerMergePreview) api.RacerMergePreview {
+
+	conflicts := make([]api.RacerMergeConflict, len(preview.Conflicts))
+	for i := range preview.Conflicts {
+		conflicts[i] = api.RacerMergeConflict{
+			Type:        preview.Conflicts[i].Type,
+			Description: preview.Conflicts[i].Description,
+		}
+		if preview.Conflicts[i].RaceID > 0 {
+			conflicts[i].RacePath = fmt.Sprintf("http://%s/feed/race/%d", req.Host, preview.Conflicts[i].RaceID)
+		}
+	}
+
+	return api.RacerMergePreview{
+		Racer:        FormatRacerForFeed(req, preview.Racer),
+		MergedRacer:  FormatRacerForFeed(req, preview.MergedRacer),
+		ResultsMoved: preview.ResultsMoved,
+		Conflicts:    conflicts,
+		CanMerge:     preview.CanMerge(false),
+		CanForce:     preview.CanMerge(true),
+	}
+}
+
+func FormatRacerMergesForFeed(req *http.Request, merges []database.RacerMerge) api.RacerMergeHistoryFeed {
+
+	mergeList := make([]api.RacerMergeHistory, len(merges))
+	for i := range merges {
+		mergeList[i] = api.RacerMergeHistory{
+			Id:          strconv.Itoa(merges[i].ID),
+			Racer:       FormatRacerForFeed(req, database.Racer{ID: merges[i].ParentRacerID}),
+			MergedRacer: FormatRacerForFeed(req, database.Racer{ID: merges[i].MergedRacerID}),
+			Merged:      merges[i].Created.Format(time.RFC3339),
+		}
+		if merges[i].Undone != nil {
+			mergeList[i].Undone = merges[i].Undone.Format(time.RFC3339)
+		} else {
+			mergeList[i].UndoPath = fmt.Sprintf("http://%s/feed/racer/%d/merge/%d/undo", req.Host, merges[i].ParentRacerID, merges[i].ID)
+		}
+	}
+
+	return api.RacerMergeHistoryFeed{Merges: mergeList}
+}
+
+func FormatDuplicateRacersForFeed(req *http.Request, duplicates []database.DuplicateRacers) api.DuplicateRacersFeed {
+
+	duplicateList := make([]api.DuplicateRacers, len(duplicates))
+	for i := range duplicates {
+		duplicateList[i] = api.DuplicateRacers{
+			Racer:      FormatRacerForFeed(req, duplicates[i].Racer),
+			Duplicate:  FormatRacerForFeed(req, duplicates[i].Duplicate),
+			Score:      duplicates[i].Score,
+			Evidence:   duplicates[i].Evidence,
+			AcceptPath: fmt.Sprintf("http://%s/feed/racers/duplicates/%d/%d/accept", req.Host, duplicates[i].Racer.ID, duplicates[i].Duplicate.ID),
+		}
+	}
+
+	return api.DuplicateRacersFeed{Duplicates: duplicateList}
+}
+
+func FormatRacerAliasesForFeed(req *http.Request, aliases []database.RacerAlias) api.RacerAliasFeed {
+
+	aliasList := make([]api.RacerAlias, len(aliases))
+	for i := range aliases {
+		aliasList[i] = FormatRacerAliasForFeed(req, aliases[i])
+	}
+
+	return api.RacerAliasFeed{Aliases: aliasList}
+}
+
+func FormatRacerAliasForFeed(req *http.Request, alias database.RacerAlias) api.RacerAlias {
+	return api.RacerAlias{
+		Id:        strconv.Itoa(alias.ID),
+		Name:      alias.Name,
+		SelfPath:  fmt.Sprintf("http://%s/feed/racer/%d/alias/%d", req.Host, alias.RacerID, alias.ID),
+		RacerPath: fmt.Sprintf("http://%s/feed/racer/%d", req.Host, alias.RacerID),
+	}
+}
+
+//FormatRaceResultPageForFeed The results of a race with the number matching
+//the filter and the paths of the pages either side
+func FormatRaceResultPageForFeed(req *http.Request, filter database.ResultFilter, total int, raceresults []database.RaceResult, racers []database.Racer, races []database.Race) api.RaceResults {
+
+	feed := FormatRaceResultsForFeed(req, raceresults, racers, races)
+	feed.Total = &total
+
+	if filter.Ranking == database.RankChipTime {
+		feed.Ranking = database.RankChipTime
+		for i := range feed.Results {
+			feed.Results[i] = chipPlacedResult(feed.Results[i], raceresults[i])
+		}
+	}
+	feed.SelfPath = offsetPagePath(req, filter.Offset)
+
+	if filter.Limit > 0 {
+		if filter.Offset+filter.Limit < total {
+			feed.NextPath = offsetPagePath(req, filter.Offset+filter.Limit)
+		}
+		if filter.Offset > 0 {
+			previous := filter.Offset - filter.Limit
+			if previous < 0 {
+				previous = 0
+			}
+			feed.PreviousPath = offsetPagePath(req, previous)
+		}
+	}
+
+	return feed
+}
+
+func FormatRaceResultsForFeed(req *http.Request, raceresults []database.RaceResult, racers []database.Racer, races []database.Race) api.RaceResults {
+
 	mapRacers := map[string]api.Racer{}
 	for i := range racers {
 		mapRacers[strconv.Itoa(racers[i].ID)] = FormatRacerForFeed(req, racers[i])
@@ -119,21 +291,175 @@ func FormatRaceResultsForFeed(req *http.Request, raceresults []database.RaceResu
 
 	rr := make([]api.RaceResult, len(raceresults))
 	for i := range raceresults {
-		rr[i] = api.RaceResult{
-			Name:                raceresults[i].Name,
-			Position:            raceresults[i].Position,
-			SexPosition:         raceresults[i].SexPosition,
-			Sex:                 raceresults[i].Sex,
-			AgeCategoryPosition: raceresults[i].AgeCategoryPosition,
-			RacerID:             strconv.Itoa(raceresults[i].RacerID),
-			RaceID:              strconv.Itoa(raceresults[i].RaceID),
-			BibNumber:           raceresults[i].BibNumber,
-			Time:                raceresults[i].Time,
-			AgeCategory:         ageMap[raceresults[i].AgeCategoryID],
-			Club:                raceresults[i].Club,
-			ChipTime:            raceresults[i].ChipTime,
-		}
+		rr[i] = FormatRaceResultForFeed(raceresults[i])
 	}
 
 	return api.RaceResults{Results: rr, Racers: mapRacers, Races: mapRaces}
 }
+
+func FormatRaceResultForFeed(result database.RaceResult) api.RaceResult {
+	return api.RaceResult{
+		Id:                  strconv.Itoa(result.ID),
+		Name:                result.Name,
+		Position:            result.Position,
+		SexPosition:         result.SexPosition,
+		Sex:                 result.Sex,
+		AgeCategoryPosition: result.AgeCategoryPosition,
+		RacerID:             strconv.Itoa(result.RacerID),
+		RaceID:              strconv.Itoa(result.RaceID),
+		BibNumber:           result.BibNumber,
+		Time:                result.Time,
+		TimeMs:              result.TimeMs,
+		AgeCategory:         result.AgeCategory.Name,
+		Bracket:             result.Bracket,
+		Club:                result.Club,
+		ChipTime:            result.ChipTime,
+		ChipTimeMs:          result.ChipTimeMs,
+	}
+}
+
+//chipPlacedResult places the result by its chip time, keeping the places by
+//gun time as the gun places
+func chipPlacedResult(feedResult api.RaceResult, result database.RaceResult) api.RaceResult {
+	feedResult.GunPosition, feedResult.GunSexPosition, feedResult.GunAgeCategoryPosition = feedResult.Position, feedResult.SexPosition, feedResult.AgeCategoryPosition
+	feedResult.Position = result.ChipPosition
+	feedResult.SexPosition = result.ChipSexPosition
+	feedResult.AgeCategoryPosition = result.ChipAgeCategoryPosition
+	return feedResult
+}
+
+func FormatAgeCategoriesForFeed(req *http.Request, categories []database.AgeCategory, aliases []database.AgeCategoryAlias) api.AgeCategoryFeed {
+	byCategory := map[int][]database.AgeCategoryAlias{}
+	for i := range aliases {
+		byCategory[aliases[i].AgeCategoryID] = append(byCategory[aliases[i].AgeCategoryID], aliases[i])
+	}
+
+	c := make([]api.AgeCategory, len(categories))
+	for i := range categories {
+		c[i] = FormatAgeCategoryForFeed(req, categories[i], byCategory[categories[i].ID])
+	}
+	return api.AgeCategoryFeed{AgeCategories: c}
+}
+
+func FormatAgeCategoryForFeed(req *http.Request, category database.AgeCategory, aliases []database.AgeCategoryAlias) api.AgeCategory {
+	a := make([]api.AgeCategoryAlias, len(aliases))
+	for i := range aliases {
+		a[i] = FormatAgeCategoryAliasForFeed(req, aliases[i])
+	}
+
+	return api.AgeCategory{
+		Id:          strconv.Itoa(category.ID),
+		Name:        category.Name,
+		MinAge:      category.MinAge,
+		MaxAge:      category.MaxAge,
+		Aliases:     a,
+		SelfPath:    fmt.Sprintf("http://%s/feed/agecategory/%d", req.Host, category.ID),
+		AliasesPath: fmt.Sprintf("http://%s/feed/agecategory/%d/aliases", req.Host, category.ID),
+	}
+}
+
+func FormatAgeCategoryAliasForFeed(req *http.Request, alias database.AgeCategoryAlias) api.AgeCategoryAlias {
+	return api.AgeCategoryAlias{
+		Id:       strconv.Itoa(alias.ID),
+		Name:     alias.Name,
+		SelfPath: fmt.Sprintf("http://%s/feed/agecategory/%d/alias/%d", req.Host, alias.AgeCategoryID, alias.ID),
+	}
+}
+
+func FormatRacerSearchForFeed(req *http.Request, search database.RacerSearch, matches []database.RacerSearchMatch, total int) api.RacerSearchFeed {
+	racers := make([]api.RacerSearchResult, len(matches))
+	for i := range matches {
+		clubs := matches[i].Clubs
+		if clubs == nil {
+			clubs = []string{}
+		}
+		racers[i] = api.RacerSearchResult{
+			Name:  matches[i].Name,
+			Match: matches[i].Match,
+			Races: matches[i].Races,
+			Sex:   matches[i].Sex,
+			Clubs: clubs,
+			Racer: FormatRacerForFeed(req, database.Racer{ID: matches[i].RacerID}),
+		}
+	}
+
+	feed := api.RacerSearchFeed{
+		Racers:   racers,
+		Total:    total,
+		SelfPath: offsetPagePath(req, search.Offset),
+	}
+
+	if search.Offset+search.Limit < total {
+		feed.NextPath = offsetPagePath(req, search.Offset+search.Limit)
+	}
+
+	if search.Offset > 0 {
+		previous := search.Offset - search.Limit
+		if previous < 0 {
+			previous = 0
+		}
+		feed.PreviousPath = offsetPagePath(req, previous)
+	}
+
+	return feed
+}
+
+//offsetPagePath is the list with the offset of another page
+func offsetPagePath(req *http.Request, offset int) string {
+	query := req.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	return fmt.Sprintf("http://%s%s?%s", req.Host, req.URL.Path, query.Encode())
+}
+
+func FormatRacerSuggestionsForFeed(req *http.Request, suggestions []database.RacerSuggestion) api.RacerSuggestionFeed {
+	s := make([]api.RacerSuggestion, len(suggestions))
+	for i := range suggestions {
+		s[i] = api.RacerSuggestion{
+			Name:  suggestions[i].Name,
+			Races: suggestions[i].Races,
+			Racer: FormatRacerForFeed(req, database.Racer{ID: suggestions[i].RacerID}),
+		}
+	}
+	return api.RacerSuggestionFeed{Suggestions: s}
+}
+
+func FormatAuditEntriesForFeed(req *http.Request, entries []database.AuditEntry) api.AuditFeed {
+	e := make([]api.AuditEntry, len(entries))
+	for i := range entries {
+		e[i] = FormatAuditEntryForFeed(req, entries[i])
+	}
+	return api.AuditFeed{Entries: e}
+}
+
+func FormatAuditEntryForFeed(req *http.Request, entry database.AuditEntry) api.AuditEntry {
+	a := api.AuditEntry{
+		Id:       strconv.Itoa(entry.ID),
+		Actor:    entry.Actor,
+		Action:   entry.Action,
+		Entity:   entry.Entity,
+		EntityId: strconv.Itoa(entry.EntityID),
+		Created:  entry.Created.UTC().Format(time.RFC3339),
+	}
+
+	switch entry.Entity {
+	case database.AuditRace:
+		a.EntityPath = fmt.Sprintf("http://%s/feed/race/%d", req.Host, entry.EntityID)
+	case database.AuditRaceGroup:
+		a.EntityPath = fmt.Sprintf("http://%s/feed/racegroup/%d", req.Host, entry.EntityID)
+	case database.AuditRacer:
+		a.EntityPath = fmt.Sprintf("http://%s/feed/racer/%d", req.Host, entry.EntityID)
+	case database.AuditAgeCategory:
+		a.EntityPath = fmt.Sprintf("http://%s/feed/agecategory/%d", req.Host, entry.EntityID)
+	case database.AuditImportTask:
+		a.EntityPath = FormatImportTaskLocation(req, entry.EntityID)
+	}
+
+	if len(entry.Before) > 0 {
+		a.Before = json.RawMessage(entry.Before)
+	}
+	if len(entry.After) > 0 {
+		a.After = json.RawMessage(entry.After)
+	}
+
+	return a
+}
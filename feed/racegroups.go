@@ -2,6 +2,7 @@ package feed
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -28,9 +29,21 @@ func (r *FeedResource) CreateRaceGroup(res http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	raceGroupDB, _ := r.Db.CreateRaceGroup(raceGroup.Name, raceGroup.Distance, raceGroup.DistanceUnit)
+	var raceGroupFeed api.RaceGroup
 
-	raceGroupFeed := FormatRaceGroupForFeed(req, raceGroupDB)
+	err = r.Db.Transaction(func(tx database.Store) error {
+		raceGroupDB, err := tx.CreateRaceGroup(raceGroup.Name, raceGroup.Distance, raceGroup.DistanceUnit)
+		if err != nil {
+			return err
+		}
+		raceGroupFeed = FormatRaceGroupForFeed(req, raceGroupDB)
+		return RecordAudit(tx, req, "create", database.AuditRaceGroup, raceGroupDB.ID, nil, raceGroupFeed)
+	})
+
+	if err != nil {
+		HandleError(err, res)
+		return
+	}
 
 	raceGroupFeedFormatted, _ := json.Marshal(&raceGroupFeed)
 
@@ -43,56 +56,77 @@ func (r *FeedResource) CreateRaceGroup(res http.ResponseWriter, req *http.Reques
 // UpdateRaceGroup Update the race group
 func (r *FeedResource) UpdateRaceGroup(res http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
-
-	raceGroupID, err := strconv.Atoi(vars["id"])
+	raceGroupDB := r.getRaceGroupOrSendError(res, req)
 
-	if err != nil {
-		http.Error(res, err.Error(), 400)
-	}
-
-	raceGroupDB, err := r.Db.GetRaceGroup(int(raceGroupID))
-
-	if err != nil {
-		http.Error(res, err.Error(), 404)
+	if raceGroupDB == nil {
+		return
 	}
 
 	var raceGroup api.RaceGroupCreate
 
 	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&raceGroup)
+	err := decoder.Decode(&raceGroup)
 
 	if err != nil {
 		http.Error(res, "Bad Parameters", http.StatusBadRequest)
 		return
 	}
 
-	var raceGroupUpdated database.RaceGroup
+	var updatedFeed api.RaceGroup
 
-	if raceGroupUpdated, err = r.Db.UpdateRaceGroup(raceGroupDB.ID, raceGroup.Name, raceGroup.Distance, raceGroup.DistanceUnit); err != nil {
-		http.Error(res, err.Error(), 500)
+	err = r.Db.Transaction(func(tx database.Store) error {
+		before, err := tx.GetRaceGroup(raceGroupDB.ID)
+		if err != nil {
+			return err
+		}
+		raceGroupUpdated, err := tx.UpdateRaceGroup(before.ID, raceGroup.Name, raceGroup.Distance, raceGroup.DistanceUnit)
+		if err != nil {
+			return err
+		}
+		updatedFeed = FormatRaceGroupForFeed(req, raceGroupUpdated)
+		return RecordAudit(tx, req, "update", database.AuditRaceGroup, raceGroupUpdated.ID, FormatRaceGroupForFeed(req, before), updatedFeed)
+	})
+
+	if err != nil {
+		HandleError(err, res)
+		return
 	}
 
-	SendJson(res, FormatRaceGroupForFeed(req, raceGroupUpdated))
+	SendJson(res, updatedFeed)
 
 }
 
-// DeleteRaceGroup Delete the race group
+// DeleteRaceGroup Move the race group to the trash
 func (r *FeedResource) DeleteRaceGroup(res http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
-
-	raceGroupID, err := strconv.Atoi(vars["id"])
+	raceGroup := r.getRaceGroupOrSendError(res, req)
 
-	if err != nil {
-		http.Error(res, err.Error(), 404)
+	if raceGroup == nil {
+		return
 	}
 
-	if _, err := r.Db.DeleteRaceGroup(int(raceGroupID)); err != nil {
-		http.Error(res, err.Error(), 500)
+	var deletedFeed api.RaceGroup
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		before, err := tx.GetRaceGroup(raceGroup.ID)
+		if err != nil {
+			return err
+		}
+		deleted, err := tx.DeleteRaceGroup(before.ID)
+		if err != nil {
+			return err
+		}
+		deletedFeed = FormatRaceGroupForFeed(req, deleted)
+		return RecordAudit(tx, req, "delete", database.AuditRaceGroup, deleted.ID, FormatRaceGroupForFeed(req, before), deletedFeed)
+	})
+
+	if err != nil {
+		HandleError(err, res)
+		return
 	}
 
-	res.WriteHeader(http.StatusOK)
+	//the race group is in the trash, send it back with the path to restore it
+	SendJson(res, deletedFeed)
 
 }
 
@@ -101,7 +135,14 @@ func (r *FeedResource) ListRaceGroups(res http.ResponseWriter, req *http.Request
 
 	var etag string
 
-	if raceGroupLastUpdated, err := r.Db.GetLastUpdatedRaceGroup(); err == nil {
+	raceGroupLastUpdated, err := r.Db.GetLastUpdatedRaceGroup()
+
+	if err != nil && !errors.Is(err, database.ErrNoRecordsAvailable) {
+		HandleError(err, res)
+		return
+	}
+
+	if err == nil {
 		if ok, _ := SendNotModifiedIfETagIsValid(res, req, raceGroupLastUpdated.ETag); ok {
 			return
 		}
@@ -111,7 +152,8 @@ func (r *FeedResource) ListRaceGroups(res http.ResponseWriter, req *http.Request
 	raceGroups, err := r.Db.GetRaceGroups()
 
 	if err != nil {
-		http.Error(res, err.Error(), 500)
+		HandleError(err, res)
+		return
 	}
 
 	if len(etag) > 0 {
@@ -123,33 +165,30 @@ func (r *FeedResource) ListRaceGroups(res http.ResponseWriter, req *http.Request
 
 // GetRaceGroup Fetch the requested race group and return its data
 func (r *FeedResource) GetRaceGroup(res http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
 
-	raceGroupID, err := strconv.Atoi(vars["id"])
+	raceGroup := r.getRaceGroupOrSendError(res, req)
 
-	if err != nil {
-		http.Error(res, err.Error(), 404)
+	if raceGroup == nil {
+		return
 	}
 
-	raceGroupDB, _ := r.Db.GetRaceGroup(int(raceGroupID))
-
-	SendJson(res, FormatRaceGroupForFeed(req, raceGroupDB))
+	SendJson(res, FormatRaceGroupForFeed(req, *raceGroup))
 
 }
 
 func (r *FeedResource) GetRacesForRaceGroup(res http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
 
-	raceGroupId, err := strconv.Atoi(vars["id"])
+	raceGroup := r.getRaceGroupOrSendError(res, req)
 
-	if err != nil {
-		http.Error(res, err.Error(), 404)
+	if raceGroup == nil {
+		return
 	}
 
-	races, err := r.Db.GetRacesForRaceGroup(int(raceGroupId))
+	races, err := r.Db.GetRacesForRaceGroup(raceGroup.ID)
 
 	if err != nil {
-		http.Error(res, err.Error(), 500)
+		HandleError(err, res)
+		return
 	}
 
 	SendJson(res, FormatRacesForFeed(req, races))
@@ -158,7 +197,11 @@ func (r *FeedResource) GetRacesForRaceGroup(res http.ResponseWriter, req *http.R
 
 func (r *FeedResource) AddRaceToRaceGroup(res http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
+	raceGroup := r.getRaceGroupOrSendError(res, req)
+
+	if raceGroup == nil {
+		return
+	}
 
 	var addRaceGroup api.RaceGroupAddRace
 
@@ -166,30 +209,59 @@ func (r *FeedResource) AddRaceToRaceGroup(res http.ResponseWriter, req *http.Req
 	err := decoder.Decode(&addRaceGroup)
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(ErrBadRequest, res)
+		return
 	}
 
 	raceId, err := strconv.Atoi(addRaceGroup.RaceId)
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(ErrBadRequest, res)
+		return
 	}
 
-	race, err := r.Db.GetRace(raceId)
+	err = r.Db.Transaction(func(tx database.Store) error {
+		race, err := tx.GetRace(raceId)
+		if errors.Is(err, database.ErrRecordNotFoundError) {
+			return ErrBadRequest
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.AddRaceToRaceGroup(*raceGroup, race); err != nil {
+			return err
+		}
 
-	raceGroupId, err := strconv.Atoi(vars["id"])
+		added := race
+		added.RaceGroupID = raceGroup.ID
+
+		return RecordAudit(tx, req, "add-to-racegroup", database.AuditRace, race.ID, FormatRaceForFeed(req, race), FormatRaceForFeed(req, added))
+	})
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(err, res)
+		return
 	}
 
-	raceGroup, err := r.Db.GetRaceGroup(raceGroupId)
+	SendSuccess(res)
+}
+
+func (r *FeedResource) getRaceGroupOrSendError(res http.ResponseWriter, req *http.Request) *database.RaceGroup {
+
+	raceGroupID, err := strconv.Atoi(mux.Vars(req)["id"])
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(ErrNotFound, res)
+		return nil
 	}
 
-	r.Db.AddRaceToRaceGroup(raceGroup, race)
+	raceGroup, err := r.Db.GetRaceGroup(raceGroupID)
 
-	SendSuccess(res)
+	if err != nil {
+		HandleError(err, res)
+		return nil
+	}
+
+	return &raceGroup
 }
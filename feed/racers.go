@@ -2,128 +2,688 @@ package feed
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/chiefwhitecloud/running-man/api"
+	"github.com/chiefwhitecloud/running-man/database"
 	"github.com/gorilla/mux"
 )
 
 //GetRacer Fetch Racer
 func (r *FeedResource) GetRacer(res http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
-
-	racerID, err := strconv.Atoi(vars["id"])
-
-	if err != nil {
-		http.Error(res, err.Error(), 404)
-	}
-
-	racer, err := r.Db.GetRacer(racerID)
+	racer := r.GetRacerOrSendError(res, req)
 
-	if err != nil {
-		http.Error(res, err.Error(), 500)
+	if racer == nil {
+		return
 	}
 
-	SendJson(res, FormatRacerForFeed(req, racer))
+	SendJson(res, FormatRacerForFeed(req, *racer))
 }
 
 //GetRacerProfile Fetch racer profile
 func (r *FeedResource) GetRacerProfile(res http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
+	racer := r.GetRacerOrSendError(res, req)
 
-	racerID, err := strconv.Atoi(vars["id"])
+	if racer == nil {
+		return
+	}
+
+	if ok, _ := SendNotModifiedIfETagIsValid(res, req, racer.ETag); ok {
+		return
+	}
+
+	lowBirthDate, highBirthDate, err := r.Db.GetRacerBirthDates(racer.ID)
 
 	if err != nil {
-		http.Error(res, err.Error(), 404)
+		HandleError(err, res)
+		return
 	}
 
-	racer, err := r.Db.GetRacer(racerID)
+	names, err := r.Db.GetRacerNames(racer.ID)
 
-	lowBirthDate, highBirthDate, _ := r.Db.GetRacerBirthDates(racerID)
-	names, _ := r.Db.GetRacerNames(racerID)
+	if err != nil {
+		HandleError(err, res)
+		return
+	}
 
 	racerProfile := api.RacerProfile{
-		Name:          names[0],
 		NameList:      names,
 		SelfPath:      fmt.Sprintf("http://%s/feed/racer/%d/profile", req.Host, racer.ID),
 		BirthDateLow:  fmt.Sprintf("%0.4d-%0.2d-%0.2d", lowBirthDate.Year(), lowBirthDate.Month(), lowBirthDate.Day()),
 		BirthDateHigh: fmt.Sprintf("%0.4d-%0.2d-%0.2d", highBirthDate.Year(), highBirthDate.Month(), highBirthDate.Day()),
 	}
 
-	SendJson(res, racerProfile)
+	if len(names) > 0 {
+		racerProfile.Name = names[0]
+	}
+
+	SendJsonWithETag(res, racerProfile, racer.ETag)
 
 }
 
 //GetRaceResultsForRacer Fetch results for the racer
 func (r *FeedResource) GetRaceResultsForRacer(res http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
+	racer := r.GetRacerOrSendError(res, req)
 
-	racerID, err := strconv.Atoi(vars["id"])
+	if racer == nil {
+		return
+	}
+
+	filter, err := r.parseResultFilter(req)
+
+	if err != nil {
+		HandleError(err, res)
+		return
+	}
+
+	export, err := parseResultExport(req)
+
+	if err != nil {
+		HandleError(err, res)
+		return
+	}
+
+	varyOnAccept(res)
+
+	ok, err := SendNotModifiedIfETagIsValid(res, req, racer.ETag)
 
 	if err != nil {
-		http.Error(res, err.Error(), 404)
+		HandleError(err, res)
+		return
+	}
+
+	if ok {
+		return
+	}
+
+	if export.Format != formatJSON {
+		export.sendRows(res, racer.ETag, fmt.Sprintf("racer-%d-results", racer.ID), "", func(write func(row resultRow) error) error {
+			return r.Db.EachRaceResultForRacer(uint(racer.ID), filter, exportRows(req, "", write))
+		})
+		return
 	}
 
-	rr, racers, races, err := r.Db.GetRaceResultsForRacer(uint(racerID))
+	rr, racers, races, err := r.Db.GetRaceResultsForRacer(uint(racer.ID), filter)
 
 	if err != nil {
-		http.Error(res, err.Error(), 500)
+		HandleError(err, res)
+		return
 	}
 
-	SendJson(res, FormatRaceResultsForFeed(req, rr, racers, races))
+	SendJsonWithETag(res, FormatRaceResultsForFeed(req, rr, racers, races), racer.ETag)
 }
 
-//MergeRacer Merge to racers results
-func (r *FeedResource) MergeRacer(res http.ResponseWriter, req *http.Request) {
+//PreviewMergeRacer Show the conflicts merging the racer given by racerId would cause
+func (r *FeedResource) PreviewMergeRacer(w http.ResponseWriter, req *http.Request) {
 
-	vars := mux.Vars(req)
+	parentRacer := r.GetRacerOrSendError(w, req)
+
+	if parentRacer == nil {
+		return
+	}
 
-	parentRacerID, err := strconv.Atoi(vars["id"])
+	racer, err := r.getMergedRacer(*parentRacer, req.URL.Query().Get("racerId"))
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(err, w)
+		return
 	}
 
-	parentRacer, err := r.Db.GetRacer(parentRacerID)
+	preview, err := r.Db.PreviewRacerMerge(*parentRacer, racer)
 
 	if err != nil {
-		http.Error(res, err.Error(), 404)
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRacerMergePreviewForFeed(req, preview))
+}
+
+//MergeRacer Merge to racers results
+func (r *FeedResource) MergeRacer(w http.ResponseWriter, req *http.Request) {
+
+	parentRacer := r.GetRacerOrSendError(w, req)
+
+	if parentRacer == nil {
+		return
 	}
 
 	var racerMerge api.RacerMerge
 
 	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&racerMerge)
+
+	if err := decoder.Decode(&racerMerge); err != nil {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	racer, err := r.getMergedRacer(*parentRacer, racerMerge.RacerId)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	r.mergeRacers(w, req, *parentRacer, racer, racerMerge.Force)
+}
+
+//GetRacerMerges List the merges into the racer
+func (r *FeedResource) GetRacerMerges(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
+	}
+
+	merges, err := r.Db.GetRacerMerges(racer.ID)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRacerMergesForFeed(req, merges))
+}
+
+//UnmergeRacer Undo a merge, restoring the merged racer and its results
+func (r *FeedResource) UnmergeRacer(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
+	}
+
+	mergeID, err := strconv.Atoi(mux.Vars(req)["mergeId"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return
+	}
+
+	merge, err := r.Db.GetRacerMerge(racer.ID, mergeID)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	var restored database.Racer
+	var moved int
+
+	err = r.Db.Transaction(func(tx database.Store) error {
+		before, err := snapshotRacer(tx, req, *racer)
+		if err != nil {
+			return err
+		}
+
+		if restored, moved, err = tx.UnmergeRacers(merge); err != nil {
+			return err
+		}
+
+		after, err := snapshotRacers(tx, req, map[string]database.Racer{"racer": *racer, "mergedRacer": restored})
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "unmerge", database.AuditRacer, racer.ID, before, after)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, api.RacerMergeResult{
+		Racer:         FormatRacerForFeed(req, *racer),
+		MergedRacerId: strconv.Itoa(restored.ID),
+		ResultsMoved:  moved,
+	})
+}
+
+//SplitRacer Move some of the racer's results to another racer, or a new one
+func (r *FeedResource) SplitRacer(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
+	}
+
+	var split api.RacerSplit
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&split); err != nil || len(split.ResultIds) == 0 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	resultIds := make([]int, len(split.ResultIds))
+	for i := range split.ResultIds {
+		id, err := strconv.Atoi(split.ResultIds[i])
+		if err != nil {
+			HandleError(ErrBadRequest, w)
+			return
+		}
+		resultIds[i] = id
+	}
+
+	var target *database.Racer
+
+	if len(split.RacerId) > 0 {
+		targetRacer, err := r.getMergedRacer(*racer, split.RacerId)
+		if err != nil {
+			HandleError(err, w)
+			return
+		}
+		target = &targetRacer
+	}
+
+	var newRacer database.Racer
+	var moved int
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		before, err := snapshotRacer(tx, req, *racer)
+		if err != nil {
+			return err
+		}
+
+		newRacer, moved, err = tx.SplitRaceResults(*racer, resultIds, target)
+		if errors.Is(err, database.ErrRecordNotFoundError) {
+			return ErrBadRequest
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := snapshotRacers(tx, req, map[string]database.Racer{"racer": *racer, "newRacer": newRacer})
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "split", database.AuditRacer, racer.ID, before, after)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, api.RacerMergeResult{
+		Racer:         FormatRacerForFeed(req, newRacer),
+		MergedRacerId: strconv.Itoa(racer.ID),
+		ResultsMoved:  moved,
+	})
+}
+
+//mergeRacers Merge the racer and respond with the result, or the conflicts preventing it
+func (r *FeedResource) mergeRacers(w http.ResponseWriter, req *http.Request, parentRacer database.Racer, racer database.Racer, force bool) {
+
+	var preview database.RacerMergePreview
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		before, err := snapshotRacers(tx, req, map[string]database.Racer{"racer": parentRacer, "mergedRacer": racer})
+		if err != nil {
+			return err
+		}
+
+		if preview, err = tx.MergeRacers(parentRacer, racer, force); err != nil {
+			return err
+		}
+
+		after, err := snapshotRacer(tx, req, parentRacer)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "merge", database.AuditRacer, parentRacer.ID, before, after)
+	})
+
+	if errors.Is(err, database.ErrMergeConflict) {
+		sendConflict(w, FormatRacerMergePreviewForFeed(req, preview))
+		return
+	}
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, api.RacerMergeResult{
+		Racer:         FormatRacerForFeed(req, parentRacer),
+		MergedRacerId: strconv.Itoa(racer.ID),
+		ResultsMoved:  preview.ResultsMoved,
+	})
+}
+
+func (r *FeedResource) getMergedRacer(parentRacer database.Racer, racerID string) (database.Racer, error) {
+
+	id, err := strconv.Atoi(racerID)
+
+	if err != nil {
+		return database.Racer{}, ErrBadRequest
+	}
+
+	if id == parentRacer.ID {
+		return database.Racer{}, ErrBadRequest
+	}
+
+	racer, err := r.Db.GetRacer(id)
+
+	if errors.Is(err, database.ErrRecordNotFoundError) {
+		return racer, ErrBadRequest
+	}
+
+	return racer, err
+}
+
+//SearchRacers Find the racers known by the name, or a nickname or similar
+//sounding version of it.  Deprecated: /feed/racers?q= finds the same racers,
+//a page at a time.
+func (r *FeedResource) SearchRacers(w http.ResponseWriter, req *http.Request) {
+
+	name := strings.TrimSpace(req.URL.Query().Get("name"))
+
+	if len(name) == 0 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	successor := url.Values{"q": {name}}
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("<http://%s/feed/racers?%s>; rel=\"successor-version\"", req.Host, successor.Encode()))
+
+	matches, err := r.Db.FindRacersForName(name)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRacerMatchesForFeed(req, matches))
+}
+
+//ListRacers Find racers by any of their names, narrowed down by sex, club and
+//birth year, a page at a time
+func (r *FeedResource) ListRacers(w http.ResponseWriter, req *http.Request) {
+
+	query := req.URL.Query()
+
+	search := database.RacerSearch{
+		Query: strings.TrimSpace(query.Get("q")),
+		Sex:   strings.TrimSpace(query.Get("sex")),
+		Club:  strings.TrimSpace(query.Get("club")),
+		Limit: 20,
+	}
+
+	if len(search.Query) == 0 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	for param, value := range map[string]*int{"bornFrom": &search.BornFrom, "bornTo": &search.BornTo, "offset": &search.Offset, "limit": &search.Limit} {
+		if v := query.Get(param); len(v) > 0 {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				HandleError(ErrBadRequest, w)
+				return
+			}
+			*value = n
+		}
+	}
+
+	if search.Limit == 0 || search.Limit > 100 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	matches, total, err := r.Db.SearchRacers(search)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRacerSearchForFeed(req, search, matches, total))
+}
+
+//SuggestRacers Suggest the racers with a name starting with what has been
+//typed, the racers with the most races first
+func (r *FeedResource) SuggestRacers(w http.ResponseWriter, req *http.Request) {
+
+	prefix := strings.TrimSpace(req.URL.Query().Get("prefix"))
+
+	if len(prefix) == 0 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	limit := 10
+
+	if v := req.URL.Query().Get("limit"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			HandleError(ErrBadRequest, w)
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := r.Db.SuggestRacers(prefix, limit)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRacerSuggestionsForFeed(req, suggestions))
+}
+
+//ListDuplicateRacers Suggest pairs of racers that are likely the same person
+func (r *FeedResource) ListDuplicateRacers(w http.ResponseWriter, req *http.Request) {
+
+	duplicates, err := r.Db.FindDuplicateRacers()
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatDuplicateRacersForFeed(req, duplicates))
+}
+
+//AcceptDuplicateRacers Merge a suggested duplicate into the racer
+func (r *FeedResource) AcceptDuplicateRacers(w http.ResponseWriter, req *http.Request) {
+
+	vars := mux.Vars(req)
+
+	racerID, err := strconv.Atoi(vars["id"])
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(ErrNotFound, w)
+		return
 	}
 
-	racerID, err := strconv.Atoi(racerMerge.RacerId)
+	duplicateID, err := strconv.Atoi(vars["duplicateId"])
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(ErrNotFound, w)
+		return
 	}
 
 	racer, err := r.Db.GetRacer(racerID)
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(err, w)
+		return
 	}
 
-	_, err = r.Db.MergeRacers(parentRacer, racer)
+	duplicate, err := r.Db.GetRacer(duplicateID)
 
 	if err != nil {
-		http.Error(res, err.Error(), 400)
+		HandleError(err, w)
+		return
+	}
+
+	if racer.ID == duplicate.ID {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	r.mergeRacers(w, req, racer, duplicate, false)
+}
+
+//GetRacerAliases Fetch the names the racer is also known by
+func (r *FeedResource) GetRacerAliases(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
 	}
 
-	//res.Header().Set("Content-Type", "application/json")
-	//res.WriteHeader(http.StatusOK)
-	//res.Write([]byte(raceFeedFormatted))
+	aliases, err := r.Db.GetRacerAliases(racer.ID)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRacerAliasesForFeed(req, aliases))
+}
+
+//CreateRacerAlias Record another name the racer is known by
+func (r *FeedResource) CreateRacerAlias(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
+	}
+
+	var aliasCreate api.RacerAliasCreate
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&aliasCreate); err != nil {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	name := strings.TrimSpace(aliasCreate.Name)
+
+	if len(name) == 0 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	var aliasFeed api.RacerAlias
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		alias, err := tx.CreateRacerAlias(*racer, name)
+		if err != nil {
+			return err
+		}
+		aliasFeed = FormatRacerAliasForFeed(req, alias)
+		return RecordAudit(tx, req, "create-alias", database.AuditRacer, racer.ID, nil, aliasFeed)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	b, err := json.Marshal(aliasFeed)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	jsonResponse(w)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+//DeleteRacerAlias Remove a name from the racer
+func (r *FeedResource) DeleteRacerAlias(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
+	}
+
+	aliasID, err := strconv.Atoi(mux.Vars(req)["aliasId"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return
+	}
+
+	err = r.Db.Transaction(func(tx database.Store) error {
+		alias, err := tx.DeleteRacerAlias(racer.ID, aliasID)
+		if err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "delete-alias", database.AuditRacer, racer.ID, FormatRacerAliasForFeed(req, alias), nil)
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *FeedResource) GetRacerOrSendError(w http.ResponseWriter, req *http.Request) *database.Racer {
+	vars := mux.Vars(req)
+
+	racerID, err := strconv.Atoi(vars["id"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return nil
+	}
+
+	racer, err := r.Db.GetRacer(racerID)
+
+	if errors.Is(err, database.ErrRecordNotFoundError) {
+		//the racer may have been merged into another
+		if redirect, err := r.Db.GetRacerRedirect(racerID); err == nil {
+			sendRacerRedirect(w, req, racerID, redirect.NewRacerID)
+			return nil
+		}
+	}
+
+	if err != nil {
+		HandleError(err, w)
+		return nil
+	}
+
+	return &racer
+}
+
+func sendRacerRedirect(w http.ResponseWriter, req *http.Request, oldRacerID int, newRacerID int) {
+	path := strings.Replace(req.URL.Path, fmt.Sprintf("/racer/%d", oldRacerID), fmt.Sprintf("/racer/%d", newRacerID), 1)
+
+	location := fmt.Sprintf("http://%s%s", req.Host, path)
+	if len(req.URL.RawQuery) > 0 {
+		location += "?" + req.URL.RawQuery
+	}
+
+	status := http.StatusMovedPermanently
+	if req.Method != "GET" && req.Method != "HEAD" {
+		status = http.StatusPermanentRedirect
+	}
 
+	http.Redirect(w, req, location, status)
 }
@@ -1,32 +1,45 @@
 package feed
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/chiefwhitecloud/running-man/api"
 	"github.com/chiefwhitecloud/running-man/database"
 	"github.com/gorilla/mux"
 )
 
-// ListRaces Return a list of race tags. Check for etag
+// ListRaces Return a list of race tags. Check for etag.  The races can be
+// filtered, sorted and paged with the querystring, each query has its own etag.
 func (r *FeedResource) ListRaces(w http.ResponseWriter, req *http.Request) {
 
+	filter, err := parseRaceFilter(req)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
 	var etag string
 
 	raceLastUpdated, err := r.Db.GetLastUpdatedRace()
 
-	if err != nil && err != database.ErrNoRecordsAvailable {
-		handleError(err, w)
+	if err != nil && !errors.Is(err, database.ErrNoRecordsAvailable) {
+		HandleError(err, w)
 		return
 	}
 
-	if err != database.ErrNoRecordsAvailable {
-		etag = raceLastUpdated.ETag
+	if err == nil {
+		etag = QueryETag(raceLastUpdated.ETag, req)
 
 		sent, error := SendNotModifiedIfETagIsValid(w, req, etag)
 
 		if error != nil {
-			handleError(error, w)
+			HandleError(error, w)
 			return
 		}
 
@@ -35,19 +48,88 @@ func (r *FeedResource) ListRaces(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	races, err := r.Db.GetRaces()
+	page, err := r.Db.GetRacePage(filter)
 
 	if err != nil {
-		handleError(err, w)
+		HandleError(err, w)
 		return
 	}
 
 	if len(etag) > 0 {
-		SendJsonWithETag(w, FormatRacesForFeed(req, races), etag)
+		SendJsonWithETag(w, FormatRacePageForFeed(req, filter, page), etag)
 	} else {
-		SendJson(w, FormatRacesForFeed(req, races))
+		SendJson(w, FormatRacePageForFeed(req, filter, page))
+	}
+
+}
+
+//parseRaceFilter reads the optional year, from, to, raceGroup, grouped, name,
+//sort, limit, after and before querystring parameters.  Dates are given as
+//2006-01-02 and sort is date or name, with a - in front for descending.
+func parseRaceFilter(req *http.Request) (database.RaceFilter, error) {
+	filter := database.RaceFilter{Sort: database.RaceSortDate, Descending: true}
+	var err error
+
+	query := req.URL.Query()
+
+	for param, value := range map[string]*int{"year": &filter.Year, "raceGroup": &filter.RaceGroupID, "limit": &filter.Limit} {
+		if v := query.Get(param); len(v) > 0 {
+			if *value, err = strconv.Atoi(v); err != nil || *value <= 0 {
+				return filter, ErrBadRequest
+			}
+		}
+	}
+
+	if from := query.Get("from"); len(from) > 0 {
+		if filter.From, err = time.Parse("2006-01-02", from); err != nil {
+			return filter, ErrBadRequest
+		}
+	}
+
+	if to := query.Get("to"); len(to) > 0 {
+		if filter.To, err = time.Parse("2006-01-02", to); err != nil {
+			return filter, ErrBadRequest
+		}
+	}
+
+	if grouped := query.Get("grouped"); len(grouped) > 0 {
+		g, err := strconv.ParseBool(grouped)
+		if err != nil {
+			return filter, ErrBadRequest
+		}
+		filter.Grouped = &g
+	}
+
+	filter.Name = strings.TrimSpace(query.Get("name"))
+
+	if order := query.Get("sort"); len(order) > 0 {
+		filter.Descending = strings.HasPrefix(order, "-")
+		filter.Sort = strings.TrimPrefix(order, "-")
+		if filter.Sort != database.RaceSortDate && filter.Sort != database.RaceSortName {
+			return filter, ErrBadRequest
+		}
+	}
+
+	if after := query.Get("after"); len(after) > 0 {
+		cursor, err := parseRaceCursor(after)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = &cursor
+	}
+
+	if before := query.Get("before"); len(before) > 0 {
+		if filter.After != nil {
+			return filter, ErrBadRequest
+		}
+		cursor, err := parseRaceCursor(before)
+		if err != nil {
+			return filter, err
+		}
+		filter.Before = &cursor
 	}
 
+	return filter, nil
 }
 
 // GetRace Returns individual race info
@@ -62,7 +144,7 @@ func (r *FeedResource) GetRace(w http.ResponseWriter, req *http.Request) {
 	ok, err := SendNotModifiedIfETagIsValid(w, req, race.ETag)
 
 	if err != nil {
-		handleError(err, w)
+		HandleError(err, w)
 		return
 	}
 
@@ -73,7 +155,7 @@ func (r *FeedResource) GetRace(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
-//DeleteRace Delete race
+//DeleteRace Move the race to the trash
 func (r *FeedResource) DeleteRace(w http.ResponseWriter, req *http.Request) {
 
 	race := r.GetRaceOrSendError(w, req)
@@ -82,12 +164,28 @@ func (r *FeedResource) DeleteRace(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if _, err := r.Db.DeleteRace(race.ID); err != nil {
-		handleError(err, w)
+	var deletedFeed api.Race
+
+	err := r.Db.Transaction(func(tx database.Store) error {
+		before, err := tx.GetRace(race.ID)
+		if err != nil {
+			return err
+		}
+		deleted, err := tx.DeleteRace(before.ID)
+		if err != nil {
+			return err
+		}
+		deletedFeed = FormatRaceForFeed(req, deleted)
+		return RecordAudit(tx, req, "delete", database.AuditRace, deleted.ID, FormatRaceForFeed(req, before), deletedFeed)
+	})
+
+	if err != nil {
+		HandleError(err, w)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	//the race is in the trash, send it back with the path to restore it
+	SendJson(w, deletedFeed)
 
 }
 
@@ -110,7 +208,8 @@ func (r *FeedResource) GetRaceResultsForRace(res http.ResponseWriter, req *http.
 	if len(place) != 0 {
 		startPlace, err = strconv.Atoi(place)
 		if err != nil {
-			http.Error(res, err.Error(), 400)
+			HandleError(ErrBadRequest, res)
+			return
 		}
 	}
 
@@ -119,25 +218,67 @@ func (r *FeedResource) GetRaceResultsForRace(res http.ResponseWriter, req *http.
 	if len(numOfRecords) != 0 {
 		recCount, err = strconv.Atoi(numOfRecords)
 		if err != nil {
-			http.Error(res, err.Error(), 400)
+			HandleError(ErrBadRequest, res)
+			return
 		}
 	}
 
+	export, err := parseResultExport(req)
+
 	if err != nil {
-		http.Error(res, err.Error(), 404)
+		HandleError(err, res)
+		return
 	}
 
-	if ok, _ := SendNotModifiedIfETagIsValid(res, req, race.ETag); ok {
+	filter, err := r.parseResultFilter(req)
+
+	if err != nil {
+		HandleError(err, res)
 		return
 	}
 
-	rr, racers, races, err := r.Db.GetRaceResultsForRace(race.ID, startPlace, recCount)
+	if err := parseResultOrder(req, &filter); err != nil {
+		HandleError(err, res)
+		return
+	}
+
+	etag := QueryETag(race.ETag, req)
+
+	varyOnAccept(res)
+
+	ok, err := SendNotModifiedIfETagIsValid(res, req, etag)
 
 	if err != nil {
-		http.Error(res, err.Error(), 500)
+		HandleError(err, res)
+		return
+	}
+
+	if ok {
+		return
+	}
+
+	if export.Format != formatJSON {
+		export.sendRows(res, etag, fmt.Sprintf("race-%d-results", race.ID), filter.Ranking, func(write func(row resultRow) error) error {
+			return r.Db.EachRaceResultForRace(race.ID, startPlace, recCount, filter, exportRows(req, filter.Ranking, write))
+		})
+		return
+	}
+
+	rr, racers, races, err := r.Db.GetRaceResultsForRace(race.ID, startPlace, recCount, filter)
+
+	if err != nil {
+		HandleError(err, res)
+		return
+	}
+
+	total, err := r.Db.CountRaceResultsForRace(race.ID, filter)
+
+	if err != nil {
+		HandleError(err, res)
+		return
 	}
 
-	SendJsonWithETag(res, FormatRaceResultsForFeed(req, rr, racers, races), race.ETag)
+	SendJsonWithETag(res, FormatRaceResultPageForFeed(req, filter, total, rr, racers, races), etag)
 
 }
 
@@ -147,13 +288,13 @@ func (r *FeedResource) GetRaceOrSendError(w http.ResponseWriter, req *http.Reque
 	raceID, err := strconv.Atoi(vars["id"])
 
 	if err != nil {
-		handleError(ErrNotFound, w)
+		HandleError(ErrNotFound, w)
 		return nil
 	}
 
 	race, err := r.Db.GetRace(raceID)
 	if err != nil {
-		handleError(err, w)
+		HandleError(err, w)
 		return nil
 	}
 
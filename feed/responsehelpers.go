@@ -1,6 +1,8 @@
 package feed
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 )
@@ -14,6 +16,23 @@ func SendNotModifiedIfETagIsValid(res http.ResponseWriter, req *http.Request, et
 	return false, nil
 }
 
+//QueryETag The etag for a query of a list.  The list's etag changes with any
+//item in it, the query narrows it down, so each query has its own etag.  The
+//format and columns it is exported with don't change what is listed.
+func QueryETag(etag string, req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("format")
+	query.Del("columns")
+
+	if len(query) == 0 {
+		return etag
+	}
+
+	h := sha1.New()
+	h.Write([]byte(etag + "?" + query.Encode()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 //SendJsonWithETag Send json response with etag
 func SendJsonWithETag(res http.ResponseWriter, entity interface{}, etag string) error {
 	res.Header().Set("ETag", etag)
@@ -37,6 +56,18 @@ func SendJson(res http.ResponseWriter, entity interface{}) error {
 	return nil
 }
 
+//sendConflict Send json response describing why the request conflicts with the current state
+func sendConflict(res http.ResponseWriter, entity interface{}) error {
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	jsonResponse(res)
+	res.WriteHeader(http.StatusConflict)
+	res.Write(b)
+	return nil
+}
+
 //SendSuccess Send success object
 func SendSuccess(res http.ResponseWriter) error {
 	b, err := json.Marshal(map[string]interface{}{"success": 1})
@@ -0,0 +1,124 @@
+package feed
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/database"
+)
+
+//parseResultFilter reads the optional bracket, from, to, sex, category, club,
+//bib and name querystring parameters.  Dates are given as 2006-01-02 and the
+//category is the name, or another name, of an age category.
+func (r *FeedResource) parseResultFilter(req *http.Request) (database.ResultFilter, error) {
+	var filter database.ResultFilter
+	var err error
+
+	query := req.URL.Query()
+
+	if bracket := query.Get("bracket"); len(bracket) > 0 {
+		if filter.Bracket, err = database.ParseBracket(bracket); err != nil {
+			return filter, err
+		}
+	}
+
+	if from := query.Get("from"); len(from) > 0 {
+		if filter.From, err = time.Parse("2006-01-02", from); err != nil {
+			return filter, ErrBadRequest
+		}
+	}
+
+	if to := query.Get("to"); len(to) > 0 {
+		if filter.To, err = time.Parse("2006-01-02", to); err != nil {
+			return filter, ErrBadRequest
+		}
+	}
+
+	if category := strings.TrimSpace(query.Get("category")); len(category) > 0 {
+		ageCategory, err := r.Db.FindAgeCategory(category)
+		if database.KindOf(err) == database.KindNotFound {
+			return filter, ErrBadRequest
+		}
+		if err != nil {
+			return filter, err
+		}
+		filter.AgeCategoryID = ageCategory.ID
+	}
+
+	filter.Sex = strings.TrimSpace(query.Get("sex"))
+	filter.Club = strings.TrimSpace(query.Get("club"))
+	filter.Bib = strings.TrimSpace(query.Get("bib"))
+	filter.Name = strings.TrimSpace(query.Get("name"))
+
+	return filter, nil
+}
+
+//parseResultOrder reads the optional rank, sort, offset and limit querystring
+//parameters of the results of a race.  Rank is gun or chip, and sort is
+//position, time, chiptime or category, with a - in front for descending.
+func parseResultOrder(req *http.Request, filter *database.ResultFilter) error {
+	query := req.URL.Query()
+
+	switch rank := query.Get("rank"); rank {
+	case "", database.RankGunTime:
+	case database.RankChipTime:
+		filter.Ranking = rank
+	default:
+		return ErrBadRequest
+	}
+
+	if order := query.Get("sort"); len(order) > 0 {
+		filter.Descending = strings.HasPrefix(order, "-")
+		filter.Sort = strings.TrimPrefix(order, "-")
+		switch filter.Sort {
+		case database.ResultSortPosition, database.ResultSortTime, database.ResultSortChipTime, database.ResultSortCategory:
+		default:
+			return ErrBadRequest
+		}
+	}
+
+	if offset := query.Get("offset"); len(offset) > 0 {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return ErrBadRequest
+		}
+		filter.Offset = n
+	}
+
+	if limit := query.Get("limit"); len(limit) > 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return ErrBadRequest
+		}
+		filter.Limit = n
+	}
+
+	return nil
+}
+
+//GetRaceResults Fetch the results in an age bracket across every race, optionally between two dates
+func (r *FeedResource) GetRaceResults(w http.ResponseWriter, req *http.Request) {
+
+	filter, err := r.parseResultFilter(req)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	if len(filter.Bracket) == 0 {
+		HandleError(ErrBadRequest, w)
+		return
+	}
+
+	rr, racers, races, err := r.Db.GetRaceResults(filter)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, FormatRaceResultsForFeed(req, rr, racers, races))
+}
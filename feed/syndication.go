@@ -0,0 +1,385 @@
+package feed
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefwhitecloud/running-man/api"
+	"github.com/chiefwhitecloud/running-man/database"
+	"github.com/gorilla/mux"
+)
+
+//syndicationLimit is the number of the newest entries in a feed
+const syndicationLimit = 20
+
+var syndicationContentTypes = map[string]string{
+	"atom": "application/atom+xml; charset=utf-8",
+	"rss":  "application/rss+xml; charset=utf-8",
+}
+
+//syndication is a feed of races or results, sent as atom or rss
+type syndication struct {
+	Title    string
+	SelfPath string
+	Link     string
+	Entries  []syndicationEntry
+}
+
+type syndicationEntry struct {
+	Title       string
+	ID          string
+	Link        string
+	ResultsPath string
+	Summary     string
+	Published   time.Time
+	Updated     time.Time
+}
+
+//RacesSyndication The newest imported races as an atom or rss feed
+func (r *FeedResource) RacesSyndication(w http.ResponseWriter, req *http.Request) {
+
+	races, err := r.Db.GetRaces()
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	r.sendRacesSyndication(w, req, "Races", fmt.Sprintf("http://%s/feed/races", req.Host), "", races)
+}
+
+//RaceGroupSyndication The newest imported races of the race group as an atom or rss feed
+func (r *FeedResource) RaceGroupSyndication(w http.ResponseWriter, req *http.Request) {
+
+	raceGroup := r.getRaceGroupOrSendError(w, req)
+
+	if raceGroup == nil {
+		return
+	}
+
+	races, err := r.Db.GetRacesForRaceGroup(raceGroup.ID)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	r.sendRacesSyndication(w, req, raceGroup.Name+" Races", FormatRaceGroupForFeed(req, *raceGroup).SelfPath, raceGroup.ETag, races)
+}
+
+//sendRacesSyndication sends the newest imported of the races, each with its
+//winners.  The etag changes with the races in the feed.
+func (r *FeedResource) sendRacesSyndication(w http.ResponseWriter, req *http.Request, title string, link string, etag string, races []database.Race) {
+
+	races = newestImportedRaces(races)
+
+	h := sha1.New()
+	h.Write([]byte(etag))
+	for i := range races {
+		h.Write([]byte(fmt.Sprintf("%d:%s,", races[i].ID, races[i].ETag)))
+	}
+	etag = hex.EncodeToString(h.Sum(nil))
+
+	ok, err := SendNotModifiedIfETagIsValid(w, req, etag)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	if ok {
+		return
+	}
+
+	raceIDs := make([]int, len(races))
+	for i := range races {
+		raceIDs[i] = races[i].ID
+	}
+
+	winners, err := r.Db.GetRaceWinners(raceIDs)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	sendSyndication(w, req, FormatRacesForSyndication(req, title, link, races, winners), etag)
+}
+
+//RacerSyndication The results of the racer, newest race first, as an atom or rss feed
+func (r *FeedResource) RacerSyndication(w http.ResponseWriter, req *http.Request) {
+
+	racer := r.GetRacerOrSendError(w, req)
+
+	if racer == nil {
+		return
+	}
+
+	ok, err := SendNotModifiedIfETagIsValid(w, req, racer.ETag)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	if ok {
+		return
+	}
+
+	names, err := r.Db.GetRacerNames(racer.ID)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	rr, _, races, err := r.Db.GetRaceResultsForRacer(uint(racer.ID), database.ResultFilter{})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	name := fmt.Sprintf("Racer %d", racer.ID)
+	if len(names) > 0 {
+		name = names[0]
+	}
+
+	sendSyndication(w, req, FormatRacerResultsForSyndication(req, *racer, name, rr, races), racer.ETag)
+}
+
+//newestImportedRaces are the races that have been imported, the most recently imported first
+func newestImportedRaces(races []database.Race) []database.Race {
+	imported := []database.Race{}
+	for i := range races {
+		if races[i].Imported != nil {
+			imported = append(imported, races[i])
+		}
+	}
+
+	sort.SliceStable(imported, func(i, j int) bool {
+		if !imported[i].Imported.Equal(*imported[j].Imported) {
+			return imported[i].Imported.After(*imported[j].Imported)
+		}
+		return imported[i].ID > imported[j].ID
+	})
+
+	if len(imported) > syndicationLimit {
+		imported = imported[:syndicationLimit]
+	}
+	return imported
+}
+
+//FormatRacesForSyndication The races as feed entries linking to the race and
+//its results, summarized by the winners
+func FormatRacesForSyndication(req *http.Request, title string, link string, races []database.Race, winners []database.RaceResult) syndication {
+
+	raceWinners := map[int][]database.RaceResult{}
+	for i := range winners {
+		raceWinners[winners[i].RaceID] = append(raceWinners[winners[i].RaceID], winners[i])
+	}
+
+	feed := syndication{Title: title, SelfPath: syndicationSelfPath(req), Link: link}
+
+	for i := range races {
+		race := FormatRaceForFeed(req, races[i])
+		feed.Entries = append(feed.Entries, syndicationEntry{
+			Title:       fmt.Sprintf("%s, %s", race.Name, race.Date),
+			ID:          race.SelfPath,
+			Link:        race.SelfPath,
+			ResultsPath: race.ResultsPath,
+			Summary:     summarizeWinners(raceWinners[races[i].ID]),
+			Published:   *races[i].Imported,
+			Updated:     races[i].LastUpdated,
+		})
+	}
+
+	return feed
+}
+
+//summarizeWinners names the winner of the race and the first of each sex
+func summarizeWinners(winners []database.RaceResult) string {
+	var parts []string
+
+	for i := range winners {
+		if winners[i].Position == 1 {
+			parts = append(parts, fmt.Sprintf("Won by %s in %s.", winners[i].Name, strings.TrimSpace(winners[i].Time)))
+		}
+	}
+
+	for i := range winners {
+		if winners[i].SexPosition != 1 {
+			continue
+		}
+
+		first := "First in " + winners[i].Sex
+		switch strings.ToUpper(winners[i].Sex) {
+		case "F":
+			first = "First woman"
+		case "M":
+			first = "First man"
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s in %s.", first, winners[i].Name, strings.TrimSpace(winners[i].Time)))
+	}
+
+	if len(parts) == 0 {
+		return "No results."
+	}
+	return strings.Join(parts, " ")
+}
+
+//FormatRacerResultsForSyndication The racer's results as feed entries linking
+//to the race and its results
+func FormatRacerResultsForSyndication(req *http.Request, racer database.Racer, name string, results []database.RaceResult, races []database.Race) syndication {
+
+	racerFeed := FormatRacerForFeed(req, racer)
+
+	feed := syndication{Title: name + " Results", SelfPath: syndicationSelfPath(req), Link: racerFeed.ResultsPath}
+
+	entries := []syndicationEntry{}
+	for i := range results {
+		race := FormatRaceForFeed(req, races[i])
+
+		published := races[i].Date
+		if races[i].Imported != nil {
+			published = *races[i].Imported
+		}
+
+		summary := fmt.Sprintf("%s in %s", ordinal(results[i].Position), strings.TrimSpace(results[i].Time))
+		if results[i].SexPosition > 0 {
+			summary += fmt.Sprintf(", %s of the %s", ordinal(results[i].SexPosition), results[i].Sex)
+		}
+		if results[i].AgeCategoryPosition > 0 && len(results[i].AgeCategory.Name) > 0 {
+			summary += fmt.Sprintf(", %s in %s", ordinal(results[i].AgeCategoryPosition), results[i].AgeCategory.Name)
+		}
+
+		entries = append(entries, syndicationEntry{
+			Title:       fmt.Sprintf("%s was %s in %s", name, ordinal(results[i].Position), race.Name),
+			ID:          fmt.Sprintf("%s#result-%d", racerFeed.ResultsPath, results[i].ID),
+			Link:        race.SelfPath,
+			ResultsPath: race.ResultsPath,
+			Summary:     summary + ".",
+			Published:   published,
+			Updated:     published,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Published.After(entries[j].Published) })
+
+	if len(entries) > syndicationLimit {
+		entries = entries[:syndicationLimit]
+	}
+	feed.Entries = entries
+
+	return feed
+}
+
+func syndicationSelfPath(req *http.Request) string {
+	return fmt.Sprintf("http://%s%s", req.Host, req.URL.Path)
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+//updated is when the newest entry was last updated
+func (s syndication) updated() time.Time {
+	var updated time.Time
+	for i := range s.Entries {
+		if s.Entries[i].Updated.After(updated) {
+			updated = s.Entries[i].Updated
+		}
+	}
+	return updated
+}
+
+func (s syndication) atom() api.AtomFeed {
+	feed := api.AtomFeed{
+		Title:   s.Title,
+		Id:      s.SelfPath,
+		Links:   []api.AtomLink{{Rel: "self", Href: s.SelfPath}, {Rel: "alternate", Href: s.Link, Type: "application/json"}},
+		Updated: s.updated().UTC().Format(time.RFC3339),
+		Author:  api.AtomAuthor{Name: "Running Man"},
+	}
+
+	for _, entry := range s.Entries {
+		feed.Entries = append(feed.Entries, api.AtomEntry{
+			Title: entry.Title,
+			Id:    entry.ID,
+			Links: []api.AtomLink{
+				{Rel: "alternate", Href: entry.Link, Type: "application/json"},
+				{Rel: "related", Href: entry.ResultsPath, Type: "application/json", Title: "Results"},
+			},
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Updated.UTC().Format(time.RFC3339),
+			Summary:   entry.Summary,
+		})
+	}
+
+	return feed
+}
+
+func (s syndication) rss() api.RssFeed {
+	channel := api.RssChannel{
+		Title:       s.Title,
+		Link:        s.Link,
+		Description: s.Title,
+	}
+
+	if updated := s.updated(); !updated.IsZero() {
+		channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, entry := range s.Entries {
+		channel.Items = append(channel.Items, api.RssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Guid:        api.RssGuid{IsPermaLink: entry.ID == entry.Link, Value: entry.ID},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Description: entry.Summary + " Results: " + entry.ResultsPath,
+		})
+	}
+
+	return api.RssFeed{Version: "2.0", Channel: channel}
+}
+
+//sendSyndication Send the feed as atom or rss, by the format in the path, with the etag
+func sendSyndication(w http.ResponseWriter, req *http.Request, feed syndication, etag string) {
+
+	format := mux.Vars(req)["format"]
+
+	var entity interface{} = feed.atom()
+	if format == "rss" {
+		entity = feed.rss()
+	}
+
+	b, err := xml.Marshal(entity)
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", syndicationContentTypes[format])
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
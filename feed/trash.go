@@ -0,0 +1,93 @@
+package feed
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/chiefwhitecloud/running-man/api"
+	"github.com/chiefwhitecloud/running-man/database"
+	"github.com/gorilla/mux"
+)
+
+//ListTrash Get the races and race groups that have been deleted but not yet purged
+func (r *FeedResource) ListTrash(w http.ResponseWriter, req *http.Request) {
+
+	races, err := r.Db.GetDeletedRaces()
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	raceGroups, err := r.Db.GetDeletedRaceGroups()
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	SendJson(w, api.TrashFeed{
+		Races:      FormatRacesForFeed(req, races).Races,
+		RaceGroups: FormatRaceGroupsForFeed(req, raceGroups).RaceGroups,
+	})
+}
+
+//RestoreRace Take the race out of the trash
+func (r *FeedResource) RestoreRace(w http.ResponseWriter, req *http.Request) {
+
+	raceID, err := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return
+	}
+
+	var race database.Race
+
+	err = r.Db.Transaction(func(tx database.Store) error {
+		var err error
+		if race, err = tx.RestoreRace(raceID); err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "restore", database.AuditRace, race.ID, nil, FormatRaceForFeed(req, race))
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	raceFeed := FormatRaceForFeed(req, race)
+
+	SendJsonWithETag(w, raceFeed, race.ETag)
+}
+
+//RestoreRaceGroup Take the race group out of the trash
+func (r *FeedResource) RestoreRaceGroup(w http.ResponseWriter, req *http.Request) {
+
+	raceGroupID, err := strconv.Atoi(mux.Vars(req)["id"])
+
+	if err != nil {
+		HandleError(ErrNotFound, w)
+		return
+	}
+
+	var raceGroup database.RaceGroup
+
+	err = r.Db.Transaction(func(tx database.Store) error {
+		var err error
+		if raceGroup, err = tx.RestoreRaceGroup(raceGroupID); err != nil {
+			return err
+		}
+		return RecordAudit(tx, req, "restore", database.AuditRaceGroup, raceGroup.ID, nil, FormatRaceGroupForFeed(req, raceGroup))
+	})
+
+	if err != nil {
+		HandleError(err, w)
+		return
+	}
+
+	raceGroupFeed := FormatRaceGroupForFeed(req, raceGroup)
+
+	SendJson(w, raceGroupFeed)
+}
@@ -0,0 +1,113 @@
+package feed
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+//The parts of a workbook with a single sheet, other than the sheet itself
+var xlsxParts = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+//xlsxWriter writes a workbook with one sheet a row at a time, so the rows
+//don't have to be held in memory.  Strings are written inline in the sheet.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	rows  int
+	err   error
+}
+
+func newXlsxWriter(w io.Writer, sheetName string) (*xlsxWriter, error) {
+	x := &xlsxWriter{zip: zip.NewWriter(w)}
+
+	for _, part := range xlsxParts {
+		x.writePart(part.Name, part.Content)
+	}
+
+	x.writePart("xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`+xlsxEscape(sheetName)+`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+
+	if x.err == nil {
+		x.sheet, x.err = x.zip.Create("xl/worksheets/sheet1.xml")
+	}
+
+	x.write(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return x, x.err
+}
+
+func (x *xlsxWriter) writePart(name string, content string) {
+	if x.err != nil {
+		return
+	}
+
+	var part io.Writer
+	if part, x.err = x.zip.Create(name); x.err == nil {
+		_, x.err = io.WriteString(part, content)
+	}
+}
+
+func (x *xlsxWriter) write(s string) {
+	if x.err == nil {
+		_, x.err = io.WriteString(x.sheet, s)
+	}
+}
+
+//WriteRow adds a row to the sheet.  Ints are written as numbers and anything
+//else as a string.
+func (x *xlsxWriter) WriteRow(values []interface{}) error {
+	x.rows++
+	row := strconv.Itoa(x.rows)
+
+	x.write(`<row r="` + row + `">`)
+	for i, value := range values {
+		ref := xlsxColumn(i) + row
+		switch v := value.(type) {
+		case int:
+			x.write(`<c r="` + ref + `"><v>` + strconv.Itoa(v) + `</v></c>`)
+		case string:
+			x.write(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + xlsxEscape(v) + `</t></is></c>`)
+		}
+	}
+	x.write(`</row>`)
+
+	return x.err
+}
+
+//Close finishes the sheet and the workbook
+func (x *xlsxWriter) Close() error {
+	x.write(`</sheetData></worksheet>`)
+	if x.err != nil {
+		return x.err
+	}
+	return x.zip.Close()
+}
+
+//xlsxColumn is the letters of the column with the index: A to Z, then AA and on
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xlsxEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
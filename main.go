@@ -6,6 +6,8 @@ import (
 	"github.com/chiefwhitecloud/running-man/service"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 func main() {
@@ -40,8 +42,49 @@ func main() {
 		}
 	case "migrate-db":
 
-		// Start Server
-		if err := s.MigrateDb(); err != nil {
+		switch flag.Arg(1) {
+		case "", "up":
+			if err := s.MigrateDb(); err != nil {
+				log.Fatal(err)
+			}
+		case "rollback":
+			if err := s.RollbackDb(); err != nil {
+				log.Fatal(err)
+			}
+		case "status":
+			status, err := s.MigrationStatus()
+			if err != nil {
+				log.Fatal(err)
+			}
+			for i := range status {
+				applied := "pending"
+				if status[i].Applied != nil {
+					applied = status[i].Applied.Format(time.RFC3339)
+				}
+				fmt.Printf("%4d  %-40s %s\n", status[i].Version, status[i].Name, applied)
+			}
+		default:
+			flag.Usage()
+			log.Fatalf("Unknown migrate-db command: %s", flag.Arg(1))
+		}
+	case "purge":
+
+		//days items stay in the trash before they are purged
+		days := 30
+		if flag.Arg(1) != "" {
+			days, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || days < 0 {
+				flag.Usage()
+				log.Fatalf("Invalid number of days: %s", flag.Arg(1))
+			}
+		}
+
+		if err := s.PurgeTrash(time.Duration(days) * 24 * time.Hour); err != nil {
+			log.Fatal(err)
+		}
+	case "gc":
+
+		if err := s.CollectGarbage(); err != nil {
 			log.Fatal(err)
 		}
 	default:
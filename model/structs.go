@@ -6,7 +6,9 @@ type Racer struct {
 	BibNumber           string
 	Club                string
 	Time                string
+	TimeMs              int
 	ChipTime            string
+	ChipTimeMs          int
 	Sex                 string
 	SexPosition         int
 	AgeCategory         string
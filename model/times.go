@@ -0,0 +1,53 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidTime = errors.New("Invalid time")
+
+//ParseTime converts a finish time such as 15:45, 1:02:03 or 59:59.9 into
+//milliseconds.  Fractions of a second beyond milliseconds are dropped.
+func ParseTime(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+
+	if len(parts) > 3 || len(parts[0]) == 0 {
+		return 0, ErrInvalidTime
+	}
+
+	seconds := parts[len(parts)-1]
+	fraction := ""
+	if i := strings.Index(seconds, "."); i >= 0 {
+		seconds, fraction = seconds[:i], seconds[i+1:]
+		if len(fraction) == 0 {
+			return 0, ErrInvalidTime
+		}
+	}
+
+	ms := 0
+	for i, part := range append(parts[:len(parts)-1], seconds) {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || len(part) == 0 {
+			return 0, ErrInvalidTime
+		}
+		//minutes and seconds after the leading part never exceed 59
+		if i > 0 && n > 59 {
+			return 0, ErrInvalidTime
+		}
+		ms = ms*60 + n
+	}
+	ms *= 1000
+
+	if len(fraction) > 0 {
+		fraction = (fraction + "00")[:3]
+		n, err := strconv.Atoi(fraction)
+		if err != nil || n < 0 {
+			return 0, ErrInvalidTime
+		}
+		ms += n
+	}
+
+	return ms, nil
+}
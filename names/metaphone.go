@@ -0,0 +1,172 @@
+package names
+
+import (
+	"strings"
+)
+
+//Metaphone returns the phonetic key of a single word using Lawrence Philips'
+//original metaphone rules.  Words that sound alike, like FEWER and FEWAR or
+//STEPHEN and STEVEN, share the same key.
+func Metaphone(word string) string {
+
+	w := []byte(lettersOnly(word))
+
+	if len(w) == 0 {
+		return ""
+	}
+
+	//drop duplicate adjacent letters, except for C
+	deduped := w[:1]
+	for i := 1; i < len(w); i++ {
+		if w[i] != w[i-1] || w[i] == 'C' {
+			deduped = append(deduped, w[i])
+		}
+	}
+	w = deduped
+
+	//initial letter exceptions
+	switch {
+	case hasPrefix(w, "KN"), hasPrefix(w, "GN"), hasPrefix(w, "PN"), hasPrefix(w, "AE"), hasPrefix(w, "WR"):
+		w = w[1:]
+	case w[0] == 'X':
+		w[0] = 'S'
+	case hasPrefix(w, "WH"):
+		w = append([]byte{'W'}, w[2:]...)
+	}
+
+	at := func(i int) byte {
+		if i < 0 || i >= len(w) {
+			return 0
+		}
+		return w[i]
+	}
+
+	var key []byte
+
+	for i := 0; i < len(w); i++ {
+		c := w[i]
+		prev := at(i - 1)
+		next := at(i + 1)
+
+		switch c {
+		case 'A', 'E', 'I', 'O', 'U':
+			if i == 0 {
+				key = append(key, c)
+			}
+		case 'B':
+			//silent in a trailing MB
+			if !(prev == 'M' && i == len(w)-1) {
+				key = append(key, 'B')
+			}
+		case 'C':
+			if prev == 'S' && isFrontVowel(next) {
+				//silent in SCI, SCE, SCY
+			} else if next == 'I' && at(i+2) == 'A' {
+				key = append(key, 'X')
+			} else if next == 'H' {
+				if prev == 'S' {
+					key = append(key, 'K')
+				} else {
+					key = append(key, 'X')
+				}
+				i++
+			} else if isFrontVowel(next) {
+				key = append(key, 'S')
+			} else {
+				key = append(key, 'K')
+			}
+		case 'D':
+			if next == 'G' && isFrontVowel(at(i+2)) {
+				key = append(key, 'J')
+				i++
+			} else {
+				key = append(key, 'T')
+			}
+		case 'G':
+			if next == 'H' && i+2 < len(w) && !isVowel(at(i+2)) {
+				//silent in GH when not at the end or before a vowel
+			} else if next == 'N' && (i+2 == len(w) || (at(i+2) == 'E' && at(i+3) == 'D' && i+4 == len(w))) {
+				//silent in a trailing GN or GNED
+			} else if isFrontVowel(next) && prev != 'G' {
+				key = append(key, 'J')
+			} else {
+				key = append(key, 'K')
+			}
+		case 'H':
+			if isVowel(prev) && !isVowel(next) {
+				//silent after a vowel with no vowel following
+			} else if strings.IndexByte("CSPTG", prev) >= 0 {
+				//part of a digraph handled by the previous letter
+			} else {
+				key = append(key, 'H')
+			}
+		case 'K':
+			if prev != 'C' {
+				key = append(key, 'K')
+			}
+		case 'P':
+			if next == 'H' {
+				key = append(key, 'F')
+				i++
+			} else {
+				key = append(key, 'P')
+			}
+		case 'Q':
+			key = append(key, 'K')
+		case 'S':
+			if next == 'H' {
+				key = append(key, 'X')
+				i++
+			} else if next == 'I' && (at(i+2) == 'O' || at(i+2) == 'A') {
+				key = append(key, 'X')
+			} else {
+				key = append(key, 'S')
+			}
+		case 'T':
+			if next == 'I' && (at(i+2) == 'O' || at(i+2) == 'A') {
+				key = append(key, 'X')
+			} else if next == 'H' {
+				key = append(key, '0')
+				i++
+			} else if !(next == 'C' && at(i+2) == 'H') {
+				key = append(key, 'T')
+			}
+		case 'V':
+			key = append(key, 'F')
+		case 'W', 'Y':
+			if isVowel(next) {
+				key = append(key, c)
+			}
+		case 'X':
+			key = append(key, 'K', 'S')
+		case 'Z':
+			key = append(key, 'S')
+		default:
+			key = append(key, c)
+		}
+	}
+
+	return string(key)
+}
+
+func lettersOnly(word string) string {
+	var b []byte
+	for _, r := range strings.ToUpper(word) {
+		if r >= 'A' && r <= 'Z' {
+			b = append(b, byte(r))
+		}
+	}
+	return string(b)
+}
+
+func hasPrefix(w []byte, prefix string) bool {
+	return strings.HasPrefix(string(w), prefix)
+}
+
+func isVowel(c byte) bool {
+	return c != 0 && strings.IndexByte("AEIOU", c) >= 0
+}
+
+func isFrontVowel(c byte) bool {
+	return c != 0 && strings.IndexByte("EIY", c) >= 0
+}
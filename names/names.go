@@ -0,0 +1,147 @@
+package names
+
+import (
+	"strings"
+)
+
+const (
+	ReasonExact    = "exact"
+	ReasonNickname = "nickname"
+	ReasonPhonetic = "phonetic"
+)
+
+//Equivalence explains why two racer names were considered the same
+type Equivalence struct {
+	FirstName string
+	LastName  string
+}
+
+//Reason summarizes the equivalence using the weakest of the first and last name reasons
+func (e Equivalence) Reason() string {
+	if e.FirstName == ReasonPhonetic || e.LastName == ReasonPhonetic {
+		return ReasonPhonetic
+	}
+	if e.FirstName == ReasonNickname || e.LastName == ReasonNickname {
+		return ReasonNickname
+	}
+	return ReasonExact
+}
+
+//Normalize upper cases the name and collapses the whitespace between its parts
+func Normalize(name string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(name)), " ")
+}
+
+//Split returns the first and last name.  Middle names and initials are ignored.
+func Split(name string) (string, string) {
+	parts := strings.Fields(Normalize(name))
+
+	if len(parts) == 0 {
+		return "", ""
+	} else if len(parts) == 1 {
+		return "", parts[0]
+	}
+
+	return parts[0], parts[len(parts)-1]
+}
+
+//Key returns the phonetic key of the last name.  Equivalent names always share the same key.
+func Key(name string) string {
+	_, last := Split(name)
+	return Metaphone(last)
+}
+
+//Equivalent reports whether the two names could belong to the same racer
+//and, when they could, why
+func Equivalent(a string, b string) (Equivalence, bool) {
+	firstA, lastA := Split(a)
+	firstB, lastB := Split(b)
+
+	last, ok := compareLastNames(lastA, lastB)
+
+	if !ok {
+		return Equivalence{}, false
+	}
+
+	first, ok := compareFirstNames(firstA, firstB)
+
+	if !ok {
+		return Equivalence{}, false
+	}
+
+	return Equivalence{FirstName: first, LastName: last}, true
+}
+
+func compareLastNames(a string, b string) (string, bool) {
+	if a == b {
+		return ReasonExact, true
+	}
+	if soundsAlike(a, b) {
+		return ReasonPhonetic, true
+	}
+	return "", false
+}
+
+func compareFirstNames(a string, b string) (string, bool) {
+	if a == b {
+		return ReasonExact, true
+	}
+	if IsNickname(a, b) {
+		return ReasonNickname, true
+	}
+	if soundsAlike(a, b) {
+		return ReasonPhonetic, true
+	}
+	return "", false
+}
+
+//soundsAlike requires the spellings to be close as well as the keys to be
+//equal so short names like JON and JANE are not confused.  One edit is
+//allowed for every three letters of the shorter name.
+func soundsAlike(a string, b string) bool {
+	keyA := Metaphone(a)
+	if len(keyA) == 0 || keyA != Metaphone(b) {
+		return false
+	}
+
+	allowed := minInt(len(a), len(b)) / 3
+	if allowed < 1 {
+		allowed = 1
+	}
+
+	return Distance(a, b) <= allowed
+}
+
+//Distance returns the Levenshtein edit distance between the two strings
+func Distance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+package names
+
+//nicknameGroups lists first names that are commonly used for the same
+//person.  A name may appear in more than one group, CHRIS for example is
+//short for both CHRISTOPHER and CHRISTINE.
+var nicknameGroups = [][]string{
+	{"ABIGAIL", "ABBY", "ABBIE", "GAIL"},
+	{"ALBERT", "AL", "BERT", "BERTIE"},
+	{"ALEXANDER", "ALEX", "ALEC", "SANDY", "XANDER"},
+	{"ALEXANDRA", "ALEX", "ALEXA", "SANDRA", "SANDY", "LEXIE"},
+	{"ALFRED", "AL", "ALF", "ALFIE", "FRED"},
+	{"ALLAN", "ALAN", "ALLEN", "AL"},
+	{"ANDREW", "ANDY", "DREW"},
+	{"ANGELA", "ANGIE"},
+	{"ANN", "ANNE", "ANNA", "ANNIE", "NAN", "NANCY"},
+	{"ANTHONY", "TONY"},
+	{"ARTHUR", "ART", "ARTIE"},
+	{"BARBARA", "BARB", "BARBIE", "BABS"},
+	{"BENJAMIN", "BEN", "BENNY", "BENJI"},
+	{"BERNARD", "BERNIE", "BERN"},
+	{"BEVERLEY", "BEVERLY", "BEV"},
+	{"BRADLEY", "BRAD"},
+	{"BRIDGET", "BRIDGETTE", "BIDDY", "BRIDIE"},
+	{"CAMERON", "CAM"},
+	{"CAROLINE", "CAROLYN", "CAROL", "CARRIE", "CARLY"},
+	{"CATHERINE", "KATHERINE", "KATHRYN", "CATHY", "KATHY", "KATE", "KATIE", "KAT", "CAT", "KAY", "KIT"},
+	{"CHARLES", "CHARLIE", "CHUCK", "CHAS", "CHAZ"},
+	{"CHRISTINE", "CHRISTINA", "CHRIS", "CHRISSY", "TINA", "KRISTINE", "KRISTINA", "KRIS"},
+	{"CHRISTOPHER", "CHRIS", "KRIS", "TOPHER", "KIT"},
+	{"CLIFFORD", "CLIFF"},
+	{"CONSTANCE", "CONNIE"},
+	{"CORNELIUS", "CONNIE", "NEIL"},
+	{"CYNTHIA", "CINDY"},
+	{"DANIEL", "DAN", "DANNY"},
+	{"DAVID", "DAVE", "DAVEY", "DAVY"},
+	{"DEBORAH", "DEBRA", "DEB", "DEBBIE"},
+	{"DENNIS", "DENNY"},
+	{"DOMINIC", "DOM"},
+	{"DONALD", "DON", "DONNIE", "DONNY"},
+	{"DOROTHY", "DOT", "DOTTIE", "DOLLY"},
+	{"DOUGLAS", "DOUG"},
+	{"EDWARD", "ED", "EDDIE", "EDDY", "NED", "TED", "TEDDY"},
+	{"EDMUND", "ED", "EDDIE", "NED"},
+	{"EDWIN", "ED", "EDDIE"},
+	{"ELEANOR", "ELLIE", "NELL", "NELLIE", "NORA"},
+	{"ELIZABETH", "LIZ", "LIZZIE", "BETH", "BETTY", "BETSY", "ELIZA", "LISA", "LIBBY", "ELSIE"},
+	{"EMILY", "EM", "EMMY", "MILLIE"},
+	{"EUGENE", "GENE"},
+	{"FRANCES", "FRAN", "FRANNIE", "FRANKIE"},
+	{"FRANCIS", "FRANK", "FRANKIE", "FRAN"},
+	{"FRANKLIN", "FRANK"},
+	{"FREDERICK", "FRED", "FREDDIE", "FREDDY", "RICK"},
+	{"GABRIEL", "GABE"},
+	{"GABRIELLE", "GABBY", "GABBIE"},
+	{"GEOFFREY", "JEFFREY", "GEOFF", "JEFF"},
+	{"GERALD", "GERRY", "JERRY"},
+	{"GERARD", "GERRY"},
+	{"GILBERT", "GIL", "BERT"},
+	{"GREGORY", "GREG"},
+	{"HAROLD", "HAL", "HARRY"},
+	{"HENRY", "HANK", "HARRY"},
+	{"HERBERT", "HERB", "BERT"},
+	{"ISAAC", "IKE", "ZAC", "ZACK"},
+	{"JACOB", "JAKE", "JAKOB"},
+	{"JACQUELINE", "JACKIE", "JACKY"},
+	{"JAMES", "JIM", "JIMMY", "JAMIE", "JIMMIE"},
+	{"JANET", "JAN", "JANIE"},
+	{"JENNIFER", "JEN", "JENN", "JENNY", "JENNIE"},
+	{"JEREMY", "JERRY"},
+	{"JESSICA", "JESS", "JESSIE"},
+	{"JOANNE", "JOAN", "JO", "JOANNA"},
+	{"JOHN", "JACK", "JOHNNY", "JON", "JOHNNIE"},
+	{"JONATHAN", "JON", "JONNY", "NATHAN"},
+	{"JOSEPH", "JOE", "JOEY", "JOS"},
+	{"JOSEPHINE", "JO", "JOSIE"},
+	{"JOSHUA", "JOSH"},
+	{"JUDITH", "JUDY", "JUDE"},
+	{"JULIA", "JULIE"},
+	{"KAREN", "KARI"},
+	{"KENNETH", "KEN", "KENNY"},
+	{"KIMBERLEY", "KIMBERLY", "KIM"},
+	{"LAWRENCE", "LAURENCE", "LARRY", "LAURIE"},
+	{"LEONARD", "LEN", "LENNY", "LEO"},
+	{"LOUIS", "LEWIS", "LOU", "LOUIE"},
+	{"LOUISE", "LOU", "LOUISA"},
+	{"MARGARET", "MAGGIE", "MEG", "PEG", "PEGGY", "MARGE", "MARGIE", "GRETA", "RITA"},
+	{"MARIE", "MARY", "MAE", "MOLLY", "POLLY", "MAMIE"},
+	{"MARJORIE", "MARGE", "MARGIE"},
+	{"MARTIN", "MARTY"},
+	{"MATTHEW", "MATT", "MATTY"},
+	{"MAURICE", "MOE", "MO"},
+	{"MELISSA", "MEL", "MISSY", "LISSA"},
+	{"MICHAEL", "MIKE", "MIKEY", "MICK", "MICKEY", "MICHEAL"},
+	{"MICHELLE", "SHELLY", "SHELLEY", "MICHELE"},
+	{"NATHANIEL", "NATHAN", "NATE", "NAT"},
+	{"NICHOLAS", "NICK", "NICKY", "NIC", "NICOLAS"},
+	{"NICOLE", "NIKKI", "NICKY", "COLE"},
+	{"OLIVER", "OLLIE"},
+	{"PAMELA", "PAM"},
+	{"PATRICIA", "PAT", "PATTY", "PATTI", "TRISH", "TRICIA"},
+	{"PATRICK", "PAT", "PADDY", "RICK"},
+	{"PETER", "PETE"},
+	{"PHILIP", "PHILLIP", "PHIL", "PIP"},
+	{"RAYMOND", "RAY"},
+	{"REBECCA", "BECKY", "BECCA", "BECK"},
+	{"RICHARD", "RICK", "RICKY", "RICH", "RICHIE", "DICK"},
+	{"ROBERT", "ROB", "ROBBIE", "BOB", "BOBBY", "BERT"},
+	{"ROBERTA", "BOBBIE", "ROBBIE"},
+	{"RODNEY", "ROD"},
+	{"ROGER", "RODGE"},
+	{"RONALD", "RON", "RONNIE", "RONNY"},
+	{"ROSEMARY", "ROSE", "ROSIE"},
+	{"SAMANTHA", "SAM", "SAMMY", "SAMMIE"},
+	{"SAMUEL", "SAM", "SAMMY"},
+	{"SANDRA", "SANDY", "SANDI"},
+	{"SARAH", "SARA", "SALLY", "SADIE"},
+	{"STANLEY", "STAN"},
+	{"STEPHANIE", "STEPH", "STEFANIE", "STEPHIE"},
+	{"STEPHEN", "STEVEN", "STEVE", "STEVIE", "STEFAN"},
+	{"SUSAN", "SUE", "SUSIE", "SUZY", "SUZANNE"},
+	{"TERENCE", "TERRENCE", "TERRY"},
+	{"THEODORE", "TED", "TEDDY", "THEO"},
+	{"THERESA", "TERESA", "TERRY", "TESS", "TESSA", "TERRI"},
+	{"THOMAS", "TOM", "TOMMY"},
+	{"TIMOTHY", "TIM", "TIMMY"},
+	{"VALERIE", "VAL"},
+	{"VICTORIA", "VICKY", "VICKIE", "TORI"},
+	{"VINCENT", "VINCE", "VINNY"},
+	{"WALTER", "WALT", "WALLY"},
+	{"WILLIAM", "BILL", "BILLY", "WILL", "WILLIE", "LIAM"},
+	{"ZACHARY", "ZACH", "ZACK", "ZAC"},
+}
+
+var nicknameIndex = buildNicknameIndex(nicknameGroups)
+
+func buildNicknameIndex(groups [][]string) map[string][]int {
+	index := map[string][]int{}
+	for i := range groups {
+		for _, name := range groups[i] {
+			if !containsInt(index[name], i) {
+				index[name] = append(index[name], i)
+			}
+		}
+	}
+	return index
+}
+
+//IsNickname reports whether the two first names are listed as nicknames of
+//each other in the bundled dictionary
+func IsNickname(a string, b string) bool {
+	for _, i := range nicknameIndex[a] {
+		if containsInt(nicknameIndex[b], i) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for i := range values {
+		if values[i] == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,11 @@
 package service
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/chiefwhitecloud/running-man/api"
 	"github.com/chiefwhitecloud/running-man/data-import"
@@ -17,8 +19,10 @@ import (
 var _ = log.Printf
 
 type RunningManService struct {
-	Bind        string
-	Db          database.Db
+	Bind string
+	Db   database.Db
+	//Store is what the handlers read and write.  It defaults to Db.
+	Store       database.Store
 	RaceFetcher dataimport.RaceFetcher
 }
 
@@ -30,35 +34,160 @@ func NewRunningManService(bind string, dbStr string) (*RunningManService, error)
 		RaceFetcher: &fetcher.RaceFetcher{},
 	}
 
-	s.Db.Open()
+	if err := s.Db.Open(); err != nil {
+		return nil, err
+	}
 
 	return &s, nil
 }
 
 func (s *RunningManService) MigrateDb() error {
-	s.Db.Migrate()
+	applied, err := s.Db.Migrate()
+	for i := range applied {
+		log.Printf("applied migration %d %s", applied[i].ID, applied[i].Name)
+	}
+	return err
+}
+
+func (s *RunningManService) RollbackDb() error {
+	migration, err := s.Db.Rollback()
+	if err != nil {
+		return err
+	}
+	log.Printf("rolled back migration %d %s", migration.ID, migration.Name)
 	return nil
 }
 
-func (s *RunningManService) Create() error {
-	s.Db.Create()
+func (s *RunningManService) MigrationStatus() ([]database.MigrationStatus, error) {
+	return s.Db.MigrationStatus()
+}
+
+//CollectGarbage removes the racers left without any results, recording each
+//one in the audit log
+func (s *RunningManService) CollectGarbage() error {
+	var removed []int
+
+	err := s.store().Transaction(func(tx database.Store) error {
+		var err error
+		if removed, err = tx.DeleteOrphanRacers(); err != nil {
+			return err
+		}
+
+		for _, id := range removed {
+			if err := recordAudit(tx, "gc", database.AuditRacer, id, map[string]int{"id": id}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("removed %d racers without results", len(removed))
 	return nil
 }
 
-func (s *RunningManService) DropAllTables() error {
-	s.Db.DropAllTables()
+//PurgeTrash permanently removes the races and race groups that have been in
+//the trash for longer than the retention period, recording each one in the
+//audit log
+func (s *RunningManService) PurgeTrash(retention time.Duration) error {
+	before := time.Now().Add(-retention)
+
+	var races []database.Race
+	var raceGroups []database.RaceGroup
+
+	err := s.store().Transaction(func(tx database.Store) error {
+		var err error
+		if races, err = tx.PurgeDeletedRaces(before); err != nil {
+			return err
+		}
+
+		for _, race := range races {
+			if err := recordAudit(tx, "purge", database.AuditRace, race.ID, race); err != nil {
+				return err
+			}
+		}
+
+		if raceGroups, err = tx.PurgeDeletedRaceGroups(before); err != nil {
+			return err
+		}
+
+		for _, raceGroup := range raceGroups {
+			if err := recordAudit(tx, "purge", database.AuditRaceGroup, raceGroup.ID, raceGroup); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("purged %d races and %d race groups deleted before %s", len(races), len(raceGroups), before.Format(time.RFC3339))
 	return nil
 }
 
+//recordAudit records a change made by one of the commands, with the json of
+//the entity it removed
+func recordAudit(tx database.Store, action string, entity string, entityID int, before interface{}) error {
+	b, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.RecordAudit(database.AuditEntry{
+		Actor:    "running-man " + action,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Before:   string(b),
+	})
+	return err
+}
+
+func (s *RunningManService) Create() error {
+	return s.Db.Create()
+}
+
+func (s *RunningManService) DropAllTables() error {
+	return s.Db.DropAllTables()
+}
+
 func (s *RunningManService) Run() error {
+	//build the racer name index up front so the first suggestions are quick
+	if s.Store == nil {
+		if err := s.Db.BuildRacerNameIndex(); err != nil {
+			log.Printf("the racer name index will be built when first needed: %s", err)
+		}
+	}
+
+	// Start HTTP Server
+	return http.ListenAndServe(":"+s.Bind, s.Handler())
+}
+
+//store is what the handlers and commands read and write
+func (s *RunningManService) store() database.Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return &s.Db
+}
+
+//Handler routes the requests to the importer, the feeds and the ui
+func (s *RunningManService) Handler() http.Handler {
+
+	store := s.store()
 
 	importer := &dataimport.DataImportResource{
-		Db:          s.Db,
+		Db:          store,
 		RaceFetcher: s.RaceFetcher,
 	}
 
 	feeds := &feed.FeedResource{
-		Db: s.Db,
+		Db: store,
 	}
 
 	cwd, _ := os.Getwd()
@@ -72,6 +201,7 @@ func (s *RunningManService) Run() error {
 
 	r.HandleFunc("/import", importer.DoImport).Methods("POST")
 	r.HandleFunc("/import/task/{id}", importer.CheckImportStatus).Methods("GET")
+	r.HandleFunc("/audit", feeds.ListAudit).Methods("GET")
 
 	var feedRouter = r.PathPrefix("/feed/").Subrouter()
 	feedRouter.HandleFunc("/racegroup", feeds.CreateRaceGroup).Methods("POST")
@@ -81,19 +211,42 @@ func (s *RunningManService) Run() error {
 	feedRouter.HandleFunc("/racegroup/{id}", feeds.GetRaceGroup).Methods("GET")
 	feedRouter.HandleFunc("/racegroup/{id}/races", feeds.AddRaceToRaceGroup).Methods("POST")
 	feedRouter.HandleFunc("/racegroup/{id}/races", feeds.GetRacesForRaceGroup).Methods("GET")
+	feedRouter.HandleFunc("/racegroup/{id}/restore", feeds.RestoreRaceGroup).Methods("POST")
+	feedRouter.HandleFunc("/racegroup/{id}/races.{format:atom|rss}", feeds.RaceGroupSyndication).Methods("GET")
 	feedRouter.HandleFunc("/races", feeds.ListRaces).Methods("GET")
+	feedRouter.HandleFunc("/races.{format:atom|rss}", feeds.RacesSyndication).Methods("GET")
+	feedRouter.HandleFunc("/results", feeds.GetRaceResults).Methods("GET")
 	feedRouter.HandleFunc("/race/{id}", feeds.GetRace).Methods("GET")
 	feedRouter.HandleFunc("/race/{id}", feeds.DeleteRace).Methods("DELETE")
 	feedRouter.HandleFunc("/race/{id}/results", feeds.GetRaceResultsForRace).Methods("GET")
+	feedRouter.HandleFunc("/race/{id}/restore", feeds.RestoreRace).Methods("POST")
+	feedRouter.HandleFunc("/trash", feeds.ListTrash).Methods("GET")
+	feedRouter.HandleFunc("/racers", feeds.ListRacers).Methods("GET")
+	feedRouter.HandleFunc("/racers/search", feeds.SearchRacers).Methods("GET")
+	feedRouter.HandleFunc("/racers/suggest", feeds.SuggestRacers).Methods("GET")
+	feedRouter.HandleFunc("/racers/duplicates", feeds.ListDuplicateRacers).Methods("GET")
+	feedRouter.HandleFunc("/racers/duplicates/{id}/{duplicateId}/accept", feeds.AcceptDuplicateRacers).Methods("POST")
 	feedRouter.HandleFunc("/racer/{id}", feeds.GetRacer).Methods("GET")
 	feedRouter.HandleFunc("/racer/{id}/results", feeds.GetRaceResultsForRacer).Methods("GET")
+	feedRouter.HandleFunc("/racer/{id}/results.{format:atom|rss}", feeds.RacerSyndication).Methods("GET")
 	feedRouter.HandleFunc("/racer/{id}/profile", feeds.GetRacerProfile).Methods("GET")
+	feedRouter.HandleFunc("/racer/{id}/merge", feeds.PreviewMergeRacer).Methods("GET")
 	feedRouter.HandleFunc("/racer/{id}/merge", feeds.MergeRacer).Methods("POST")
+	feedRouter.HandleFunc("/racer/{id}/merges", feeds.GetRacerMerges).Methods("GET")
+	feedRouter.HandleFunc("/racer/{id}/merge/{mergeId}/undo", feeds.UnmergeRacer).Methods("POST")
+	feedRouter.HandleFunc("/racer/{id}/split", feeds.SplitRacer).Methods("POST")
+	feedRouter.HandleFunc("/racer/{id}/aliases", feeds.GetRacerAliases).Methods("GET")
+	feedRouter.HandleFunc("/racer/{id}/aliases", feeds.CreateRacerAlias).Methods("POST")
+	feedRouter.HandleFunc("/racer/{id}/alias/{aliasId}", feeds.DeleteRacerAlias).Methods("DELETE")
+	feedRouter.HandleFunc("/agecategories", feeds.ListAgeCategories).Methods("GET")
+	feedRouter.HandleFunc("/agecategories", feeds.CreateAgeCategory).Methods("POST")
+	feedRouter.HandleFunc("/agecategory/{id}", feeds.GetAgeCategory).Methods("GET")
+	feedRouter.HandleFunc("/agecategory/{id}", feeds.UpdateAgeCategory).Methods("PUT")
+	feedRouter.HandleFunc("/agecategory/{id}", feeds.DeleteAgeCategory).Methods("DELETE")
+	feedRouter.HandleFunc("/agecategory/{id}/aliases", feeds.CreateAgeCategoryAlias).Methods("POST")
+	feedRouter.HandleFunc("/agecategory/{id}/alias/{aliasId}", feeds.DeleteAgeCategoryAlias).Methods("DELETE")
 
 	r.PathPrefix("/").Handler(ui)
 
-	http.Handle("/", r)
-
-	// Start HTTP Server
-	return http.ListenAndServe(":"+s.Bind, nil)
+	return r
 }
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"fmt"
+
+	"github.com/chiefwhitecloud/running-man/data-import"
+	"github.com/chiefwhitecloud/running-man/model"
+	. "gopkg.in/check.v1"
+)
+
+var telyFixtures = []string{
+	"http://www.nlaa.ca/02-Tely.html",
+	"http://www.nlaa.ca/05-Tely.html",
+	"http://www.nlaa.ca/06-Tely.html",
+}
+
+// Imports the Tely fixtures one after the other, so later races are matched
+// against the racers saved by the earlier ones.
+//
+//   go test ./tests/ -check.b -check.f BenchmarkImportTely
+func (s *TestSuite) BenchmarkImportTely(c *C) {
+
+	s.requireSQL(c)
+
+	importer := &dataimport.DataImportResource{
+		Db:          &s.s.Db,
+		RaceFetcher: &RaceFetcherStub{},
+	}
+
+	for i := 0; i < c.N; i++ {
+		c.StopTimer()
+		s.s.DropAllTables()
+		_, err := s.s.Db.Migrate()
+		c.Assert(err, IsNil)
+		c.StartTimer()
+
+		for _, url := range telyFixtures {
+			task, err := s.s.Db.CreateImportTask(url)
+			c.Assert(err, IsNil)
+
+			importer.ImportResults(task)
+
+			task, err = s.s.Db.GetImportTask(task.ID)
+			c.Assert(err, IsNil)
+			c.Assert(task.Status, Equals, "completed")
+		}
+	}
+}
+
+// generatedRaceSize is the number of runners in a generated race, many more
+// than any of the fixtures
+const generatedRaceSize = 5000
+
+// Saves a generated race of thousands of new racers, then the same runners a
+// year later, matched against the racers saved by the first.
+//
+//   go test ./tests/ -check.b -check.f BenchmarkImportGeneratedRace
+func (s *TestSuite) BenchmarkImportGeneratedRace(c *C) {
+
+	s.requireSQL(c)
+
+	for i := 0; i < c.N; i++ {
+		c.StopTimer()
+		s.s.DropAllTables()
+		_, err := s.s.Db.Migrate()
+		c.Assert(err, IsNil)
+		c.StartTimer()
+
+		for year := 2014; year <= 2015; year++ {
+			task, err := s.s.Db.CreateImportTask(fmt.Sprintf("http://www.nlaa.ca/generated-%d.html", year))
+			c.Assert(err, IsNil)
+
+			_, err = s.s.Db.SaveRace(task, generatedRace(year, generatedRaceSize))
+			c.Assert(err, IsNil)
+		}
+	}
+}
+
+// generatedRace is a race with runners of distinct names, all in the 30-34
+// age category
+func generatedRace(year int, size int) *model.RaceDetails {
+	syllables := []string{"BAR", "KEL", "MOR", "TAN", "WIL", "DON", "FER", "GAL", "HUN", "LIS"}
+	firstNames := []string{"ALEX", "JORDAN", "SAM", "CHRIS", "TAYLOR", "MORGAN", "CASEY", "JAMIE", "RILEY", "DREW"}
+
+	race := &model.RaceDetails{Name: "Generated Road Race", Year: year, Month: 6, Day: 1}
+
+	for i := 0; i < size; i++ {
+		last := syllables[i%10] + syllables[(i/10)%10] + syllables[(i/100)%10]
+		sex := "M"
+		if i%2 == 1 {
+			sex = "F"
+		}
+		timeMs := 15*60*1000 + i*1000
+
+		race.Racers = append(race.Racers, model.Racer{
+			Position:    i + 1,
+			Name:        firstNames[(i/1000)%10] + " " + last,
+			BibNumber:   fmt.Sprint(i + 1),
+			Time:        fmt.Sprintf("%d:%02d", timeMs/60000, timeMs/1000%60),
+			TimeMs:      timeMs,
+			Sex:         sex,
+			SexPosition: i/2 + 1,
+			AgeCategory: "30-34",
+		})
+	}
+
+	return race
+}
@@ -0,0 +1,35 @@
+package test
+
+import (
+	"net/http/httptest"
+
+	"github.com/chiefwhitecloud/running-man/database"
+	"github.com/chiefwhitecloud/running-man/service"
+	. "gopkg.in/check.v1"
+)
+
+//MemoryStoreSuite runs the service tests against the in memory store, each
+//test with a store of its own.  Tests of what only the sql database does are
+//skipped.
+type MemoryStoreSuite struct {
+	TestSuite
+	server *httptest.Server
+}
+
+var _ = Suite(&MemoryStoreSuite{})
+
+func (s *MemoryStoreSuite) SetUpSuite(c *C) {
+}
+
+func (s *MemoryStoreSuite) SetUpTest(c *C) {
+	s.s = service.RunningManService{
+		Store:       database.NewMemoryStore(),
+		RaceFetcher: &RaceFetcherStub{},
+	}
+	s.server = httptest.NewServer(s.s.Handler())
+	s.host = s.server.URL
+}
+
+func (s *MemoryStoreSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
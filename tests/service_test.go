@@ -1,11 +1,23 @@
 package test
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/chiefwhitecloud/running-man/api"
@@ -27,19 +39,32 @@ var _ = Suite(&TestSuite{})
 
 func (s *TestSuite) SetUpSuite(c *C) {
 
+	//without a DATABASE_URL the suite runs against a throwaway sqlite file
+	connectionString := os.Getenv("DATABASE_URL")
+	if connectionString == "" {
+		connectionString = "sqlite3://" + filepath.Join(c.MkDir(), "running-man-test.db")
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8089"
+	}
+
 	server := service.RunningManService{
-		Db:          database.Db{ConnectionString: os.Getenv("DATABASE_URL")},
-		Bind:        os.Getenv("PORT"),
+		Db:          database.Db{ConnectionString: connectionString},
+		Bind:        port,
 		RaceFetcher: &RaceFetcherStub{},
 	}
 
 	s.s = server
 
-	s.s.Db.Open()
+	err := s.s.Db.Open()
+	c.Assert(err, IsNil)
 
 	go s.s.Run()
 
-	s.host = "http://localhost:" + os.Getenv("PORT")
+	s.host = "http://localhost:" + port
+	waitForServer(c, s.host)
 }
 
 func (s *TestSuite) SetUpTest(c *C) {
@@ -50,6 +75,14 @@ func (s *TestSuite) TearDownTest(c *C) {
 	s.s.DropAllTables()
 }
 
+//sqlDB is a connection of its own to the suite's database, for changing it
+//behind the service's back
+func (s *TestSuite) sqlDB(c *C) *sql.DB {
+	db, err := sql.Open(database.ParseConnectionString(s.s.Db.ConnectionString))
+	c.Assert(err, IsNil)
+	return db
+}
+
 // Simple import
 func (s *TestSuite) Test01Import(c *C) {
 
@@ -101,6 +134,7 @@ func (s *TestSuite) Test01Import(c *C) {
 	c.Assert(raceResults.Results[0].SexPosition, Equals, 1)
 	c.Assert(raceResults.Results[0].AgeCategoryPosition, Equals, 1)
 	c.Assert(raceResults.Results[0].Time, Equals, "15:45")
+	c.Assert(raceResults.Results[0].TimeMs, Equals, 945000)
 	c.Assert(raceResults.Results[0].AgeCategory, Equals, "20-29")
 	c.Assert(raceResults.Results[1].Position, Equals, 2)
 	c.Assert(raceResults.Results[1].Club, Equals, "PGNL")
@@ -236,7 +270,13 @@ func (s *TestSuite) Test02ImportTely(c *C) {
 	c.Assert(raceResults.Results[0].SexPosition, Equals, 1)
 	c.Assert(raceResults.Results[0].BibNumber, Equals, "3662")
 	c.Assert(raceResults.Results[0].ChipTime, Equals, "49:25")
+	c.Assert(raceResults.Results[0].ChipTimeMs, Equals, 2965000)
+	c.Assert(raceResults.Results[0].TimeMs, Equals, 2968000)
+	c.Assert(raceResults.Results[32].Time, Equals, "1:00:06")
+	c.Assert(raceResults.Results[32].TimeMs, Equals, 3606000)
+	c.Assert(raceResults.Results[32].ChipTimeMs, Equals, 3601000)
 	c.Assert(raceResults.Results[len(raceResults.Results)-1].ChipTime, Equals, " ")
+	c.Assert(raceResults.Results[len(raceResults.Results)-1].ChipTimeMs, Equals, 0)
 
 }
 
@@ -583,56 +623,1879 @@ func (s *TestSuite) Test11DeleteRace(c *C) {
 
 }
 
-func (s *TestSuite) doImport(path string) (api.Race, error) {
+func (s *TestSuite) Test12RacerAliases(c *C) {
 
-	var race api.Race
+	race, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var raceResults api.RaceResults
+	s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(raceResults.Results[2].Name, Equals, "MATTHEW POWER")
+	matthew := raceResults.Racers[raceResults.Results[2].RacerID]
 
+	//give matthew another name
 	request := gorequest.New()
-	url := api.DataImport{RaceUrl: path}
-	resp, _, _ := request.Post(fmt.Sprintf("%s/import", s.host)).
-		Send(url).
+	resp, body, _ := request.Post(matthew.AliasesPath).
+		Send(api.RacerAliasCreate{Name: "DOMINIC LORO"}).
 		End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	var alias api.RacerAlias
+	json.Unmarshal([]byte(body), &alias)
+	c.Assert(alias.Name, Equals, "DOMINIC LORO")
+	c.Assert(alias.RacerPath, Equals, matthew.SelfPath)
+
+	var aliases api.RacerAliasFeed
+	s.doRequest(matthew.AliasesPath, &aliases)
+	c.Assert(len(aliases.Aliases), Equals, 1)
+
+	var profile api.RacerProfile
+	s.doRequest(matthew.ProfilePath, &profile)
+	c.Assert(profile.Name, Equals, "MATTHEW POWER")
+	c.Assert(profile.NameList, DeepEquals, []string{"MATTHEW POWER", "DOMINIC LORO"})
+
+	//the alias is matched on the next import
+	race, err = s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(raceResults.Results[5].Name, Equals, "DOMINIC LORO")
+	c.Assert(raceResults.Results[5].RacerID, Equals, matthew.Id)
 
-	//initial status code should be 202
-	if resp.StatusCode == 202 {
+	s.doRequest(matthew.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 2)
 
-		//get the location header
-		var taskLocation string
-		taskLocation = resp.Header.Get("Location")
-		var resp gorequest.Response
-		var body string
+	//merging records the merged racers names as aliases
+	s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(raceResults.Results[11].Name, Equals, "ANDREA WHITE")
+	andreaWhite := raceResults.Racers[raceResults.Results[11].RacerID]
+
+	var firstRaceResults api.RaceResults
+	s.doRequest(s.host+"/feed/race/1/results", &firstRaceResults)
+	c.Assert(firstRaceResults.Results[9].Name, Equals, "ANDREA SPARKES")
+	resp, _, _ = request.Post(andreaWhite.MergePath).
+		Send(api.RacerMerge{RacerId: firstRaceResults.Results[9].RacerID}).
+		End()
 
-		//ping the task until it is completed
-		errRetry := retry(5, func() error {
-			resp, body, _ = request.Get(taskLocation).End()
-			if resp.StatusCode == 200 {
-				if resp.Request.URL.String() == taskLocation {
-					return errors.New("Still pending")
-				} else {
-					return nil
-				}
-			} else if resp.StatusCode == 500 {
-				log.Print(body)
-				return errors.New("Import Failed")
-			} else {
-				return errors.New("Unknown status")
-			}
-		})
-		if errRetry != nil {
-			return race, errRetry
+	s.doRequest(andreaWhite.AliasesPath, &aliases)
+	c.Assert(len(aliases.Aliases), Equals, 1)
+	c.Assert(aliases.Aliases[0].Name, Equals, "ANDREA SPARKES")
+
+	//remove the alias
+	deleteRequest := gorequest.New()
+	resp, _, _ = deleteRequest.Delete(aliases.Aliases[0].SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	s.doRequest(andreaWhite.AliasesPath, &aliases)
+	c.Assert(len(aliases.Aliases), Equals, 0)
+}
+
+func (s *TestSuite) Test13SearchRacersByEquivalentName(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var matches api.RacerMatchFeed
+	s.doRequest(s.host+"/feed/racers/search?name=MIKE%20SCOTT", &matches)
+	c.Assert(len(matches.Matches), Equals, 1)
+	c.Assert(matches.Matches[0].Name, Equals, "MICHAEL SCOTT")
+	c.Assert(matches.Matches[0].Reason, Equals, "nickname")
+	c.Assert(matches.Matches[0].FirstNameReason, Equals, "nickname")
+	c.Assert(matches.Matches[0].LastNameReason, Equals, "exact")
+	c.Assert(matches.Matches[0].Racer.SelfPath, Equals, s.host+"/feed/racer/8")
+
+	s.doRequest(s.host+"/feed/racers/search?name=Jordan%20Fewar", &matches)
+	c.Assert(len(matches.Matches), Equals, 1)
+	c.Assert(matches.Matches[0].Name, Equals, "JORDAN FEWER")
+	c.Assert(matches.Matches[0].Reason, Equals, "phonetic")
+
+	s.doRequest(s.host+"/feed/racers/search?name=JOE%20DUNFORD", &matches)
+	c.Assert(len(matches.Matches), Equals, 1)
+	c.Assert(matches.Matches[0].Reason, Equals, "exact")
+
+	s.doRequest(s.host+"/feed/racers/search?name=JASON%20GUY", &matches)
+	c.Assert(len(matches.Matches), Equals, 0)
+
+	request := gorequest.New()
+	resp, _, _ := request.Get(s.host + "/feed/racers/search").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+
+	//the search by name points to the search it is replaced by
+	resp, _, _ = request.Get(s.host + "/feed/racers/search?name=MIKE%20SCOTT").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Header.Get("Deprecation"), Equals, "true")
+	c.Assert(resp.Header.Get("Link"), Equals, "<"+s.host+"/feed/racers?q=MIKE+SCOTT>; rel=\"successor-version\"")
+}
+
+func (s *TestSuite) Test14DuplicateRacers(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	race, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var duplicates api.DuplicateRacersFeed
+	s.doRequest(s.host+"/feed/racers/duplicates", &duplicates)
+	c.Assert(len(duplicates.Duplicates), Equals, 0)
+
+	//andrea sparkes is also known as andrea white
+	var raceResults api.RaceResults
+	s.doRequest(s.host+"/feed/race/1/results", &raceResults)
+	c.Assert(raceResults.Results[9].Name, Equals, "ANDREA SPARKES")
+	andreaSparkes := raceResults.Racers[raceResults.Results[9].RacerID]
+
+	request := gorequest.New()
+	resp, _, _ := request.Post(andreaSparkes.AliasesPath).
+		Send(api.RacerAliasCreate{Name: "ANDREA WHITE"}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 201)
+
+	s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(raceResults.Results[11].Name, Equals, "ANDREA WHITE")
+	andreaWhite := raceResults.Racers[raceResults.Results[11].RacerID]
+
+	s.doRequest(s.host+"/feed/racers/duplicates", &duplicates)
+	c.Assert(len(duplicates.Duplicates), Equals, 1)
+	c.Assert(duplicates.Duplicates[0].Racer.Id, Equals, andreaSparkes.Id)
+	c.Assert(duplicates.Duplicates[0].Duplicate.Id, Equals, andreaWhite.Id)
+	//the same name and closely agreeing birth dates, but no club in common
+	c.Assert(duplicates.Duplicates[0].Score > 0.85, Equals, true)
+	c.Assert(duplicates.Duplicates[0].Score < 0.9, Equals, true)
+	c.Assert(len(duplicates.Duplicates[0].Evidence), Equals, 3)
+
+	request = gorequest.New()
+	resp, _, _ = request.Post(duplicates.Duplicates[0].AcceptPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	s.doRequest(andreaSparkes.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 2)
+
+	s.doRequest(s.host+"/feed/racers/duplicates", &duplicates)
+	c.Assert(len(duplicates.Duplicates), Equals, 0)
+
+	//a racer without an age category can still be a duplicate, on less evidence
+	var saved []database.Race
+	for i, category := range []string{"", "30-34"} {
+		task, err := s.store().CreateImportTask(fmt.Sprintf("http://www.nlaa.ca/ferguson-%d.html", i))
+		c.Assert(err, Equals, nil)
+		ferguson := generatedRace(2013+i, 1)
+		ferguson.Racers[0].Name = "DREW FERGUSON"
+		ferguson.Racers[0].AgeCategory = category
+		race, err := s.store().SaveRace(task, ferguson)
+		c.Assert(err, Equals, nil)
+		saved = append(saved, race)
+	}
+
+	s.doRequest(s.host+"/feed/racers/duplicates", &duplicates)
+	c.Assert(len(duplicates.Duplicates), Equals, 1)
+	c.Assert(duplicates.Duplicates[0].Score < 0.85, Equals, true)
+	c.Assert(duplicates.Duplicates[0].Evidence[1], Equals, "no age category to compare birth dates by")
+
+	//the results of races in the trash are left out
+	resp, _, _ = gorequest.New().Delete(fmt.Sprintf("%s/feed/race/%d", s.host, saved[0].ID)).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	s.doRequest(s.host+"/feed/racers/duplicates", &duplicates)
+	c.Assert(len(duplicates.Duplicates), Equals, 0)
+}
+
+func (s *TestSuite) Test15SafeRacerMerge(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	race, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var firstRaceResults api.RaceResults
+	s.doRequest(s.host+"/feed/race/1/results", &firstRaceResults)
+	jordan := firstRaceResults.Racers[firstRaceResults.Results[0].RacerID]
+	matthew := firstRaceResults.Racers[firstRaceResults.Results[2].RacerID]
+	andreaSparkes := firstRaceResults.Racers[firstRaceResults.Results[9].RacerID]
+
+	var raceResults api.RaceResults
+	s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(raceResults.Results[8].Name, Equals, "PATRICK TARRANT")
+	patrick := raceResults.Racers[raceResults.Results[8].RacerID]
+
+	//jordan and matthew ran the same race
+	var preview api.RacerMergePreview
+	s.doRequest(jordan.MergePath+"?racerId="+matthew.Id, &preview)
+	c.Assert(preview.CanMerge, Equals, false)
+	c.Assert(preview.CanForce, Equals, false)
+	c.Assert(len(preview.Conflicts), Equals, 1)
+	c.Assert(preview.Conflicts[0].Type, Equals, "same-race")
+	c.Assert(preview.Conflicts[0].RacePath, Equals, s.host+"/feed/race/1")
+
+	request := gorequest.New()
+	resp, _, _ := request.Post(jordan.MergePath).
+		Send(api.RacerMerge{RacerId: matthew.Id, Force: true}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 409)
+
+	s.doRequest(jordan.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 2)
+
+	//patrick is too young to be andrea
+	s.doRequest(andreaSparkes.MergePath+"?racerId="+patrick.Id, &preview)
+	c.Assert(preview.CanMerge, Equals, false)
+	c.Assert(preview.CanForce, Equals, true)
+	c.Assert(preview.ResultsMoved, Equals, 1)
+	c.Assert(preview.Conflicts[0].Type, Equals, "birth-dates")
+
+	request = gorequest.New()
+	resp, _, _ = request.Post(andreaSparkes.MergePath).
+		Send(api.RacerMerge{RacerId: patrick.Id}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 409)
+
+	request = gorequest.New()
+	resp, body, _ := request.Post(andreaSparkes.MergePath).
+		Send(api.RacerMerge{RacerId: patrick.Id, Force: true}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	var result api.RacerMergeResult
+	json.Unmarshal([]byte(body), &result)
+	c.Assert(result.Racer.Id, Equals, andreaSparkes.Id)
+	c.Assert(result.MergedRacerId, Equals, patrick.Id)
+	c.Assert(result.ResultsMoved, Equals, 1)
+
+	//the merged racer redirects to the surviving racer
+	var racer api.Racer
+	err = s.doRequest(patrick.SelfPath, &racer)
+	c.Assert(err, Equals, nil)
+	c.Assert(racer.Id, Equals, andreaSparkes.Id)
+
+	s.doRequest(patrick.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 2)
+
+	//unknown racers can't be merged
+	request = gorequest.New()
+	resp, _, _ = request.Post(andreaSparkes.MergePath).
+		Send(api.RacerMerge{RacerId: "999"}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+func (s *TestSuite) Test16UnmergeAndSplitRacer(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	race, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var firstRaceResults api.RaceResults
+	s.doRequest(s.host+"/feed/race/1/results", &firstRaceResults)
+	andreaSparkes := firstRaceResults.Racers[firstRaceResults.Results[9].RacerID]
+	chris := firstRaceResults.Racers[firstRaceResults.Results[4].RacerID]
+
+	var raceResults api.RaceResults
+	s.doRequest(race.ResultsPath, &raceResults)
+	andreaWhite := raceResults.Racers[raceResults.Results[11].RacerID]
+	slowChris := raceResults.Racers[raceResults.Results[10].RacerID]
+
+	request := gorequest.New()
+	resp, _, _ := request.Post(andreaSparkes.MergePath).
+		Send(api.RacerMerge{RacerId: andreaWhite.Id}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	var merges api.RacerMergeHistoryFeed
+	s.doRequest(andreaSparkes.MergesPath, &merges)
+	c.Assert(len(merges.Merges), Equals, 1)
+	c.Assert(merges.Merges[0].MergedRacer.Id, Equals, andreaWhite.Id)
+	c.Assert(merges.Merges[0].UndoPath, Not(Equals), "")
+
+	//undo the merge
+	request = gorequest.New()
+	resp, body, _ := request.Post(merges.Merges[0].UndoPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	var result api.RacerMergeResult
+	json.Unmarshal([]byte(body), &result)
+	c.Assert(result.MergedRacerId, Equals, andreaWhite.Id)
+	c.Assert(result.ResultsMoved, Equals, 1)
+
+	s.doRequest(andreaSparkes.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 1)
+	s.doRequest(andreaWhite.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 1)
+	c.Assert(raceResults.Results[0].RacerID, Equals, andreaWhite.Id)
+
+	var aliases api.RacerAliasFeed
+	s.doRequest(andreaSparkes.AliasesPath, &aliases)
+	c.Assert(len(aliases.Aliases), Equals, 0)
+
+	//a merge can only be undone once
+	s.doRequest(andreaSparkes.MergesPath, &merges)
+	c.Assert(merges.Merges[0].Undone, Not(Equals), "")
+	request = gorequest.New()
+	resp, _, _ = request.Post(s.host + "/feed/racer/" + andreaSparkes.Id + "/merge/" + merges.Merges[0].Id + "/undo").End()
+	c.Assert(resp.StatusCode, Equals, 409)
+
+	//split chris's second race off to a new racer
+	s.doRequest(chris.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 2)
+	secondRaceResultId := raceResults.Results[0].Id
+
+	request = gorequest.New()
+	resp, body, _ = request.Post(chris.SplitPath).
+		Send(api.RacerSplit{ResultIds: []string{secondRaceResultId}}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	json.Unmarshal([]byte(body), &result)
+	c.Assert(result.Racer.Id, Not(Equals), chris.Id)
+
+	s.doRequest(chris.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 1)
+	s.doRequest(result.Racer.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 1)
+
+	//slow chris already has a result in that race
+	request = gorequest.New()
+	resp, _, _ = request.Post(result.Racer.SplitPath).
+		Send(api.RacerSplit{ResultIds: []string{secondRaceResultId}, RacerId: slowChris.Id}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 409)
+
+	//move it back
+	request = gorequest.New()
+	resp, _, _ = request.Post(result.Racer.SplitPath).
+		Send(api.RacerSplit{ResultIds: []string{secondRaceResultId}, RacerId: chris.Id}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	s.doRequest(chris.ResultsPath, &raceResults)
+	c.Assert(len(raceResults.Results), Equals, 2)
+}
+
+// Migrations are recorded, can be re-run safely and rolled back
+func (s *TestSuite) Test17Migrations(c *C) {
+
+	s.requireSQL(c)
+
+	//every migration was applied when the test was set up
+	status, err := s.s.MigrationStatus()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(status) > 0, Equals, true)
+	for i := range status {
+		c.Assert(status[i].Applied, NotNil)
+	}
+
+	//running the migrations again does nothing
+	applied, err := s.s.Db.Migrate()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(applied), Equals, 0)
+
+	//so the age categories are not seeded twice
+	race, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	request := gorequest.New()
+	resp, body, _ := request.Get(race.ResultsPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	var raceResults api.RaceResults
+	err = json.Unmarshal([]byte(body), &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(raceResults.Results[0].AgeCategory, Equals, "20-29")
+
+	//the latest migration can be rolled back and applied again
+	last := status[len(status)-1]
+
+	rolledBack, err := s.s.Db.Rollback()
+	c.Assert(err, Equals, nil)
+	c.Assert(rolledBack.ID, Equals, last.Version)
+
+	status, err = s.s.MigrationStatus()
+	c.Assert(err, Equals, nil)
+	c.Assert(status[len(status)-1].Applied, IsNil)
+	c.Assert(status[len(status)-2].Applied, NotNil)
+
+	applied, err = s.s.Db.Migrate()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(applied), Equals, 1)
+	c.Assert(applied[0].ID, Equals, last.Version)
+
+	//every migration can be rolled back, leaving no tables behind, and applied again
+	for range status {
+		_, err = s.s.Db.Rollback()
+		c.Assert(err, Equals, nil)
+	}
+
+	_, err = s.s.Db.Rollback()
+	c.Assert(err, NotNil)
+
+	applied, err = s.s.Db.Migrate()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(applied), Equals, len(status))
+
+	race, err = s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	resp, body, _ = request.Get(race.ResultsPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	raceResults = api.RaceResults{}
+	err = json.Unmarshal([]byte(body), &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(raceResults.Results[0].AgeCategory, Equals, "20-29")
+
+	//migrating a database from before brackets places the results as an import does
+	second, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var imported, secondImported api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath, &imported), Equals, nil)
+	c.Assert(s.doRequest(second.ResultsPath, &secondImported), Equals, nil)
+	placed := 0
+	for i := range imported.Results {
+		if len(imported.Results[i].Bracket) > 0 {
+			placed++
 		}
+	}
+	c.Assert(placed > 0, Equals, true)
 
-		jsonBlob := []byte(body)
-		err := json.Unmarshal(jsonBlob, &race)
+	for i := len(status); i > 7; i-- {
+		_, err = s.s.Db.Rollback()
+		c.Assert(err, Equals, nil)
+	}
 
-		if err != nil {
-			return race, err
+	applied, err = s.s.Db.Migrate()
+	c.Assert(err, Equals, nil)
+	c.Assert(applied[0].ID, Equals, 8)
+
+	var migrated api.RaceResults
+	for _, results := range []api.RaceResults{imported, secondImported} {
+		c.Assert(s.doRequest(s.host+"/feed/race/"+results.Results[0].RaceID+"/results", &migrated), Equals, nil)
+		c.Assert(len(migrated.Results), Equals, len(results.Results))
+		for i := range results.Results {
+			c.Assert(migrated.Results[i].Bracket, Equals, results.Results[i].Bracket)
+		}
+		for id := range migrated.Racers {
+			racerID, err := strconv.Atoi(id)
+			c.Assert(err, Equals, nil)
+			racer, err := s.s.Db.GetRacer(racerID)
+			c.Assert(err, Equals, nil)
+			c.Assert(racer.ETag, Not(Equals), "")
 		}
+	}
 
-		return race, nil
+	//rolling back the seed keeps the categories that were there before it
+	for i := len(status); i > 1; i-- {
+		_, err = s.s.Db.Rollback()
+		c.Assert(err, Equals, nil)
+	}
+	db := s.sqlDB(c)
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO age_category (name) VALUES ('U20')")
+	c.Assert(err, Equals, nil)
 
-	} else {
-		return race, errors.New("nannan")
+	_, err = s.s.Db.Migrate()
+	c.Assert(err, Equals, nil)
+	for i := len(status); i > 2; i-- {
+		_, err = s.s.Db.Rollback()
+		c.Assert(err, Equals, nil)
+	}
+
+	rolledBack, err = s.s.Db.Rollback()
+	c.Assert(err, Equals, nil)
+	c.Assert(rolledBack.ID, Equals, 2)
+	var names []string
+	rows, err := db.Query("SELECT name FROM age_category")
+	c.Assert(err, Equals, nil)
+	for rows.Next() {
+		var name string
+		c.Assert(rows.Scan(&name), Equals, nil)
+		names = append(names, name)
+	}
+	c.Assert(rows.Close(), Equals, nil)
+	c.Assert(names, DeepEquals, []string{"U20"})
+
+	//a seed that wasn't recorded can't be rolled back
+	_, err = s.s.Db.Migrate()
+	c.Assert(err, Equals, nil)
+	for i := len(status); i > 2; i-- {
+		_, err = s.s.Db.Rollback()
+		c.Assert(err, Equals, nil)
+	}
+	_, err = db.Exec("DROP TABLE age_category_seed")
+	c.Assert(err, Equals, nil)
+	_, err = s.s.Db.Rollback()
+	c.Assert(errors.Is(err, database.ErrSeedNotRecorded), Equals, true)
+}
+
+// A failed import leaves nothing behind but the failed task
+func (s *TestSuite) Test18FailedImportIsRolledBack(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	//the last result matches an existing racer but has an unknown age category
+	_, err = s.doImport("http://www.nlaa.ca/07-Bad-Category.html")
+	c.Assert(err, NotNil)
+
+	var races api.RaceFeed
+	err = s.doRequest(s.host+"/feed/races", &races)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(races.Races), Equals, 1)
+
+	//the racers saved before the failure were rolled back
+	var matches api.RacerMatchFeed
+	err = s.doRequest(s.host+"/feed/racers/search?name=GORDON%20BLACKMORE", &matches)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(matches.Matches), Equals, 0)
+
+	request := gorequest.New()
+	resp, _, _ := request.Get(s.host + "/feed/racer/11").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	//the racer who was matched keeps only their own results
+	var results api.RaceResults
+	err = s.doRequest(s.host+"/feed/racer/1/results", &results)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(results.Results), Equals, 1)
+
+	//and the race imports cleanly once fixed
+	_, err = s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+}
+
+// Finish times are stored in milliseconds as well as the original text
+func (s *TestSuite) Test19FinishTimes(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/08-Track-Mile.html")
+	c.Assert(err, Equals, nil)
+
+	var raceResults api.RaceResults
+	err = s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 3)
+
+	//fractions of a second
+	c.Assert(raceResults.Results[0].Time, Equals, "4:21.37")
+	c.Assert(raceResults.Results[0].TimeMs, Equals, 261370)
+	c.Assert(raceResults.Results[1].Time, Equals, "5:02.9")
+	c.Assert(raceResults.Results[1].TimeMs, Equals, 302900)
+
+	//hours
+	c.Assert(raceResults.Results[2].Time, Equals, "1:05:02")
+	c.Assert(raceResults.Results[2].TimeMs, Equals, 3902000)
+
+	//and on the racer's results
+	var racerResults api.RaceResults
+	err = s.doRequest(s.host+"/feed/racer/"+raceResults.Results[1].RacerID+"/results", &racerResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(racerResults.Results[0].TimeMs, Equals, 302900)
+}
+
+// Age categories are managed through the api and matched by name or alias
+func (s *TestSuite) Test20AgeCategories(c *C) {
+
+	var categories api.AgeCategoryFeed
+	err := s.doRequest(s.host+"/feed/agecategories", &categories)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(categories.AgeCategories), Equals, 28)
+	c.Assert(categories.AgeCategories[0].Name, Equals, "U20")
+	c.Assert(categories.AgeCategories[0].MinAge, Equals, 5)
+	c.Assert(categories.AgeCategories[0].MaxAge, Equals, 19)
+
+	request := gorequest.New()
+	resp, body, _ := request.Post(s.host + "/feed/agecategories").
+		Send(api.AgeCategoryCreate{Name: "M40-44", MinAge: 40, MaxAge: 44}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	var m4044 api.AgeCategory
+	json.Unmarshal([]byte(body), &m4044)
+	c.Assert(m4044.Name, Equals, "M40-44")
+	c.Assert(m4044.MaxAge, Equals, 44)
+
+	resp, body, _ = request.Post(s.host + "/feed/agecategories").
+		Send(api.AgeCategoryCreate{Name: "Masters", MinAge: 40, MaxAge: 100, Aliases: []string{"MAS"}}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	var masters api.AgeCategory
+	json.Unmarshal([]byte(body), &masters)
+	c.Assert(len(masters.Aliases), Equals, 1)
+	c.Assert(masters.Aliases[0].Name, Equals, "MAS")
+
+	//names and aliases are unique, ignoring case
+	resp, _, _ = request.Post(s.host + "/feed/agecategories").
+		Send(api.AgeCategoryCreate{Name: "mas", MinAge: 40, MaxAge: 100}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 409)
+
+	resp, _, _ = request.Post(s.host + "/feed/agecategories").
+		Send(api.AgeCategoryCreate{Name: "Old", MinAge: 90, MaxAge: 80}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 400)
+
+	race, err := s.doImport("http://www.nlaa.ca/09-Masters-Mile.html")
+	c.Assert(err, Equals, nil)
+
+	var raceResults api.RaceResults
+	err = s.doRequest(race.ResultsPath, &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 3)
+	c.Assert(raceResults.Results[0].AgeCategory, Equals, "M40-44")
+	c.Assert(raceResults.Results[1].AgeCategory, Equals, "Masters")
+	c.Assert(raceResults.Results[2].AgeCategory, Equals, "Masters")
+
+	//categories with results can't be removed
+	resp, _, _ = gorequest.New().Delete(masters.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 409)
+
+	resp, body, _ = request.Put(m4044.SelfPath).
+		Send(api.AgeCategoryCreate{Name: "M40-44", MinAge: 40, MaxAge: 45}).
+		End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	json.Unmarshal([]byte(body), &m4044)
+	c.Assert(m4044.MaxAge, Equals, 45)
+
+	resp, _, _ = gorequest.New().Delete(masters.Aliases[0].SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	s.doRequest(masters.SelfPath, &masters)
+	c.Assert(len(masters.Aliases), Equals, 0)
+}
+
+// Results carry a canonical five year bracket, whatever categories the race used
+func (s *TestSuite) Test21CanonicalBrackets(c *C) {
+
+	summer, err := s.doImport("http://www.nlaa.ca/10-Summer-5K.html")
+	c.Assert(err, Equals, nil)
+
+	//a ten year category doesn't fit one bracket
+	var raceResults api.RaceResults
+	err = s.doRequest(summer.ResultsPath, &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(raceResults.Results[0].Bracket, Equals, "")
+
+	request := gorequest.New()
+	resp, _, _ := request.Get(summer.ResultsPath).End()
+	summerETag := resp.Header.Get("ETag")
+
+	fall, err := s.doImport("http://www.nlaa.ca/11-Fall-10K.html")
+	c.Assert(err, Equals, nil)
+
+	//the earlier race's results changed, so it isn't served from the cache
+	resp, _, _ = request.Get(summer.ResultsPath).Set("If-None-Match", summerETag).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Header.Get("ETag") != summerETag, Equals, true)
+
+	err = s.doRequest(fall.ResultsPath, &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(raceResults.Results[0].Bracket, Equals, "40-44")
+	c.Assert(raceResults.Results[1].Bracket, Equals, "20-24")
+
+	//the later race narrows down the earlier results
+	err = s.doRequest(summer.ResultsPath, &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(raceResults.Results[0].AgeCategory, Equals, "40-49")
+	c.Assert(raceResults.Results[0].Bracket, Equals, "40-44")
+	c.Assert(raceResults.Results[1].Bracket, Equals, "")
+
+	err = s.doRequest(summer.ResultsPath+"?bracket=40-44", &raceResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 1)
+	c.Assert(raceResults.Results[0].Name, Equals, "KEVIN LAHEY")
+
+	//every 40-44 finisher of the season
+	var season api.RaceResults
+	err = s.doRequest(s.host+"/feed/results?bracket=40-44&from=2015-01-01&to=2015-12-31", &season)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(season.Results), Equals, 2)
+	c.Assert(season.Results[0].RaceID, Equals, summer.Id)
+	c.Assert(season.Results[1].RaceID, Equals, fall.Id)
+	c.Assert(len(season.Racers), Equals, 1)
+	c.Assert(len(season.Races), Equals, 2)
+
+	err = s.doRequest(s.host+"/feed/results?bracket=40-44&from=2015-07-01", &season)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(season.Results), Equals, 1)
+
+	var racerResults api.RaceResults
+	err = s.doRequest(s.host+"/feed/racer/"+raceResults.Results[0].RacerID+"/results?bracket=20-24", &racerResults)
+	c.Assert(err, Equals, nil)
+	c.Assert(len(racerResults.Results), Equals, 0)
+
+	resp, _, _ = request.Get(s.host + "/feed/results?bracket=41-45").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+	resp, _, _ = request.Get(s.host + "/feed/results").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+func (s *TestSuite) Test22ErrorResponses(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	request := gorequest.New()
+
+	//missing records are 404 whatever the route
+	resp, _, _ := request.Get(s.host + "/feed/racegroup/99").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Get(s.host + "/feed/racegroup/99/races").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Delete(s.host + "/feed/racegroup/99").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Put(s.host + "/feed/racegroup/99").Send(api.RaceGroupCreate{Name: "Tely 10"}).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Get(s.host + "/feed/racegroup/abc").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Get(s.host + "/feed/racer/99/profile").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Get(s.host + "/import/task/99").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	//bad paging stops before any results are sent
+	resp, body, _ := request.Get(race.ResultsPath + "?startPos=abc").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+	c.Assert(strings.Contains(body, "results"), Equals, false)
+
+	//adding a race that doesn't exist to a group is a bad request
+	var raceGroup api.RaceGroup
+	resp, body, _ = request.Post(s.host + "/feed/racegroup").Send(api.RaceGroupCreate{Name: "Flat Out", Distance: "5", DistanceUnit: "k"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	json.Unmarshal([]byte(body), &raceGroup)
+
+	resp, _, _ = request.Post(raceGroup.SelfPath + "/races").Send(api.RaceGroupAddRace{RaceId: "99"}).End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+// Deleting a race puts it in the trash, purging it takes its results with it,
+// along with the racers who only ran that race
+func (s *TestSuite) Test23DeleteRaceCascades(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	race, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var firstRaceResults api.RaceResults
+	s.doRequest(s.host+"/feed/race/1/results", &firstRaceResults)
+	chris := firstRaceResults.Racers[firstRaceResults.Results[4].RacerID]
+
+	var raceResults api.RaceResults
+	s.doRequest(race.ResultsPath, &raceResults)
+
+	request := gorequest.New()
+
+	var raceGroup api.RaceGroup
+	resp, body, _ := request.Post(s.host + "/feed/racegroup").Send(api.RaceGroupCreate{Name: "Flat Out", Distance: "5", DistanceUnit: "k"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	json.Unmarshal([]byte(body), &raceGroup)
+	resp, _, _ = request.Post(raceGroup.RacesPath).Send(api.RaceGroupAddRace{RaceId: race.Id}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Get(s.host + "/feed/racegroups").End()
+	raceGroupsEtag := resp.Header.Get("ETag")
+	c.Assert(raceGroupsEtag, Not(Equals), "")
+
+	//the racer's results carry the racer's etag
+	resp, _, _ = request.Get(chris.ResultsPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	racerEtag := resp.Header.Get("ETag")
+	c.Assert(racerEtag, Not(Equals), "")
+	resp, _, _ = request.Get(chris.ResultsPath).Set("If-None-Match", racerEtag).End()
+	c.Assert(resp.StatusCode, Equals, 304)
+
+	var deleted api.Race
+	resp, body, _ = request.Delete(race.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	json.Unmarshal([]byte(body), &deleted)
+	c.Assert(deleted.Deleted, Not(Equals), "")
+	c.Assert(deleted.RestorePath, Equals, race.SelfPath+"/restore")
+
+	//the race and its results are hidden
+	resp, _, _ = request.Get(race.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Get(race.ResultsPath).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	var chrisResults api.RaceResults
+	resp, body, _ = request.Get(chris.ResultsPath).Set("If-None-Match", racerEtag).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Header.Get("ETag"), Not(Equals), racerEtag)
+	json.Unmarshal([]byte(body), &chrisResults)
+	c.Assert(len(chrisResults.Results), Equals, 1)
+	c.Assert(chrisResults.Results[0].RaceID, Equals, "1")
+
+	resp, _, _ = request.Get(s.host + "/feed/racegroups").End()
+	c.Assert(resp.Header.Get("ETag"), Not(Equals), raceGroupsEtag)
+
+	var races api.RaceFeed
+	s.doRequest(raceGroup.RacesPath, &races)
+	c.Assert(len(races.Races), Equals, 0)
+	s.doRequest(s.host+"/feed/races", &races)
+	c.Assert(len(races.Races), Equals, 1)
+
+	var trash api.TrashFeed
+	s.doRequest(s.host+"/feed/trash", &trash)
+	c.Assert(len(trash.Races), Equals, 1)
+	c.Assert(trash.Races[0].Id, Equals, race.Id)
+	c.Assert(len(trash.RaceGroups), Equals, 0)
+
+	//a race in the trash is restored rather than imported again
+	_, err = s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Not(Equals), nil)
+
+	resp, _, _ = request.Post(trash.Races[0].RestorePath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	resp, _, _ = request.Post(trash.Races[0].RestorePath).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	s.doRequest(chris.ResultsPath, &chrisResults)
+	c.Assert(len(chrisResults.Results), Equals, 2)
+	s.doRequest(raceGroup.RacesPath, &races)
+	c.Assert(len(races.Races), Equals, 1)
+
+	//purging only takes what has been in the trash long enough
+	resp, _, _ = request.Delete(race.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	purged, err := s.store().PurgeDeletedRaces(time.Now().Add(-time.Hour))
+	c.Assert(err, Equals, nil)
+	c.Assert(len(purged), Equals, 0)
+
+	purged, err = s.store().PurgeDeletedRaces(time.Now())
+	c.Assert(err, Equals, nil)
+	c.Assert(len(purged), Equals, 1)
+	c.Assert(strconv.Itoa(purged[0].ID), Equals, race.Id)
+
+	s.doRequest(s.host+"/feed/trash", &trash)
+	c.Assert(len(trash.Races), Equals, 0)
+
+	//racers who also ran the first race keep that result, the rest are gone
+	s.doRequest(chris.ResultsPath, &chrisResults)
+	c.Assert(len(chrisResults.Results), Equals, 1)
+
+	removed := 0
+	for _, racer := range raceResults.Racers {
+		resp, _, _ = request.Get(racer.SelfPath).End()
+		if resp.StatusCode == 404 {
+			removed++
+		} else {
+			c.Assert(resp.StatusCode, Equals, 200)
+		}
+	}
+	c.Assert(removed > 0, Equals, true)
+	c.Assert(removed < len(raceResults.Racers), Equals, true)
+
+	//the race can be imported again
+	race, err = s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	s.doRequest(chris.ResultsPath, &chrisResults)
+	c.Assert(len(chrisResults.Results), Equals, 2)
+
+	//moving all of a racer's results away leaves an orphan for gc to remove
+	resultIds := []string{chrisResults.Results[0].Id, chrisResults.Results[1].Id}
+	resp, _, _ = request.Post(chris.SplitPath).Send(api.RacerSplit{ResultIds: resultIds}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Get(chris.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	c.Assert(s.s.CollectGarbage(), Equals, nil)
+
+	resp, _, _ = request.Get(chris.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	//gc records the racers it removed
+	var audit api.AuditFeed
+	c.Assert(s.doRequest(s.host+"/audit?action=gc", &audit), Equals, nil)
+	c.Assert(len(audit.Entries), Equals, 1)
+	c.Assert(audit.Entries[0].Entity, Equals, "racer")
+	c.Assert(audit.Entries[0].EntityId, Equals, chris.Id)
+	c.Assert(audit.Entries[0].Actor, Equals, "running-man gc")
+
+	removedRacers, err := s.store().DeleteOrphanRacers()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(removedRacers), Equals, 0)
+}
+
+// Race groups go to the trash and keep their races until they are purged
+func (s *TestSuite) Test24RaceGroupTrash(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/03-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	request := gorequest.New()
+
+	var raceGroup api.RaceGroup
+	resp, body, _ := request.Post(s.host + "/feed/racegroup").Send(api.RaceGroupCreate{Name: "Mundy Pond", Distance: "5", DistanceUnit: "k"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	json.Unmarshal([]byte(body), &raceGroup)
+	resp, _, _ = request.Post(raceGroup.RacesPath).Send(api.RaceGroupAddRace{RaceId: race.Id}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Delete(raceGroup.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Get(raceGroup.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+	resp, _, _ = request.Get(raceGroup.RacesPath).End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	var raceGroups api.RaceGroupFeed
+	s.doRequest(s.host+"/feed/racegroups", &raceGroups)
+	c.Assert(len(raceGroups.RaceGroups), Equals, 0)
+
+	var trash api.TrashFeed
+	s.doRequest(s.host+"/feed/trash", &trash)
+	c.Assert(len(trash.RaceGroups), Equals, 1)
+	c.Assert(trash.RaceGroups[0].RestorePath, Equals, raceGroup.SelfPath+"/restore")
+
+	//restoring brings back the group with its race
+	var restored api.RaceGroup
+	resp, body, _ = request.Post(trash.RaceGroups[0].RestorePath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	json.Unmarshal([]byte(body), &restored)
+	c.Assert(restored.Name, Equals, "Mundy Pond")
+	c.Assert(restored.Deleted, Equals, "")
+
+	var races api.RaceFeed
+	s.doRequest(raceGroup.RacesPath, &races)
+	c.Assert(len(races.Races), Equals, 1)
+
+	//purging the group leaves its race without one
+	resp, _, _ = request.Delete(raceGroup.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	c.Assert(s.s.PurgeTrash(0), Equals, nil)
+
+	resp, _, _ = request.Post(raceGroup.SelfPath + "/restore").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+
+	//the purge is in the audit log with the race group it removed
+	var audit api.AuditFeed
+	c.Assert(s.doRequest(s.host+"/audit?action=purge", &audit), Equals, nil)
+	c.Assert(len(audit.Entries), Equals, 1)
+	c.Assert(audit.Entries[0].Entity, Equals, "racegroup")
+	c.Assert(audit.Entries[0].EntityId, Equals, raceGroup.Id)
+	c.Assert(audit.Entries[0].Actor, Equals, "running-man purge")
+
+	var purgedGroup database.RaceGroup
+	json.Unmarshal(audit.Entries[0].Before, &purgedGroup)
+	c.Assert(purgedGroup.Name, Equals, "Mundy Pond")
+
+	var purgedRace api.Race
+	s.doRequest(race.SelfPath, &purgedRace)
+	c.Assert(purgedRace.RaceGroupPath, Equals, "")
+}
+
+func (s *TestSuite) Test25Audit(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/03-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	request := gorequest.New()
+
+	var raceGroup api.RaceGroup
+	resp, body, _ := request.Post(s.host+"/feed/racegroup").Set("X-Actor", "jordan").Send(api.RaceGroupCreate{Name: "Mundy Pond", Distance: "5", DistanceUnit: "k"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	json.Unmarshal([]byte(body), &raceGroup)
+
+	resp, _, _ = request.Put(raceGroup.SelfPath).Set("X-Actor", "jordan").Send(api.RaceGroupCreate{Name: "Mundy Pond Classic", Distance: "5", DistanceUnit: "k"}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Post(raceGroup.RacesPath).Send(api.RaceGroupAddRace{RaceId: race.Id}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Delete(race.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	//newest first
+	var audit api.AuditFeed
+	c.Assert(s.doRequest(s.host+"/audit", &audit), Equals, nil)
+	c.Assert(len(audit.Entries), Equals, 5)
+	c.Assert(audit.Entries[0].Action, Equals, "delete")
+	c.Assert(audit.Entries[0].Entity, Equals, "race")
+	c.Assert(audit.Entries[0].EntityPath, Equals, race.SelfPath)
+	c.Assert(audit.Entries[1].Action, Equals, "add-to-racegroup")
+	c.Assert(audit.Entries[4].Action, Equals, "import")
+	c.Assert(audit.Entries[4].Entity, Equals, "importtask")
+
+	var deletedRace api.Race
+	json.Unmarshal(audit.Entries[0].Before, &deletedRace)
+	c.Assert(deletedRace.Deleted, Equals, "")
+	c.Assert(deletedRace.RaceGroupPath, Equals, raceGroup.SelfPath)
+	json.Unmarshal(audit.Entries[0].After, &deletedRace)
+	c.Assert(deletedRace.Deleted, Not(Equals), "")
+
+	//filtered by entity and id
+	audit = api.AuditFeed{}
+	c.Assert(s.doRequest(s.host+"/audit?entity=racegroup&id="+raceGroup.Id, &audit), Equals, nil)
+	c.Assert(len(audit.Entries), Equals, 2)
+	c.Assert(audit.Entries[0].Action, Equals, "update")
+	c.Assert(audit.Entries[0].Actor, Equals, "jordan")
+	c.Assert(audit.Entries[1].Action, Equals, "create")
+	c.Assert(len(audit.Entries[1].Before), Equals, 0)
+
+	var before, after api.RaceGroup
+	json.Unmarshal(audit.Entries[0].Before, &before)
+	json.Unmarshal(audit.Entries[0].After, &after)
+	c.Assert(before.Name, Equals, "Mundy Pond")
+	c.Assert(after.Name, Equals, "Mundy Pond Classic")
+
+	c.Assert(s.doRequest(s.host+"/audit?actor=jordan&limit=1", &audit), Equals, nil)
+	c.Assert(len(audit.Entries), Equals, 1)
+	c.Assert(audit.Entries[0].Action, Equals, "update")
+
+	resp, _, _ = request.Get(s.host + "/audit?entity=runner").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+	resp, _, _ = request.Get(s.host + "/audit?entity=race&id=one").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+
+	//a change is undone when its audit entry can't be recorded
+	failed := errors.New("audit log unavailable")
+	raceGroupID, _ := strconv.Atoi(raceGroup.Id)
+	err = s.store().Transaction(func(tx database.Store) error {
+		if _, err := tx.UpdateRaceGroup(raceGroupID, "Quidi Vidi", "5", "k"); err != nil {
+			return err
+		}
+		return failed
+	})
+	c.Assert(errors.Is(err, failed), Equals, true)
+
+	var unchanged api.RaceGroup
+	s.doRequest(raceGroup.SelfPath, &unchanged)
+	c.Assert(unchanged.Name, Equals, "Mundy Pond Classic")
+}
+
+func (s *TestSuite) Test26SearchRacers(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	race, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var search api.RacerSearchFeed
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=jordan%20fewer", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 1)
+	c.Assert(search.Racers[0].Match, Equals, "exact")
+	c.Assert(search.Racers[0].Races, Equals, 2)
+	c.Assert(search.Racers[0].Racer.SelfPath, Equals, s.host+"/feed/racer/1")
+	c.Assert(search.Racers[0].Racer.ResultsPath, Equals, s.host+"/feed/racer/1/results")
+
+	//names that sound alike are found too
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=andrea%20sparks", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 1)
+	c.Assert(search.Racers[0].Name, Equals, "ANDREA SPARKES")
+	c.Assert(search.Racers[0].Match, Equals, "fuzzy")
+
+	//as are nicknames
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=mike%20scott", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 1)
+	c.Assert(search.Racers[0].Name, Equals, "MICHAEL SCOTT")
+	c.Assert(search.Racers[0].Match, Equals, "fuzzy")
+
+	//prefixes rank before substrings
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=an", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 5)
+	c.Assert(search.Racers[0].Match, Equals, "prefix")
+	c.Assert(search.Racers[4].Match, Equals, "substring")
+
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=an&sex=f", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 2)
+
+	//a page at a time
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=an&limit=2", &search), Equals, nil)
+	c.Assert(len(search.Racers), Equals, 2)
+	c.Assert(search.PreviousPath, Equals, "")
+	c.Assert(search.NextPath, Equals, s.host+"/feed/racers?limit=2&offset=2&q=an")
+
+	var next api.RacerSearchFeed
+	c.Assert(s.doRequest(search.NextPath, &next), Equals, nil)
+	c.Assert(len(next.Racers), Equals, 2)
+	c.Assert(next.Racers[0].Racer.Id, Not(Equals), search.Racers[0].Racer.Id)
+	c.Assert(next.PreviousPath, Equals, s.host+"/feed/racers?limit=2&offset=0&q=an")
+
+	//an alias finds the racer
+	request := gorequest.New()
+	resp, _, _ := request.Post(s.host + "/feed/racer/1/aliases").Send(api.RacerAliasCreate{Name: "Speedy Fewer"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=speedy", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 1)
+	c.Assert(search.Racers[0].Racer.Id, Equals, "1")
+
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=an&bornFrom=2100", &search), Equals, nil)
+	c.Assert(search.Total, Equals, 0)
+
+	//races in the trash don't count
+	resp, _, _ = request.Delete(race.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	search = api.RacerSearchFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=jordan%20fewer", &search), Equals, nil)
+	c.Assert(search.Racers[0].Races, Equals, 1)
+
+	resp, _, _ = request.Get(s.host + "/feed/racers").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+	resp, _, _ = request.Get(s.host + "/feed/racers?q=an&bornFrom=sixty").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+
+	//a single letter matches nearly everyone
+	resp, _, _ = request.Get(s.host + "/feed/racers?q=a").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+func (s *TestSuite) Test27SuggestRacers(c *C) {
+
+	_, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var suggest api.RacerSuggestionFeed
+	c.Assert(s.doRequest(s.host+"/feed/racers/suggest?prefix=andrea", &suggest), Equals, nil)
+	c.Assert(len(suggest.Suggestions), Equals, 1)
+	c.Assert(suggest.Suggestions[0].Name, Equals, "ANDREA SPARKES")
+
+	//the index keeps up with new races
+	race, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	suggest = api.RacerSuggestionFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers/suggest?prefix=andrea", &suggest), Equals, nil)
+	c.Assert(len(suggest.Suggestions), Equals, 2)
+
+	//the racers with the most races come first, found by any word of their name
+	suggest = api.RacerSuggestionFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers/suggest?prefix=f&limit=1", &suggest), Equals, nil)
+	c.Assert(len(suggest.Suggestions), Equals, 1)
+	c.Assert(suggest.Suggestions[0].Name, Equals, "JORDAN FEWER")
+	c.Assert(suggest.Suggestions[0].Races, Equals, 2)
+	c.Assert(suggest.Suggestions[0].Racer.SelfPath, Equals, s.host+"/feed/racer/1")
+
+	//and with merges
+	var andreaWhite, andreaSparkes api.RacerSearchFeed
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=andrea%20white", &andreaWhite), Equals, nil)
+	c.Assert(s.doRequest(s.host+"/feed/racers?q=andrea%20sparkes", &andreaSparkes), Equals, nil)
+
+	request := gorequest.New()
+	resp, _, _ := request.Post(andreaWhite.Racers[0].Racer.MergePath).Send(api.RacerMerge{RacerId: andreaSparkes.Racers[0].Racer.Id}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	suggest = api.RacerSuggestionFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers/suggest?prefix=andrea", &suggest), Equals, nil)
+	c.Assert(len(suggest.Suggestions), Equals, 1)
+	c.Assert(suggest.Suggestions[0].Races, Equals, 2)
+	c.Assert(suggest.Suggestions[0].Racer.Id, Equals, andreaWhite.Racers[0].Racer.Id)
+
+	//and with the trash
+	resp, _, _ = request.Delete(race.SelfPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	suggest = api.RacerSuggestionFeed{}
+	c.Assert(s.doRequest(s.host+"/feed/racers/suggest?prefix=jordan", &suggest), Equals, nil)
+	c.Assert(suggest.Suggestions[0].Races, Equals, 1)
+
+	resp, _, _ = request.Get(s.host + "/feed/racers/suggest").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+func (s *TestSuite) Test28ListRaces(c *C) {
+
+	for _, path := range []string{"00-Road-Race.html", "01-Road-Race.html", "03-Road-Race.html", "04-Road-Race.html", "05-Tely.html"} {
+		_, err := s.doImport("http://www.nlaa.ca/" + path)
+		c.Assert(err, Equals, nil)
+	}
+
+	var all api.RaceFeed
+	c.Assert(s.doRequest(s.host+"/feed/races", &all), Equals, nil)
+	c.Assert(len(all.Races), Equals, 5)
+	c.Assert(all.NextPath, Equals, "")
+
+	//paging through the races two at a time lists every race once, in order
+	var pages []api.RaceFeed
+	path := s.host + "/feed/races?limit=2"
+	for len(path) > 0 && len(pages) < 5 {
+		var page api.RaceFeed
+		c.Assert(s.doRequest(path, &page), Equals, nil)
+		pages = append(pages, page)
+		path = page.NextPath
+	}
+	c.Assert(len(pages), Equals, 3)
+	c.Assert(pages[0].PreviousPath, Equals, "")
+
+	var paged []api.Race
+	for _, page := range pages {
+		paged = append(paged, page.Races...)
+	}
+	c.Assert(paged, DeepEquals, all.Races)
+
+	var previous api.RaceFeed
+	c.Assert(s.doRequest(pages[1].PreviousPath, &previous), Equals, nil)
+	c.Assert(previous.Races, DeepEquals, pages[0].Races)
+	c.Assert(previous.PreviousPath, Equals, "")
+
+	var byName api.RaceFeed
+	c.Assert(s.doRequest(s.host+"/feed/races?sort=name", &byName), Equals, nil)
+	c.Assert(len(byName.Races), Equals, 5)
+	for i := 1; i < len(byName.Races); i++ {
+		c.Assert(byName.Races[i-1].Name <= byName.Races[i].Name, Equals, true)
+	}
+
+	var named api.RaceFeed
+	c.Assert(s.doRequest(s.host+"/feed/races?name=mundy", &named), Equals, nil)
+	c.Assert(len(named.Races), Equals, 2)
+
+	year := all.Races[0].Date[:4]
+	var inYear api.RaceFeed
+	c.Assert(s.doRequest(s.host+"/feed/races?year="+year, &inYear), Equals, nil)
+	c.Assert(len(inYear.Races) > 0, Equals, true)
+	for _, race := range inYear.Races {
+		c.Assert(race.Date[:4], Equals, year)
+	}
+
+	var between api.RaceFeed
+	c.Assert(s.doRequest(s.host+"/feed/races?from="+all.Races[1].Date+"&to="+all.Races[1].Date, &between), Equals, nil)
+	c.Assert(len(between.Races) > 0, Equals, true)
+	for _, race := range between.Races {
+		c.Assert(race.Date, Equals, all.Races[1].Date)
+	}
+
+	//by race group
+	request := gorequest.New()
+	var raceGroup api.RaceGroup
+	resp, body, _ := request.Post(s.host + "/feed/racegroup").Send(api.RaceGroupCreate{Name: "Mundy Pond", Distance: "5", DistanceUnit: "k"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	json.Unmarshal([]byte(body), &raceGroup)
+	resp, _, _ = request.Post(raceGroup.RacesPath).Send(api.RaceGroupAddRace{RaceId: named.Races[0].Id}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	var grouped, ungrouped, inGroup api.RaceFeed
+	c.Assert(s.doRequest(s.host+"/feed/races?grouped=true", &grouped), Equals, nil)
+	c.Assert(s.doRequest(s.host+"/feed/races?grouped=false", &ungrouped), Equals, nil)
+	c.Assert(s.doRequest(s.host+"/feed/races?raceGroup="+raceGroup.Id, &inGroup), Equals, nil)
+	c.Assert(len(grouped.Races), Equals, 1)
+	c.Assert(len(ungrouped.Races), Equals, 4)
+	c.Assert(inGroup.Races[0].Id, Equals, named.Races[0].Id)
+
+	//each query has its own etag
+	resp, _, _ = request.Get(s.host + "/feed/races").End()
+	listETag := resp.Header.Get("ETag")
+	resp, _, _ = request.Get(s.host + "/feed/races?name=mundy").End()
+	namedETag := resp.Header.Get("ETag")
+	c.Assert(namedETag, Not(Equals), "")
+	c.Assert(namedETag, Not(Equals), listETag)
+
+	resp, _, _ = request.Get(s.host+"/feed/races?name=mundy").Set("If-None-Match", namedETag).End()
+	c.Assert(resp.StatusCode, Equals, 304)
+	resp, _, _ = request.Get(s.host+"/feed/races?name=tely").Set("If-None-Match", namedETag).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, _, _ = request.Get(s.host + "/feed/races?sort=distance").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+	resp, _, _ = request.Get(s.host + "/feed/races?after=nonsense").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+func (s *TestSuite) Test29FilterRaceResults(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/02-Tely.html")
+	c.Assert(err, Equals, nil)
+
+	var all api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath, &all), Equals, nil)
+	c.Assert(len(all.Results), Equals, 40)
+	c.Assert(*all.Total, Equals, 40)
+	c.Assert(all.NextPath, Equals, "")
+
+	sex := all.Results[0].Sex
+	category := all.Results[0].AgeCategory
+
+	var bySex api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?sex="+strings.ToLower(sex), &bySex), Equals, nil)
+	c.Assert(len(bySex.Results) > 0, Equals, true)
+	c.Assert(len(bySex.Results) < len(all.Results), Equals, true)
+	c.Assert(*bySex.Total, Equals, len(bySex.Results))
+	for _, result := range bySex.Results {
+		c.Assert(result.Sex, Equals, sex)
+	}
+
+	//within a category by category place
+	var inCategory api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?category="+url.QueryEscape(category)+"&sort=category", &inCategory), Equals, nil)
+	c.Assert(len(inCategory.Results) > 1, Equals, true)
+	for i, result := range inCategory.Results {
+		c.Assert(result.AgeCategory, Equals, category)
+		if i > 0 {
+			c.Assert(inCategory.Results[i-1].AgeCategoryPosition < result.AgeCategoryPosition, Equals, true)
+		}
+	}
+
+	//by chip time, the results without one by their gun time
+	var byChipTime api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?sort=chiptime", &byChipTime), Equals, nil)
+	c.Assert(len(byChipTime.Results), Equals, 40)
+	chipTime := func(result api.RaceResult) int {
+		if result.ChipTimeMs > 0 {
+			return result.ChipTimeMs
+		}
+		return result.TimeMs
+	}
+	for i := 1; i < len(byChipTime.Results); i++ {
+		c.Assert(chipTime(byChipTime.Results[i-1]) <= chipTime(byChipTime.Results[i]), Equals, true)
+	}
+
+	var slowestFirst api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?sort=-time&limit=1", &slowestFirst), Equals, nil)
+	c.Assert(slowestFirst.Results[0].Id, Equals, all.Results[39].Id)
+
+	var byBib api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?bib=3662", &byBib), Equals, nil)
+	c.Assert(len(byBib.Results), Equals, 1)
+	c.Assert(byBib.Results[0].Id, Equals, all.Results[0].Id)
+
+	var byName api.RaceResults
+	name := strings.Fields(all.Results[5].Name)[0]
+	c.Assert(s.doRequest(race.ResultsPath+"?name="+url.QueryEscape(strings.ToLower(name)), &byName), Equals, nil)
+	c.Assert(len(byName.Results) > 0, Equals, true)
+	for _, result := range byName.Results {
+		c.Assert(strings.Contains(strings.ToUpper(result.Name), strings.ToUpper(name)), Equals, true)
+	}
+
+	//paging through the results fifteen at a time lists every result once
+	var paged []api.RaceResult
+	path := race.ResultsPath + "?limit=15"
+	var pages []api.RaceResults
+	for len(path) > 0 && len(pages) < 5 {
+		var page api.RaceResults
+		c.Assert(s.doRequest(path, &page), Equals, nil)
+		c.Assert(*page.Total, Equals, 40)
+		pages = append(pages, page)
+		paged = append(paged, page.Results...)
+		path = page.NextPath
+	}
+	c.Assert(len(pages), Equals, 3)
+	c.Assert(pages[0].PreviousPath, Equals, "")
+	c.Assert(paged, DeepEquals, all.Results)
+
+	var previous api.RaceResults
+	c.Assert(s.doRequest(pages[2].PreviousPath, &previous), Equals, nil)
+	c.Assert(previous.Results, DeepEquals, pages[1].Results)
+
+	//startPos and num still work alongside the filters
+	var fromFifth api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?startPos=5&num=3", &fromFifth), Equals, nil)
+	c.Assert(len(fromFifth.Results), Equals, 3)
+	c.Assert(fromFifth.Results[0].Position, Equals, 5)
+
+	//each query has its own etag
+	request := gorequest.New()
+	resp, _, _ := request.Get(race.ResultsPath).End()
+	allETag := resp.Header.Get("ETag")
+	resp, _, _ = request.Get(race.ResultsPath + "?sort=chiptime").End()
+	chipETag := resp.Header.Get("ETag")
+	c.Assert(chipETag, Not(Equals), "")
+	c.Assert(chipETag, Not(Equals), allETag)
+
+	resp, _, _ = request.Get(race.ResultsPath+"?sort=chiptime").Set("If-None-Match", chipETag).End()
+	c.Assert(resp.StatusCode, Equals, 304)
+	resp, _, _ = request.Get(race.ResultsPath+"?sort=time").Set("If-None-Match", chipETag).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	for _, query := range []string{"sort=distance", "offset=-1", "limit=0", "category=nonsense"} {
+		resp, _, _ = request.Get(race.ResultsPath + "?" + query).End()
+		c.Assert(resp.StatusCode, Equals, 400)
+
+		//the query is checked before the etag it would have
+		values, err := url.ParseQuery(query)
+		c.Assert(err, Equals, nil)
+		h := sha1.New()
+		h.Write([]byte(allETag + "?" + values.Encode()))
+		resp, _, _ = request.Get(race.ResultsPath+"?"+query).Set("If-None-Match", hex.EncodeToString(h.Sum(nil))).End()
+		c.Assert(resp.StatusCode, Equals, 400)
+	}
+}
+
+func (s *TestSuite) Test30RankByChipTime(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/02-Tely.html")
+	c.Assert(err, Equals, nil)
+
+	var gun, chip api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath, &gun), Equals, nil)
+	c.Assert(gun.Ranking, Equals, "")
+	c.Assert(gun.Results[0].GunPosition, Equals, 0)
+
+	c.Assert(s.doRequest(race.ResultsPath+"?rank=chip", &chip), Equals, nil)
+	c.Assert(chip.Ranking, Equals, "chip")
+	c.Assert(len(chip.Results), Equals, 40)
+
+	gunPlaces := map[string]api.RaceResult{}
+	for _, result := range gun.Results {
+		gunPlaces[result.Id] = result
+	}
+
+	chipTime := func(result api.RaceResult) int {
+		if result.ChipTimeMs > 0 {
+			return result.ChipTimeMs
+		}
+		return result.TimeMs
+	}
+
+	moved := 0
+	sexes := map[string]int{}
+	categories := map[string]int{}
+	for i, result := range chip.Results {
+		c.Assert(result.Position, Equals, i+1)
+		if i > 0 {
+			c.Assert(chipTime(chip.Results[i-1]) <= chipTime(result), Equals, true)
+		}
+
+		sexes[result.Sex]++
+		c.Assert(result.SexPosition, Equals, sexes[result.Sex])
+		if len(result.AgeCategory) > 0 {
+			categories[result.AgeCategory]++
+			c.Assert(result.AgeCategoryPosition, Equals, categories[result.AgeCategory])
+		}
+
+		//the published places are kept alongside
+		published := gunPlaces[result.Id]
+		c.Assert(result.GunPosition, Equals, published.Position)
+		c.Assert(result.GunSexPosition, Equals, published.SexPosition)
+		c.Assert(result.GunAgeCategoryPosition, Equals, published.AgeCategoryPosition)
+		if result.Position != published.Position {
+			moved++
+		}
+	}
+	c.Assert(moved > 0, Equals, true)
+
+	//a filtered result keeps its place in the whole race
+	var chipInSex api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?rank=chip&sex="+chip.Results[1].Sex+"&sort=-position", &chipInSex), Equals, nil)
+	for i := 1; i < len(chipInSex.Results); i++ {
+		c.Assert(chipInSex.Results[i-1].Position > chipInSex.Results[i].Position, Equals, true)
+	}
+	last := chipInSex.Results[len(chipInSex.Results)-1]
+	for _, result := range chip.Results {
+		if result.Sex == chip.Results[1].Sex {
+			c.Assert(last.Id, Equals, result.Id)
+			c.Assert(last.Position, Equals, result.Position)
+			c.Assert(last.SexPosition, Equals, 1)
+			break
+		}
+	}
+
+	//the start position and paging are by chip place too
+	var fromChip api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath+"?rank=chip&startPos=11&num=5", &fromChip), Equals, nil)
+	c.Assert(len(fromChip.Results), Equals, 5)
+	for i, result := range fromChip.Results {
+		c.Assert(result.Id, Equals, chip.Results[10+i].Id)
+		c.Assert(result.Position, Equals, 11+i)
+	}
+
+	resp, _, _ := gorequest.New().Get(race.ResultsPath + "?rank=net").End()
+	c.Assert(resp.StatusCode, Equals, 400)
+}
+
+func (s *TestSuite) Test31ExportResults(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/02-Tely.html")
+	c.Assert(err, Equals, nil)
+
+	request := gorequest.New()
+
+	var results api.RaceResults
+	resp, body, _ := request.Get(race.ResultsPath + "?sex=F").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(json.Unmarshal([]byte(body), &results), Equals, nil)
+	jsonETag := resp.Header.Get("ETag")
+
+	//the csv has a row for each result under the column names, with the same etag
+	resp, body, _ = request.Get(race.ResultsPath + "?sex=F&format=csv").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "text/csv; charset=utf-8")
+	c.Assert(resp.Header.Get("ETag"), Equals, jsonETag)
+	c.Assert(strings.Contains(resp.Header.Get("Content-Disposition"), "race-"+race.Id+"-results.csv"), Equals, true)
+
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(records), Equals, len(results.Results)+1)
+	c.Assert(records[0][:4], DeepEquals, []string{"position", "sexPosition", "ageCategoryPosition", "name"})
+	c.Assert(records[1][3], Equals, results.Results[0].Name)
+
+	resp, _, _ = request.Get(race.ResultsPath+"?sex=F&format=csv").Set("If-None-Match", jsonETag).End()
+	c.Assert(resp.StatusCode, Equals, 304)
+	c.Assert(resp.Header.Get("Vary"), Equals, "Accept")
+
+	//by the Accept header, with the columns asked for
+	resp, body, _ = request.Get(race.ResultsPath+"?columns=name,timeMs,race").Set("Accept", "text/csv").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	records, err = csv.NewReader(strings.NewReader(body)).ReadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(records), Equals, 41)
+	c.Assert(records[0], DeepEquals, []string{"name", "timeMs", "race"})
+	c.Assert(records[1][1], Equals, "2968000")
+	c.Assert(records[1][2], Equals, race.Name)
+
+	//the spreadsheet is a workbook with a row for each result
+	resp, body, _ = request.Get(race.ResultsPath + "?format=xlsx").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+
+	sheet := xlsxSheet(c, body)
+	c.Assert(strings.Count(sheet, "<row "), Equals, 41)
+	c.Assert(strings.Contains(sheet, `<c r="A2"><v>1</v></c>`), Equals, true)
+
+	//a racer's history exports the same way
+	var racerResults api.RaceResults
+	racerPath := s.host + "/feed/racer/" + results.Results[0].RacerID + "/results"
+	c.Assert(s.doRequest(racerPath, &racerResults), Equals, nil)
+	resp, body, _ = request.Get(racerPath + "?format=csv&columns=race,raceDate,time").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	records, err = csv.NewReader(strings.NewReader(body)).ReadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(len(records), Equals, len(racerResults.Results)+1)
+	c.Assert(records[1], DeepEquals, []string{race.Name, race.Date, results.Results[0].Time})
+
+	for _, query := range []string{"format=pdf", "format=csv&columns=name,shoeSize"} {
+		resp, _, _ = request.Get(race.ResultsPath + "?" + query).End()
+		c.Assert(resp.StatusCode, Equals, 400)
+	}
+
+	//values a spreadsheet would take for a formula are quoted in the csv, but
+	//not numbers like the -19 age category
+	task, err := s.store().CreateImportTask("http://www.nlaa.ca/formulas.html")
+	c.Assert(err, Equals, nil)
+	formulas := generatedRace(2015, 4)
+	formulas.Racers[0].Name = "=HYPERLINK(\"http://example.com\")"
+	formulas.Racers[1].Name = "+1+1"
+	formulas.Racers[2].Name = "-2+3"
+	formulas.Racers[3].Name = "@SUM(A1:A2)"
+	formulas.Racers[3].AgeCategory = "-19"
+	saved, err := s.store().SaveRace(task, formulas)
+	c.Assert(err, Equals, nil)
+	formulasPath := fmt.Sprintf("%s/feed/race/%d/results?columns=name,ageCategory", s.host, saved.ID)
+
+	resp, body, _ = request.Get(formulasPath + "&format=csv").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	records, err = csv.NewReader(strings.NewReader(body)).ReadAll()
+	c.Assert(err, Equals, nil)
+	c.Assert(records, DeepEquals, [][]string{
+		{"name", "ageCategory"},
+		{"'=HYPERLINK(\"http://example.com\")", "30-34"},
+		{"'+1+1", "30-34"},
+		{"'-2+3", "30-34"},
+		{"'@SUM(A1:A2)", "-19"},
+	})
+
+	resp, body, _ = request.Get(formulasPath + "&format=xlsx").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	sheet = xlsxSheet(c, body)
+	//the cells are strings, which are never run, so they are as imported
+	c.Assert(strings.Contains(sheet, `<t xml:space="preserve">+1+1</t>`), Equals, true)
+	c.Assert(strings.Contains(sheet, `<t xml:space="preserve">-19</t>`), Equals, true)
+	c.Assert(strings.Contains(sheet, `&#39;`), Equals, false)
+}
+
+// Results passed on for export are read a page at a time, so the store can
+// be used while they are sent.
+func (s *TestSuite) Test35ExportPages(c *C) {
+
+	task, err := s.store().CreateImportTask("http://www.nlaa.ca/pages.html")
+	c.Assert(err, Equals, nil)
+	race, err := s.store().SaveRace(task, generatedRace(2015, 1200))
+	c.Assert(err, Equals, nil)
+
+	positions := []int{}
+
+	err = s.store().EachRaceResultForRace(race.ID, 0, 0, database.ResultFilter{}, func(result database.RaceResult, race database.Race) error {
+		positions = append(positions, result.Position)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.store().GetRace(race.ID)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Second):
+			return errors.New("the store was held while the results were passed on")
+		}
+	})
+	c.Assert(err, Equals, nil)
+
+	c.Assert(len(positions), Equals, 1200)
+	for i := range positions {
+		c.Assert(positions[i], Equals, i+1)
+	}
+}
+
+//xlsxSheet is the xml of the first sheet of the workbook
+func xlsxSheet(c *C, body string) string {
+	workbook, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	c.Assert(err, Equals, nil)
+	for _, f := range workbook.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, err := f.Open()
+			c.Assert(err, Equals, nil)
+			b, err := ioutil.ReadAll(r)
+			c.Assert(err, Equals, nil)
+			return string(b)
+		}
+	}
+	return ""
+}
+
+func (s *TestSuite) Test32Syndication(c *C) {
+
+	first, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	tely, err := s.doImport("http://www.nlaa.ca/02-Tely.html")
+	c.Assert(err, Equals, nil)
+
+	request := gorequest.New()
+
+	//the most recently imported race comes first, summarized by its winners
+	resp, body, _ := request.Get(s.host + "/feed/races.atom").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "application/atom+xml; charset=utf-8")
+
+	var atom api.AtomFeed
+	c.Assert(xml.Unmarshal([]byte(body), &atom), Equals, nil)
+	c.Assert(len(atom.Entries), Equals, 2)
+	c.Assert(atom.Entries[0].Id, Equals, tely.SelfPath)
+	c.Assert(atom.Entries[1].Id, Equals, first.SelfPath)
+	c.Assert(atom.Entries[0].Links[1].Href, Equals, tely.ResultsPath)
+
+	var results api.RaceResults
+	c.Assert(s.doRequest(tely.ResultsPath+"?limit=1", &results), Equals, nil)
+	c.Assert(strings.HasPrefix(atom.Entries[0].Summary, "Won by "+results.Results[0].Name+" in "+results.Results[0].Time+"."), Equals, true)
+	c.Assert(strings.Contains(atom.Entries[0].Summary, "First woman: "), Equals, true)
+
+	etag := resp.Header.Get("ETag")
+	c.Assert(etag, Not(Equals), "")
+	resp, _, _ = request.Get(s.host+"/feed/races.atom").Set("If-None-Match", etag).End()
+	c.Assert(resp.StatusCode, Equals, 304)
+
+	resp, body, _ = request.Get(s.host + "/feed/races.rss").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	var rss api.RssFeed
+	c.Assert(xml.Unmarshal([]byte(body), &rss), Equals, nil)
+	c.Assert(len(rss.Channel.Items), Equals, 2)
+	c.Assert(rss.Channel.Items[0].Link, Equals, tely.SelfPath)
+	c.Assert(rss.Channel.Items[0].Guid.Value, Equals, tely.SelfPath)
+
+	//a race group's feed has only its races
+	var raceGroup api.RaceGroup
+	resp, body, _ = request.Post(s.host + "/feed/racegroup").Send(api.RaceGroupCreate{Name: "Tely 10", Distance: "10", DistanceUnit: "mi"}).End()
+	c.Assert(resp.StatusCode, Equals, 201)
+	json.Unmarshal([]byte(body), &raceGroup)
+	resp, _, _ = request.Post(raceGroup.RacesPath).Send(api.RaceGroupAddRace{RaceId: tely.Id}).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	resp, body, _ = request.Get(raceGroup.SelfPath + "/races.atom").End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	atom = api.AtomFeed{}
+	c.Assert(xml.Unmarshal([]byte(body), &atom), Equals, nil)
+	c.Assert(atom.Title, Equals, "Tely 10 Races")
+	c.Assert(len(atom.Entries), Equals, 1)
+	c.Assert(atom.Entries[0].Id, Equals, tely.SelfPath)
+
+	//the group was changed so the races feed is too
+	resp, _, _ = request.Get(s.host+"/feed/races.atom").Set("If-None-Match", etag).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+
+	//a racer's feed has an entry for each of their results
+	racerFeed := s.host + "/feed/racer/" + results.Results[0].RacerID + "/results.rss"
+	resp, body, _ = request.Get(racerFeed).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	rss = api.RssFeed{}
+	c.Assert(xml.Unmarshal([]byte(body), &rss), Equals, nil)
+	c.Assert(len(rss.Channel.Items), Equals, 1)
+	c.Assert(rss.Channel.Items[0].Title, Equals, results.Results[0].Name+" was 1st in "+tely.Name)
+	c.Assert(rss.Channel.Items[0].Link, Equals, tely.SelfPath)
+
+	resp, _, _ = request.Get(racerFeed).Set("If-None-Match", resp.Header.Get("ETag")).End()
+	c.Assert(resp.StatusCode, Equals, 304)
+
+	resp, _, _ = request.Get(s.host + "/feed/racer/999/results.atom").End()
+	c.Assert(resp.StatusCode, Equals, 404)
+}
+
+// A merge into a racer that was since merged away can still be listed and undone
+func (s *TestSuite) Test33NestedMerges(c *C) {
+
+	first, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	second, err := s.doImport("http://www.nlaa.ca/01-Road-Race.html")
+	c.Assert(err, Equals, nil)
+	summer, err := s.doImport("http://www.nlaa.ca/10-Summer-5K.html")
+	c.Assert(err, Equals, nil)
+
+	var raceResults api.RaceResults
+	c.Assert(s.doRequest(second.ResultsPath, &raceResults), Equals, nil)
+	a := raceResults.Racers[raceResults.Results[11].RacerID]
+	c.Assert(s.doRequest(first.ResultsPath, &raceResults), Equals, nil)
+	b := raceResults.Racers[raceResults.Results[9].RacerID]
+	c.Assert(s.doRequest(summer.ResultsPath, &raceResults), Equals, nil)
+	last := raceResults.Results[len(raceResults.Results)-1]
+	cRacer := raceResults.Racers[last.RacerID]
+
+	merge := func(parent api.Racer, racer api.Racer) api.RacerMergeResult {
+		resp, body, _ := gorequest.New().Post(parent.MergePath).
+			Send(api.RacerMerge{RacerId: racer.Id, Force: true}).
+			End()
+		c.Assert(resp.StatusCode, Equals, 200)
+		var result api.RacerMergeResult
+		c.Assert(json.Unmarshal([]byte(body), &result), Equals, nil)
+		return result
+	}
+
+	//a into b, then b into c
+	c.Assert(merge(b, a).ResultsMoved, Equals, 1)
+	c.Assert(merge(cRacer, b).ResultsMoved, Equals, 2)
+
+	var merges api.RacerMergeHistoryFeed
+	c.Assert(s.doRequest(cRacer.MergesPath, &merges), Equals, nil)
+	c.Assert(len(merges.Merges), Equals, 2)
+	c.Assert(merges.Merges[0].MergedRacer.Id, Equals, b.Id)
+	c.Assert(merges.Merges[1].MergedRacer.Id, Equals, a.Id)
+	c.Assert(merges.Merges[1].Racer.Id, Equals, b.Id)
+
+	//b's merge list is c's
+	var redirected api.RacerMergeHistoryFeed
+	c.Assert(s.doRequest(b.MergesPath, &redirected), Equals, nil)
+	c.Assert(len(redirected.Merges), Equals, 2)
+
+	//undoing the first merge takes a's result back from c
+	resp, body, _ := gorequest.New().Post(merges.Merges[1].UndoPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	var result api.RacerMergeResult
+	c.Assert(json.Unmarshal([]byte(body), &result), Equals, nil)
+	c.Assert(result.MergedRacerId, Equals, a.Id)
+	c.Assert(result.ResultsMoved, Equals, 1)
+
+	c.Assert(s.doRequest(a.ResultsPath, &raceResults), Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 1)
+	c.Assert(raceResults.Results[0].RacerID, Equals, a.Id)
+	c.Assert(s.doRequest(cRacer.ResultsPath, &raceResults), Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 2)
+
+	//undoing the second only moves b's own result
+	resp, body, _ = gorequest.New().Post(merges.Merges[0].UndoPath).End()
+	c.Assert(resp.StatusCode, Equals, 200)
+	result = api.RacerMergeResult{}
+	c.Assert(json.Unmarshal([]byte(body), &result), Equals, nil)
+	c.Assert(result.ResultsMoved, Equals, 1)
+
+	c.Assert(s.doRequest(b.ResultsPath, &raceResults), Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 1)
+	c.Assert(raceResults.Results[0].RacerID, Equals, b.Id)
+	c.Assert(s.doRequest(cRacer.ResultsPath, &raceResults), Equals, nil)
+	c.Assert(len(raceResults.Results), Equals, 1)
+
+	c.Assert(s.doRequest(cRacer.MergesPath, &merges), Equals, nil)
+	c.Assert(len(merges.Merges), Equals, 1)
+	c.Assert(s.doRequest(b.MergesPath, &merges), Equals, nil)
+	c.Assert(len(merges.Merges), Equals, 1)
+	c.Assert(merges.Merges[0].MergedRacer.Id, Equals, a.Id)
+}
+
+func (s *TestSuite) Test34ConcurrentRacerChanges(c *C) {
+
+	race, err := s.doImport("http://www.nlaa.ca/00-Road-Race.html")
+	c.Assert(err, Equals, nil)
+
+	var raceResults api.RaceResults
+	c.Assert(s.doRequest(race.ResultsPath, &raceResults), Equals, nil)
+	racer := raceResults.Racers[raceResults.Results[0].RacerID]
+
+	//changes to the same racer at once wait their turn rather than fail
+	const changes = 5
+	statuses := make(chan int, changes)
+	for i := 0; i < changes; i++ {
+		go func(i int) {
+			resp, _, errs := gorequest.New().Post(racer.AliasesPath).
+				Send(api.RacerAliasCreate{Name: fmt.Sprintf("CONCURRENT RACER %d", i)}).
+				End()
+			if len(errs) > 0 {
+				statuses <- 0
+				return
+			}
+			statuses <- resp.StatusCode
+		}(i)
+	}
+
+	for i := 0; i < changes; i++ {
+		c.Assert(<-statuses, Equals, 201)
+	}
+
+	var aliases api.RacerAliasFeed
+	c.Assert(s.doRequest(racer.AliasesPath, &aliases), Equals, nil)
+	c.Assert(len(aliases.Aliases), Equals, changes)
+}
+
+func (s *TestSuite) doImport(path string) (api.Race, error) {
+
+	var race api.Race
+
+	request := gorequest.New()
+	url := api.DataImport{RaceUrl: path}
+	resp, _, _ := request.Post(fmt.Sprintf("%s/import", s.host)).
+		Send(url).
+		End()
+
+	//initial status code should be 202
+	if resp.StatusCode == 202 {
+
+		//get the location header
+		var taskLocation string
+		taskLocation = resp.Header.Get("Location")
+		var resp gorequest.Response
+		var body string
+
+		//ping the task until it is completed
+		errRetry := retry(5, func() error {
+			resp, body, _ = request.Get(taskLocation).End()
+			if resp.StatusCode == 200 {
+				if resp.Request.URL.String() == taskLocation {
+					return errors.New("Still pending")
+				} else {
+					return nil
+				}
+			} else if resp.StatusCode == 500 {
+				log.Print(body)
+				return errors.New("Import Failed")
+			} else {
+				return errors.New("Unknown status")
+			}
+		})
+		if errRetry != nil {
+			return race, errRetry
+		}
+
+		jsonBlob := []byte(body)
+		err := json.Unmarshal(jsonBlob, &race)
+
+		if err != nil {
+			return race, err
+		}
+
+		return race, nil
+
+	} else {
+		return race, errors.New("nannan")
+	}
+}
+
+//store is the store the service under test reads and writes
+func (s *TestSuite) store() database.Store {
+	if s.s.Store != nil {
+		return s.s.Store
+	}
+	return &s.s.Db
+}
+
+//requireSQL skips a test of what only the sql database does
+func (s *TestSuite) requireSQL(c *C) {
+	if s.s.Store != nil {
+		c.Skip("needs a sql database")
 	}
 }
 
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/url"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -16,6 +19,19 @@ var _ = log.Print
 
 func Test(t *testing.T) { TestingT(t) }
 
+//waitForServer blocks until the service started by the suite accepts requests
+func waitForServer(c *C, host string) {
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(host, "http://"))
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Fatalf("service at %s did not start", host)
+}
+
 type RaceFetcherStub struct {
 }
 
@@ -54,6 +70,26 @@ func (c *RaceFetcherStub) GetRawResults(resultsurl string) ([]byte, error) {
 		absPath, _ := filepath.Abs("test-data/06-Tely.html")
 		byes, _ := ioutil.ReadFile(absPath)
 		return byes, nil
+	} else if u.Path == "/07-Bad-Category.html" {
+		absPath, _ := filepath.Abs("test-data/07-Bad-Category.html")
+		byes, _ := ioutil.ReadFile(absPath)
+		return byes, nil
+	} else if u.Path == "/08-Track-Mile.html" {
+		absPath, _ := filepath.Abs("test-data/08-Track-Mile.html")
+		byes, _ := ioutil.ReadFile(absPath)
+		return byes, nil
+	} else if u.Path == "/09-Masters-Mile.html" {
+		absPath, _ := filepath.Abs("test-data/09-Masters-Mile.html")
+		byes, _ := ioutil.ReadFile(absPath)
+		return byes, nil
+	} else if u.Path == "/10-Summer-5K.html" {
+		absPath, _ := filepath.Abs("test-data/10-Summer-5K.html")
+		byes, _ := ioutil.ReadFile(absPath)
+		return byes, nil
+	} else if u.Path == "/11-Fall-10K.html" {
+		absPath, _ := filepath.Abs("test-data/11-Fall-10K.html")
+		byes, _ := ioutil.ReadFile(absPath)
+		return byes, nil
 	} else {
 		return []byte(`{"raceUrl": "Hello"}`), nil
 	}